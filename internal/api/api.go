@@ -1,13 +1,21 @@
 package api
 
 import (
+	"context"
+	"crypto/rand"
 	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 
 	"tezos-delegation-service/internal/middleware"
+	"tezos-delegation-service/internal/model"
+	"tezos-delegation-service/internal/repository"
 	"tezos-delegation-service/internal/service"
 
 	"github.com/gorilla/mux"
@@ -18,50 +26,163 @@ type DelegationAPIResponse struct {
 	Amount    string `json:"amount"`
 	Delegator string `json:"delegator"`
 	Level     string `json:"level"`
+	Baker     string `json:"baker"`
+	PrevBaker string `json:"prevBaker"`
+	Hash      string `json:"hash"`
+	Block     string `json:"block"`
+	Counter   string `json:"counter"`
+	Status    string `json:"status"`
+	BakerFee  string `json:"bakerFee"`
+	GasUsed   string `json:"gasUsed"`
+	Kind      string `json:"kind"`
+	// Cycle and Protocol are left out until derived.
+	Cycle    string `json:"cycle,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+}
+
+// CurrentDelegationAPIResponse is the delegation state of an address: its baker since the level and
+// timestamp of its last delegation operation. The baker is empty when that was an undelegation.
+type CurrentDelegationAPIResponse struct {
+	Delegator      string `json:"delegator"`
+	Baker          string `json:"baker"`
+	SinceLevel     string `json:"sinceLevel"`
+	SinceTimestamp string `json:"sinceTimestamp"`
+	Amount         string `json:"amount"`
 }
 
 type WrappedResponse struct {
 	Data   []DelegationAPIResponse `json:"data"`
 	Offset int                     `json:"offset"`
 	Limit  int                     `json:"limit"`
+	Sort   model.SortField         `json:"sort"`
+	Order  string                  `json:"order"`
+	// Total is how many delegations the query selects over every page.
+	Total int `json:"total"`
+	// Cursor fetches the next page when passed back as the cursor parameter. It is left out on
+	// the last page.
+	Cursor string `json:"cursor,omitempty"`
 }
 
+// shutdownTimeout bounds how long Start waits for in-flight requests once stopped.
+const shutdownTimeout = 10 * time.Second
+
+// DefaultMaxPageSize is the largest limit a request may set unless configured otherwise.
+const DefaultMaxPageSize = 1000
+
+// DefaultMinYear is the year the Tezos mainnet launched; no delegation is older.
+const DefaultMinYear = 2018
+
 type ApiServer struct {
-	svc service.XtzService
+	svc          service.XtzService
+	pageSize     int
+	maxPageSize  int
+	minYear      int
+	cursorSecret []byte
+}
+
+type Option func(*ApiServer)
+
+// WithPageSize sets how many delegations a page lists.
+func WithPageSize(size int) Option {
+	return func(s *ApiServer) {
+		s.pageSize = size
+	}
+}
+
+// WithMaxPageSize sets the largest limit a request may set.
+func WithMaxPageSize(size int) Option {
+	return func(s *ApiServer) {
+		s.maxPageSize = size
+	}
+}
+
+// WithMinYear sets the oldest year that may be queried.
+func WithMinYear(year int) Option {
+	return func(s *ApiServer) {
+		s.minYear = year
+	}
 }
 
-func NewApiServer(svc service.XtzService) *ApiServer {
-	return &ApiServer{
-		svc: svc,
+// WithCursorSecret sets the secret signing pagination cursors. Replicas serving the same clients
+// must share it; by default a random one is drawn, and cursors only work with the process that
+// issued them.
+func WithCursorSecret(secret []byte) Option {
+	return func(s *ApiServer) {
+		s.cursorSecret = secret
 	}
 }
 
-func (s *ApiServer) Start(port string) {
+// NewApiServer fails when no cursor secret was set and drawing a random one failed.
+func NewApiServer(svc service.XtzService, opts ...Option) (*ApiServer, error) {
+	s := &ApiServer{
+		svc:         svc,
+		pageSize:    model.DefaultPageSize,
+		maxPageSize: DefaultMaxPageSize,
+		minYear:     DefaultMinYear,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.cursorSecret == nil {
+		s.cursorSecret = make([]byte, 32)
+		if _, err := rand.Read(s.cursorSecret); err != nil {
+			return nil, fmt.Errorf("drawing a cursor secret: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// Start serves the API on addr until ctx is done, then waits for in-flight requests to complete.
+func (s *ApiServer) Start(ctx context.Context, addr string) error {
 	router := mux.NewRouter()
 	router.Use(middleware.LoggingMiddleware(middleware.Logger))
 	router.HandleFunc("/xtz/delegations", s.handleGetDelegations).Methods("GET")
+	router.HandleFunc("/xtz/delegators/{address}", s.handleGetDelegator).Methods("GET")
+	router.HandleFunc("/xtz/bakers", s.handleGetBakers).Methods("GET")
+	router.HandleFunc("/xtz/stats/delegations", s.handleGetDelegationStats).Methods("GET")
+	router.HandleFunc("/xtz/bakers/{address}/delegators", s.handleGetBakerDelegators).Methods("GET")
+	router.HandleFunc("/xtz/delegators/{address}/delegations", s.handleGetDelegatorDelegations).Methods("GET")
+	router.Handle("/debug/vars", expvar.Handler()).Methods("GET")
 
 	logger := middleware.Logger
+	server := &http.Server{Addr: addr, Handler: router}
+
+	shutdown := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		defer close(shutdown)
+		logger.Info("Shutting down server", "port", addr)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	})
+	defer stop()
 
-	logger.Info("Server started 🚀🚀🚀", "port", port)
+	logger.Info("Server started 🚀🚀🚀", "port", addr)
 
-	if err := http.ListenAndServe(port, router); err != nil {
-		panic(err)
+	err := server.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		<-shutdown
+		return nil
 	}
+	return err
 }
 
 func (s *ApiServer) handleGetDelegations(w http.ResponseWriter, r *http.Request) {
 	logger := r.Context().Value(middleware.LoggerKey).(*slog.Logger)
 
 	yearParam := r.URL.Query().Get("year")
-	offsetParam := r.URL.Query().Get("offset")
 
+	// without a year, the current one is listed unless a filter bounds the listing
 	year, err := func() (int, error) {
+		if yearParam == "" && hasFilter(r.URL.Query()) {
+			return 0, nil
+		}
 		if yearParam == "" {
 			return time.Now().Year(), nil
 		}
 		parsedYear, parseErr := strconv.Atoi(yearParam)
-		return verifyYear(parsedYear, parseErr)
+		return verifyYear(parsedYear, parseErr, s.minYear)
 	}()
 
 	if err != nil {
@@ -70,6 +191,77 @@ func (s *ApiServer) handleGetDelegations(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	query, ok := s.parseListing(w, r, logger)
+	if !ok {
+		return
+	}
+	query.Year = year
+	if err := parseFilters(r.URL.Query(), &query); err != nil {
+		logger.Error("Invalid filter parameter", "error", err)
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	}
+
+	s.writePage(w, r, logger, query, s.svc.GetDelegations)
+}
+
+// handleGetDelegator returns the current delegation state of an address.
+func (s *ApiServer) handleGetDelegator(w http.ResponseWriter, r *http.Request) {
+	logger := r.Context().Value(middleware.LoggerKey).(*slog.Logger)
+
+	address := mux.Vars(r)["address"]
+	if !validAddress(address, delegatorKinds...) {
+		logger.Error("Invalid address", "address", address)
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": invalidAddress("address", delegatorKinds).Error()})
+		return
+	}
+
+	current, err := s.svc.GetCurrentDelegation(r.Context(), address)
+
+	if errors.Is(err, repository.ErrNotFound) {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "No delegation found for this address"})
+		return
+	}
+	if err != nil {
+		logger.Error("Error fetching the current delegation", "error", err)
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, toCurrentDelegationAPIResponse(current))
+}
+
+// handleGetDelegatorDelegations lists the delegations of an address across every year.
+func (s *ApiServer) handleGetDelegatorDelegations(w http.ResponseWriter, r *http.Request) {
+	logger := r.Context().Value(middleware.LoggerKey).(*slog.Logger)
+
+	address := mux.Vars(r)["address"]
+	if !validAddress(address, delegatorKinds...) {
+		logger.Error("Invalid address", "address", address)
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": invalidAddress("address", delegatorKinds).Error()})
+		return
+	}
+
+	query, ok := s.parseListing(w, r, logger)
+	if !ok {
+		return
+	}
+	query.Delegator = address
+
+	s.writePage(w, r, logger, query, func(ctx context.Context, query model.DelegationQuery) ([]model.Delegation, error) {
+		return s.svc.GetDelegatorDelegations(ctx, address, query)
+	})
+}
+
+// parseListing returns the query set by the parameters shared by every listing: kind and status,
+// offset, limit and sort order. When one is invalid, it answers the request and returns false.
+func (s *ApiServer) parseListing(w http.ResponseWriter, r *http.Request, logger *slog.Logger) (model.DelegationQuery, bool) {
+	offsetParam := r.URL.Query().Get("offset")
+	kind := model.DelegationKind(r.URL.Query().Get("kind"))
+	statusParam := r.URL.Query().Get("status")
+	sortParam := model.SortField(r.URL.Query().Get("sort"))
+	orderParam := r.URL.Query().Get("order")
+
 	offset, err := func() (int, error) {
 		if offsetParam == "" {
 			return 0, nil
@@ -81,10 +273,100 @@ func (s *ApiServer) handleGetDelegations(w http.ResponseWriter, r *http.Request)
 	if err != nil {
 		logger.Error("Invalid offset parameter", "error", err)
 		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "Invalid offset parameter"})
-		return
+		return model.DelegationQuery{}, false
+	}
+
+	limit, err := s.limitParam(r.URL.Query())
+	if err != nil {
+		logger.Error("Invalid limit parameter", "error", err)
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return model.DelegationQuery{}, false
 	}
 
-	entry, err := s.svc.GetDelegations(year, offset)
+	// newest first by default
+	if sortParam == "" {
+		sortParam = model.SortByTimestamp
+	}
+	if !sortParam.Valid() {
+		logger.Error("Invalid sort parameter", "sort", sortParam)
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": (&InvalidParameterError{Name: "sort", Reason: "expected timestamp, amount or level"}).Error()})
+		return model.DelegationQuery{}, false
+	}
+	if orderParam == "" {
+		orderParam = "desc"
+	}
+	if orderParam != "asc" && orderParam != "desc" {
+		logger.Error("Invalid order parameter", "order", orderParam)
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": (&InvalidParameterError{Name: "order", Reason: "expected asc or desc"}).Error()})
+		return model.DelegationQuery{}, false
+	}
+
+	if kind != "" && !kind.Valid() {
+		logger.Error("Invalid kind parameter", "kind", kind)
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "Invalid kind parameter"})
+		return model.DelegationQuery{}, false
+	}
+
+	// only applied operations by default; status=all includes failed, backtracked and skipped ones
+	status, err := func() (string, error) {
+		switch {
+		case statusParam == "":
+			return model.StatusApplied, nil
+		case statusParam == "all":
+			return "", nil
+		case model.ValidStatus(statusParam):
+			return statusParam, nil
+		}
+		return "", fmt.Errorf("invalid status: %s", statusParam)
+	}()
+
+	if err != nil {
+		logger.Error("Invalid status parameter", "error", err)
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "Invalid status parameter"})
+		return model.DelegationQuery{}, false
+	}
+
+	return model.DelegationQuery{
+		Offset:    offset,
+		Limit:     limit,
+		Kind:      kind,
+		Status:    status,
+		Sort:      sortParam,
+		Ascending: orderParam == "asc",
+	}, true
+}
+
+// limitParam returns the page size set by the limit parameter, the configured one by default.
+func (s *ApiServer) limitParam(values url.Values) (int, error) {
+	value := values.Get("limit")
+	if value == "" {
+		return s.pageSize, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > s.maxPageSize {
+		return 0, &InvalidParameterError{Name: "limit", Reason: fmt.Sprintf("expected an integer between 1 and %d", s.maxPageSize)}
+	}
+	return limit, nil
+}
+
+// writePage answers the request with the page of delegations list returns for the query, resumed
+// after the cursor parameter if any, and how many there are over every page.
+func (s *ApiServer) writePage(w http.ResponseWriter, r *http.Request, logger *slog.Logger, query model.DelegationQuery, list func(context.Context, model.DelegationQuery) ([]model.Delegation, error)) {
+	if cursorParam := r.URL.Query().Get("cursor"); cursorParam != "" {
+		if r.URL.Query().Get("offset") != "" {
+			logger.Error("Both cursor and offset parameters")
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "Use either the cursor or the offset parameter"})
+			return
+		}
+		var err error
+		if query.After, err = decodeCursor(s.cursorSecret, query, cursorParam); err != nil {
+			logger.Error("Invalid cursor parameter", "error", err)
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "Invalid cursor parameter"})
+			return
+		}
+	}
+
+	entry, err := list(r.Context(), query)
 
 	if err != nil {
 		logger.Error("Error fetching delegations", "error", err)
@@ -92,17 +374,62 @@ func (s *ApiServer) handleGetDelegations(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	total, err := s.svc.CountDelegations(r.Context(), query)
+
+	if err != nil {
+		logger.Error("Error counting delegations", "error", err)
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{"error": err.Error()})
+		return
+	}
+
 	var apiResults []DelegationAPIResponse
 	for _, d := range entry {
-		apiResults = append(apiResults, DelegationAPIResponse{
-			Timestamp: d.Timestamp,
-			Amount:    strconv.Itoa(d.Amount),
-			Delegator: d.Delegator,
-			Level:     strconv.Itoa(d.Level),
-		})
+		apiResults = append(apiResults, toDelegationAPIResponse(d))
+	}
+
+	order := "desc"
+	if query.Ascending {
+		order = "asc"
+	}
+	response := WrappedResponse{Data: apiResults, Offset: query.Offset, Limit: query.Limit, Sort: query.Sort, Order: order, Total: total}
+	// a full page may be followed by another one
+	if len(entry) == query.Limit {
+		response.Cursor = encodeCursor(s.cursorSecret, query, model.CursorOf(entry[len(entry)-1]))
 	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func toDelegationAPIResponse(d model.Delegation) DelegationAPIResponse {
+	response := DelegationAPIResponse{
+		Timestamp: d.Timestamp,
+		Amount:    strconv.Itoa(d.Amount),
+		Delegator: d.Delegator,
+		Level:     strconv.Itoa(d.Level),
+		Baker:     d.Baker,
+		PrevBaker: d.PrevBaker,
+		Hash:      d.Hash,
+		Block:     d.Block,
+		Counter:   strconv.Itoa(d.Counter),
+		Status:    d.Status,
+		BakerFee:  strconv.Itoa(d.BakerFee),
+		GasUsed:   strconv.Itoa(d.GasUsed),
+		Kind:      string(d.Kind),
+		Protocol:  d.Protocol,
+	}
+	if d.Cycle != nil {
+		response.Cycle = strconv.Itoa(*d.Cycle)
+	}
+	return response
+}
 
-	writeJSON(w, http.StatusOK, WrappedResponse{Data: apiResults, Offset: offset, Limit: 50})
+func toCurrentDelegationAPIResponse(c model.CurrentDelegation) CurrentDelegationAPIResponse {
+	return CurrentDelegationAPIResponse{
+		Delegator:      c.Delegator,
+		Baker:          c.Baker,
+		SinceLevel:     strconv.Itoa(c.Level),
+		SinceTimestamp: c.Timestamp,
+		Amount:         strconv.Itoa(c.Amount),
+	}
 }
 
 func writeJSON(w http.ResponseWriter, s int, v any) error {
@@ -120,12 +447,12 @@ func (e *InvalidYearError) Error() string {
 	return "Invalid year: " + strconv.Itoa(e.Year)
 }
 
-func verifyYear(year int, err error) (int, error) {
+func verifyYear(year int, err error, minYear int) (int, error) {
 	if err != nil {
 		return 0, err
 	}
 
-	if year < 2018 || year > time.Now().Year() {
+	if year < minYear || year > time.Now().Year() {
 		return 0, &InvalidYearError{Year: year}
 	}
 
@@ -4,33 +4,118 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"tezos-delegation-service/internal/middleware"
 	"tezos-delegation-service/internal/model"
+	"tezos-delegation-service/internal/repository"
+	"tezos-delegation-service/internal/service"
 	"tezos-delegation-service/mocks"
 
 	"github.com/gorilla/mux"
 )
 
+// newTestServer creates an ApiServer, failing the test when it cannot.
+func newTestServer(t *testing.T, svc service.XtzService, opts ...Option) *ApiServer {
+	t.Helper()
+	server, err := NewApiServer(svc, opts...)
+	if err != nil {
+		t.Fatalf("Failed to create API server: %v", err)
+	}
+	return server
+}
+
 func TestNewApiServer(t *testing.T) {
 	service := &mocks.MockXtzService{}
-	server := NewApiServer(service)
+	server, err := NewApiServer(service)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
 
 	if server == nil {
 		t.Fatal("Expected server to be created, got nil")
 	}
+	if len(server.cursorSecret) != 32 {
+		t.Errorf("Expected a random 32-byte cursor secret, got %d bytes", len(server.cursorSecret))
+	}
 
 	if server.svc != service {
 		t.Error("Expected service to be set correctly")
 	}
 }
 
+func TestNewApiServer_Options(t *testing.T) {
+	service := &mocks.MockXtzService{}
+	server := newTestServer(t, service, WithPageSize(10), WithMaxPageSize(20), WithMinYear(2020))
+
+	if server.pageSize != 10 {
+		t.Errorf("Expected page size 10, got %d", server.pageSize)
+	}
+	if server.maxPageSize != 20 {
+		t.Errorf("Expected max page size 20, got %d", server.maxPageSize)
+	}
+	if server.minYear != 2020 {
+		t.Errorf("Expected min year 2020, got %d", server.minYear)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/xtz/delegations?year=2019", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.LoggerKey, middleware.Logger))
+	rr := httptest.NewRecorder()
+	server.handleGetDelegations(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for a year below the minimum, got %d", http.StatusBadRequest, rr.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/xtz/delegations?year=2020", nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.LoggerKey, middleware.Logger))
+	rr = httptest.NewRecorder()
+	server.handleGetDelegations(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rr.Code)
+	}
+	if service.Query.Limit != 10 {
+		t.Errorf("Expected the page size to be passed to the service, got %d", service.Query.Limit)
+	}
+}
+
+func TestApiServer_StartStopsWithContext(t *testing.T) {
+	middleware.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	server := newTestServer(t, &mocks.MockXtzService{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Start(ctx, "127.0.0.1:0")
+	}()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected Start to return once the context is done")
+	}
+}
+
+func TestApiServer_StartFailsOnInvalidAddress(t *testing.T) {
+	middleware.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	server := newTestServer(t, &mocks.MockXtzService{})
+
+	if err := server.Start(context.Background(), "invalid:address:port"); err == nil {
+		t.Error("Expected an error for an invalid address")
+	}
+}
+
 func TestVerifyYear(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -85,7 +170,7 @@ func TestVerifyYear(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := verifyYear(tt.year, tt.parseErr)
+			result, err := verifyYear(tt.year, tt.parseErr, DefaultMinYear)
 
 			if tt.expectedErr != nil {
 				if err == nil {
@@ -128,11 +213,12 @@ func TestHandleGetDelegations(t *testing.T) {
 			queryParams: "?year=2023&offset=10",
 			mockDelegations: []model.Delegation{
 				{ID: 1, Timestamp: "2023-01-01T00:00:00Z", Amount: 1000, Delegator: "addr1", Level: 100, Year: 2023},
-				{ID: 2, Timestamp: "2023-01-02T00:00:00Z", Amount: 2000, Delegator: "addr2", Level: 101, Year: 2023},
+				{ID: 2, Timestamp: "2023-01-02T00:00:00Z", Amount: 2000, Delegator: "addr2", Level: 101, Year: 2023,
+					Baker: "tz1baker2", PrevBaker: "tz1baker1", Hash: "oohash", Block: "BLblock", Counter: 42, Status: "applied", BakerFee: 397, GasUsed: 1000, Kind: model.KindRedelegate},
 			},
 			mockErr:        nil,
 			expectedStatus: http.StatusOK,
-			expectedBody:   `{"data":[{"timestamp":"2023-01-01T00:00:00Z","amount":"1000","delegator":"addr1","level":"100"},{"timestamp":"2023-01-02T00:00:00Z","amount":"2000","delegator":"addr2","level":"101"}],"offset":10,"limit":50}`,
+			expectedBody:   `{"data":[{"timestamp":"2023-01-01T00:00:00Z","amount":"1000","delegator":"addr1","level":"100","baker":"","prevBaker":"","hash":"","block":"","counter":"0","status":"","bakerFee":"0","gasUsed":"0","kind":""},{"timestamp":"2023-01-02T00:00:00Z","amount":"2000","delegator":"addr2","level":"101","baker":"tz1baker2","prevBaker":"tz1baker1","hash":"oohash","block":"BLblock","counter":"42","status":"applied","bakerFee":"397","gasUsed":"1000","kind":"redelegate"}],"offset":10,"limit":50,"sort":"timestamp","order":"desc","total":0}`,
 		},
 		{
 			name:        "successful request without parameters (defaults)",
@@ -142,7 +228,7 @@ func TestHandleGetDelegations(t *testing.T) {
 			},
 			mockErr:        nil,
 			expectedStatus: http.StatusOK,
-			expectedBody:   `{"data":[{"timestamp":"2024-01-01T00:00:00Z","amount":"1000","delegator":"addr1","level":"100"}],"offset":0,"limit":50}`,
+			expectedBody:   `{"data":[{"timestamp":"2024-01-01T00:00:00Z","amount":"1000","delegator":"addr1","level":"100","baker":"","prevBaker":"","hash":"","block":"","counter":"0","status":"","bakerFee":"0","gasUsed":"0","kind":""}],"offset":0,"limit":50,"sort":"timestamp","order":"desc","total":0}`,
 		},
 		{
 			name:            "invalid year parameter",
@@ -168,6 +254,22 @@ func TestHandleGetDelegations(t *testing.T) {
 			expectedStatus:  http.StatusBadRequest,
 			expectedBody:    `{"error":"Invalid offset parameter"}`,
 		},
+		{
+			name:            "invalid kind parameter",
+			queryParams:     "?kind=transfer",
+			mockDelegations: nil,
+			mockErr:         nil,
+			expectedStatus:  http.StatusBadRequest,
+			expectedBody:    `{"error":"Invalid kind parameter"}`,
+		},
+		{
+			name:            "invalid status parameter",
+			queryParams:     "?status=pending",
+			mockDelegations: nil,
+			mockErr:         nil,
+			expectedStatus:  http.StatusBadRequest,
+			expectedBody:    `{"error":"Invalid status parameter"}`,
+		},
 		{
 			name:            "service error",
 			queryParams:     "?year=2023",
@@ -182,7 +284,7 @@ func TestHandleGetDelegations(t *testing.T) {
 			mockDelegations: []model.Delegation{},
 			mockErr:         nil,
 			expectedStatus:  http.StatusOK,
-			expectedBody:    `{"data":null,"offset":0,"limit":50}`,
+			expectedBody:    `{"data":null,"offset":0,"limit":50,"sort":"timestamp","order":"desc","total":0}`,
 		},
 	}
 
@@ -193,7 +295,7 @@ func TestHandleGetDelegations(t *testing.T) {
 				Err:         tt.mockErr,
 			}
 
-			server := NewApiServer(mockService)
+			server := newTestServer(t, mockService)
 
 			req := httptest.NewRequest("GET", "/xtz/delegations"+tt.queryParams, nil)
 			w := httptest.NewRecorder()
@@ -235,7 +337,7 @@ func TestHandleGetDelegations_Integration(t *testing.T) {
 		Err: nil,
 	}
 
-	server := NewApiServer(mockService)
+	server := newTestServer(t, mockService)
 
 	router := mux.NewRouter()
 	router.HandleFunc("/xtz/delegations", server.handleGetDelegations).Methods("GET")
@@ -308,7 +410,7 @@ func TestHandleGetDelegations_EdgeCases(t *testing.T) {
 				Err:         nil,
 			}
 
-			server := NewApiServer(mockService)
+			server := newTestServer(t, mockService)
 
 			req := httptest.NewRequest("GET", "/xtz/delegations"+tt.queryParams, nil)
 			w := httptest.NewRecorder()
@@ -327,14 +429,99 @@ func TestHandleGetDelegations_EdgeCases(t *testing.T) {
 	}
 }
 
+func TestHandleGetDelegations_KindFilter(t *testing.T) {
+	kinds := []model.DelegationKind{"", model.KindDelegate, model.KindRedelegate, model.KindUndelegate}
+
+	for _, kind := range kinds {
+		t.Run(string(kind), func(t *testing.T) {
+			mockService := &mocks.MockXtzService{}
+			server := newTestServer(t, mockService)
+
+			req := httptest.NewRequest("GET", "/xtz/delegations?year=2023&offset=5&kind="+string(kind), nil)
+			req = req.WithContext(context.WithValue(req.Context(), middleware.LoggerKey, middleware.Logger))
+			w := httptest.NewRecorder()
+
+			server.handleGetDelegations(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Errorf("Expected status code %d, got %d", http.StatusOK, w.Code)
+			}
+
+			expected := model.DelegationQuery{Year: 2023, Offset: 5, Limit: model.DefaultPageSize, Kind: kind, Status: model.StatusApplied, Sort: model.SortByTimestamp}
+			if mockService.Query != expected {
+				t.Errorf("Expected query %+v, got %+v", expected, mockService.Query)
+			}
+		})
+	}
+}
+
+func TestHandleGetDelegations_StatusFilter(t *testing.T) {
+	tests := []struct {
+		param    string
+		expected string
+	}{
+		{param: "", expected: model.StatusApplied},
+		{param: "all", expected: ""},
+		{param: "applied", expected: model.StatusApplied},
+		{param: "failed", expected: model.StatusFailed},
+		{param: "backtracked", expected: model.StatusBacktracked},
+		{param: "skipped", expected: model.StatusSkipped},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.param, func(t *testing.T) {
+			mockService := &mocks.MockXtzService{}
+			server := newTestServer(t, mockService)
+
+			req := httptest.NewRequest("GET", "/xtz/delegations?year=2023&status="+tt.param, nil)
+			req = req.WithContext(context.WithValue(req.Context(), middleware.LoggerKey, middleware.Logger))
+			w := httptest.NewRecorder()
+
+			server.handleGetDelegations(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Errorf("Expected status code %d, got %d", http.StatusOK, w.Code)
+			}
+			if mockService.Query.Status != tt.expected {
+				t.Errorf("Expected status filter %q, got %q", tt.expected, mockService.Query.Status)
+			}
+		})
+	}
+}
+
+func TestHandleGetDelegations_PropagatesRequestContext(t *testing.T) {
+	mockService := &mocks.MockXtzService{}
+	server := newTestServer(t, mockService)
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), middleware.LoggerKey, middleware.Logger))
+	req := httptest.NewRequest("GET", "/xtz/delegations?year=2023", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+
+	server.handleGetDelegations(w, req)
+	cancel()
+
+	if mockService.Ctx == nil {
+		t.Fatal("Expected the request context to reach the service")
+	}
+
+	select {
+	case <-mockService.Ctx.Done():
+		// the client going away cancels the service call
+	default:
+		t.Error("Expected the service context to be cancelled with the request")
+	}
+}
+
 func TestWrappedResponse_Serialization(t *testing.T) {
 	response := WrappedResponse{
 		Data: []DelegationAPIResponse{
 			{Timestamp: "2023-01-01T00:00:00Z", Amount: "1000", Delegator: "addr1", Level: "100"},
-			{Timestamp: "2023-01-02T00:00:00Z", Amount: "2000", Delegator: "addr2", Level: "101"},
+			{Timestamp: "2023-01-02T00:00:00Z", Amount: "2000", Delegator: "addr2", Level: "101", Baker: "tz1baker", Status: "applied", Counter: "42", BakerFee: "397", GasUsed: "1000", Kind: "delegate"},
 		},
 		Offset: 10,
 		Limit:  50,
+		Sort:   model.SortByTimestamp,
+		Order:  "desc",
 	}
 
 	data, err := json.Marshal(response)
@@ -342,8 +529,249 @@ func TestWrappedResponse_Serialization(t *testing.T) {
 		t.Errorf("Failed to marshal WrappedResponse: %v", err)
 	}
 
-	expected := `{"data":[{"timestamp":"2023-01-01T00:00:00Z","amount":"1000","delegator":"addr1","level":"100"},{"timestamp":"2023-01-02T00:00:00Z","amount":"2000","delegator":"addr2","level":"101"}],"offset":10,"limit":50}`
+	expected := `{"data":[{"timestamp":"2023-01-01T00:00:00Z","amount":"1000","delegator":"addr1","level":"100","baker":"","prevBaker":"","hash":"","block":"","counter":"","status":"","bakerFee":"","gasUsed":"","kind":""},{"timestamp":"2023-01-02T00:00:00Z","amount":"2000","delegator":"addr2","level":"101","baker":"tz1baker","prevBaker":"","hash":"","block":"","counter":"42","status":"applied","bakerFee":"397","gasUsed":"1000","kind":"delegate"}],"offset":10,"limit":50,"sort":"timestamp","order":"desc","total":0}`
 	if string(data) != expected {
 		t.Errorf("Expected JSON %s, got %s", expected, string(data))
 	}
 }
+
+func TestToDelegationAPIResponse_Cycle(t *testing.T) {
+	cycle := 0
+	derived := toDelegationAPIResponse(model.Delegation{Level: 100, Cycle: &cycle, Protocol: "PtCJ7pwoxe8JasnHY8YonnLYjcVHmhiARPJvqcC6VfHT5s8k8sY"})
+	if derived.Cycle != "0" || derived.Protocol != "PtCJ7pwoxe8JasnHY8YonnLYjcVHmhiARPJvqcC6VfHT5s8k8sY" {
+		t.Errorf("Expected cycle 0 of the first protocol, got %+v", derived)
+	}
+
+	data, err := json.Marshal(toDelegationAPIResponse(model.Delegation{Level: 100}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "cycle") || strings.Contains(string(data), "protocol") {
+		t.Errorf("Expected the cycle and protocol to be left out until derived, got %s", data)
+	}
+}
+
+func TestHandleGetDelegations_Cursor(t *testing.T) {
+	secret := []byte("0123456789abcdef")
+	get := func(server *ApiServer, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/xtz/delegations?year=2023"+query, nil)
+		req = req.WithContext(context.WithValue(req.Context(), middleware.LoggerKey, middleware.Logger))
+		w := httptest.NewRecorder()
+		server.handleGetDelegations(w, req)
+		return w
+	}
+
+	// a full page returns the cursor of its last delegation
+	mockService := &mocks.MockXtzService{Delegations: []model.Delegation{
+		{ID: 9, Timestamp: "2023-01-03T00:00:00Z", Year: 2023},
+		{ID: 7, Timestamp: "2023-01-02T00:00:00Z", Year: 2023},
+	}}
+	server := newTestServer(t, mockService, WithPageSize(2), WithCursorSecret(secret))
+
+	w := get(server, "")
+	var response WrappedResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Cursor != encodeCursor(secret, model.DelegationQuery{Sort: model.SortByTimestamp}, model.Cursor{Timestamp: "2023-01-02T00:00:00Z", ID: 7}) {
+		t.Fatalf("Expected the cursor of delegation 7, got %q", response.Cursor)
+	}
+
+	// passed back, it resumes the listing after that delegation
+	if w := get(server, "&cursor="+response.Cursor); w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if expected := (model.Cursor{Timestamp: "2023-01-02T00:00:00Z", ID: 7}); mockService.Query.After != expected {
+		t.Errorf("Expected the query to resume after %+v, got %+v", expected, mockService.Query.After)
+	}
+
+	// the last page has no cursor
+	mockService.Delegations = mockService.Delegations[:1]
+	w = get(server, "&cursor="+response.Cursor)
+	if strings.Contains(w.Body.String(), `"cursor"`) {
+		t.Errorf("Expected no cursor on the last page, got %s", w.Body.String())
+	}
+
+	// cursors are only accepted from the server that signed them, and not together with an offset
+	other := newTestServer(t, mockService, WithPageSize(2))
+	for _, query := range []string{"&cursor=" + response.Cursor, "&cursor=garbage"} {
+		if w := get(other, query); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "Invalid cursor parameter") {
+			t.Errorf("Expected %q to be rejected, got %d %s", query, w.Code, w.Body.String())
+		}
+	}
+	if w := get(server, "&offset=2&cursor="+response.Cursor); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected a cursor with an offset to be rejected, got %d", w.Code)
+	}
+
+	// nor with another sort order than the listing it was issued for
+	for _, query := range []string{"&sort=amount", "&order=asc"} {
+		if w := get(server, query+"&cursor="+response.Cursor); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected the cursor to be rejected with %q, got %d", query, w.Code)
+		}
+	}
+}
+
+func TestHandleGetDelegations_LimitAndSort(t *testing.T) {
+	get := func(query string) (*mocks.MockXtzService, *httptest.ResponseRecorder) {
+		mockService := &mocks.MockXtzService{Total: 1234}
+		req := httptest.NewRequest("GET", "/xtz/delegations?year=2023"+query, nil)
+		req = req.WithContext(context.WithValue(req.Context(), middleware.LoggerKey, middleware.Logger))
+		w := httptest.NewRecorder()
+		newTestServer(t, mockService, WithMaxPageSize(100)).handleGetDelegations(w, req)
+		return mockService, w
+	}
+
+	tests := []struct {
+		query     string
+		limit     int
+		sort      model.SortField
+		ascending bool
+		order     string
+	}{
+		{query: "", limit: model.DefaultPageSize, sort: model.SortByTimestamp, order: "desc"},
+		{query: "&limit=100", limit: 100, sort: model.SortByTimestamp, order: "desc"},
+		{query: "&sort=amount", limit: model.DefaultPageSize, sort: model.SortByAmount, order: "desc"},
+		{query: "&limit=1&sort=level&order=asc", limit: 1, sort: model.SortByLevel, ascending: true, order: "asc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			mockService, w := get(tt.query)
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+			if mockService.Query.Limit != tt.limit || mockService.Query.Sort != tt.sort || mockService.Query.Ascending != tt.ascending {
+				t.Errorf("Expected limit %d sorted by %s ascending %v, got %+v", tt.limit, tt.sort, tt.ascending, mockService.Query)
+			}
+
+			// the response echoes the effective values
+			var response WrappedResponse
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Limit != tt.limit || response.Sort != tt.sort || response.Order != tt.order || response.Total != 1234 {
+				t.Errorf("Expected limit %d, sort %s, order %s and total 1234, got %+v", tt.limit, tt.sort, tt.order, response)
+			}
+		})
+	}
+
+	invalid := map[string]string{
+		"&limit=0":      "Invalid limit parameter: expected an integer between 1 and 100",
+		"&limit=101":    "Invalid limit parameter: expected an integer between 1 and 100",
+		"&limit=all":    "Invalid limit parameter: expected an integer between 1 and 100",
+		"&sort=baker":   "Invalid sort parameter: expected timestamp, amount or level",
+		"&order=newest": "Invalid order parameter: expected asc or desc",
+	}
+	for query, expected := range invalid {
+		mockService, w := get(query)
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), expected) {
+			t.Errorf("Expected %q to be rejected with %q, got %d %s", query, expected, w.Code, w.Body.String())
+		}
+		if mockService.Ctx != nil {
+			t.Errorf("Expected %q not to reach the service", query)
+		}
+	}
+}
+
+func TestHandleGetDelegatorDelegations(t *testing.T) {
+	get := func(mockService *mocks.MockXtzService, address, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/xtz/delegators/"+address+"/delegations"+query, nil)
+		req = req.WithContext(context.WithValue(req.Context(), middleware.LoggerKey, middleware.Logger))
+		req = mux.SetURLVars(req, map[string]string{"address": address})
+		w := httptest.NewRecorder()
+		newTestServer(t, mockService, WithPageSize(2), WithCursorSecret([]byte("0123456789abcdef"))).handleGetDelegatorDelegations(w, req)
+		return w
+	}
+
+	// the timeline spans every year
+	mockService := &mocks.MockXtzService{Total: 3, Delegations: []model.Delegation{
+		{ID: 9, Timestamp: "2024-01-03T00:00:00Z", Year: 2024, Delegator: "tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb"},
+		{ID: 7, Timestamp: "2021-06-02T00:00:00Z", Year: 2021, Delegator: "tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb"},
+	}}
+	w := get(mockService, "tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb", "?status=all")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if mockService.Delegator != "tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb" {
+		t.Errorf("Expected the address to be passed to the service, got %q", mockService.Delegator)
+	}
+	expected := model.DelegationQuery{Limit: 2, Sort: model.SortByTimestamp, Delegator: "tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb"}
+	if mockService.Query != expected {
+		t.Errorf("Expected query %+v, got %+v", expected, mockService.Query)
+	}
+
+	var response WrappedResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Data) != 2 || response.Total != 3 || response.Cursor == "" {
+		t.Fatalf("Expected a full page of 3 delegations with a cursor, got %+v", response)
+	}
+
+	// the cursor resumes the timeline
+	if w := get(mockService, "tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb", "?cursor="+response.Cursor); w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if mockService.Query.After.ID != 7 {
+		t.Errorf("Expected the timeline to resume after delegation 7, got %+v", mockService.Query.After)
+	}
+
+	// malformed addresses and invalid parameters are rejected before reaching the service
+	for address, query := range map[string]string{
+		"tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjc": "",
+		"tz1short":                             "",
+		"BLockHash":                            "",
+		"tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb": "?limit=0",
+	} {
+		mockService := &mocks.MockXtzService{}
+		if w := get(mockService, address, query); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected %s%s to be rejected, got %d", address, query, w.Code)
+		}
+		if mockService.Ctx != nil {
+			t.Errorf("Expected %s%s not to reach the service", address, query)
+		}
+	}
+}
+
+func TestHandleGetDelegator(t *testing.T) {
+	get := func(mockService *mocks.MockXtzService, address string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/xtz/delegators/"+address, nil)
+		req = req.WithContext(context.WithValue(req.Context(), middleware.LoggerKey, middleware.Logger))
+		req = mux.SetURLVars(req, map[string]string{"address": address})
+		w := httptest.NewRecorder()
+		newTestServer(t, mockService).handleGetDelegator(w, req)
+		return w
+	}
+
+	mockService := &mocks.MockXtzService{Current: model.CurrentDelegation{
+		Delegator: "tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb", Baker: "tz3RDC3Jdn4j15J7bBHZd29EUee9gVB1CxD9",
+		Level: 2338084, Timestamp: "2022-05-05T06:29:14Z", Amount: 25079312620, DelegationID: 218103808,
+	}}
+	w := get(mockService, "tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if mockService.Delegator != "tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb" {
+		t.Errorf("Expected the address to be passed to the service, got %q", mockService.Delegator)
+	}
+	expected := `{"delegator":"tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb","baker":"tz3RDC3Jdn4j15J7bBHZd29EUee9gVB1CxD9","sinceLevel":"2338084","sinceTimestamp":"2022-05-05T06:29:14Z","amount":"25079312620"}`
+	if body := strings.TrimSpace(w.Body.String()); body != expected {
+		t.Errorf("Expected body %s, got %s", expected, body)
+	}
+
+	// an address without any applied delegation is not found
+	if w := get(&mocks.MockXtzService{Err: repository.ErrNotFound}, "tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+	}
+	if w := get(&mocks.MockXtzService{Err: errors.New("database is locked")}, "tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb"); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected status %d, got %d", http.StatusUnprocessableEntity, w.Code)
+	}
+
+	mockService = &mocks.MockXtzService{}
+	if w := get(mockService, "tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjc"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an invalid checksum to be rejected, got %d", w.Code)
+	}
+	if mockService.Ctx != nil {
+		t.Error("Expected the service not to be called")
+	}
+}
@@ -0,0 +1,170 @@
+package api
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"tezos-delegation-service/internal/middleware"
+	"tezos-delegation-service/internal/model"
+
+	"github.com/gorilla/mux"
+)
+
+// bakerKinds are the addresses that may bake: implicit accounts only.
+var bakerKinds = []string{"tz1", "tz2", "tz3", "tz4"}
+
+type BakerAPIResponse struct {
+	Rank       int    `json:"rank"`
+	Baker      string `json:"baker"`
+	Delegators int    `json:"delegators"`
+	Amount     string `json:"amount"`
+}
+
+type BakersResponse struct {
+	Data []BakerAPIResponse `json:"data"`
+	// Level and Date echo the point in time the ranking was computed at, left out for the current one.
+	Level  int             `json:"level,omitempty"`
+	Date   string          `json:"date,omitempty"`
+	Sort   model.BakerSort `json:"sort"`
+	Offset int             `json:"offset"`
+	Limit  int             `json:"limit"`
+}
+
+type BakerDelegatorsResponse struct {
+	Baker      string                         `json:"baker"`
+	Delegators int                            `json:"delegators"`
+	Amount     string                         `json:"amount"`
+	Data       []CurrentDelegationAPIResponse `json:"data"`
+	Offset     int                            `json:"offset"`
+	Limit      int                            `json:"limit"`
+}
+
+// handleGetBakers ranks the bakers by delegators or delegated amount, now or at a level or date.
+func (s *ApiServer) handleGetBakers(w http.ResponseWriter, r *http.Request) {
+	logger := r.Context().Value(middleware.LoggerKey).(*slog.Logger)
+	values := r.URL.Query()
+
+	query, err := func() (model.BakerQuery, error) {
+		var query model.BakerQuery
+		var err error
+		if query.Level, err = countParam(values, "level"); err != nil {
+			return query, err
+		}
+		if query.Timestamp, err = dateParam(values, "date"); err != nil {
+			return query, err
+		}
+		if query.Level != 0 && query.Timestamp != "" {
+			return query, &InvalidParameterError{Name: "date", Reason: "cannot be combined with level"}
+		}
+
+		query.Sort = model.BakerSort(values.Get("sort"))
+		if query.Sort == "" {
+			query.Sort = model.RankByDelegators
+		}
+		if !query.Sort.Valid() {
+			return query, &InvalidParameterError{Name: "sort", Reason: "expected delegators or amount"}
+		}
+
+		if query.Offset, err = countParam(values, "offset"); err != nil {
+			return query, err
+		}
+		query.Limit, err = s.limitParam(values)
+		return query, err
+	}()
+
+	if err != nil {
+		logger.Error("Invalid baker ranking parameter", "error", err)
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	}
+
+	stats, err := s.svc.GetBakers(r.Context(), query)
+
+	if err != nil {
+		logger.Error("Error ranking bakers", "error", err)
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{"error": err.Error()})
+		return
+	}
+
+	response := BakersResponse{Level: query.Level, Date: query.Timestamp, Sort: query.Sort, Offset: query.Offset, Limit: query.Limit}
+	for i, baker := range stats {
+		response.Data = append(response.Data, BakerAPIResponse{
+			Rank:       query.Offset + i + 1,
+			Baker:      baker.Baker,
+			Delegators: baker.Delegators,
+			Amount:     strconv.Itoa(baker.Amount),
+		})
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// handleGetBakerDelegators lists the addresses currently delegating to a baker, largest amount first.
+func (s *ApiServer) handleGetBakerDelegators(w http.ResponseWriter, r *http.Request) {
+	logger := r.Context().Value(middleware.LoggerKey).(*slog.Logger)
+	values := r.URL.Query()
+
+	address := mux.Vars(r)["address"]
+	if !validAddress(address, bakerKinds...) {
+		logger.Error("Invalid address", "address", address)
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": invalidAddress("address", bakerKinds).Error()})
+		return
+	}
+
+	offset, err := countParam(values, "offset")
+	if err != nil {
+		logger.Error("Invalid offset parameter", "error", err)
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	}
+	limit, err := s.limitParam(values)
+	if err != nil {
+		logger.Error("Invalid limit parameter", "error", err)
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	}
+
+	stats, err := s.svc.GetBaker(r.Context(), address)
+
+	if err != nil {
+		logger.Error("Error fetching baker", "error", err)
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{"error": err.Error()})
+		return
+	}
+
+	delegators, err := s.svc.GetBakerDelegators(r.Context(), address, limit, offset)
+
+	if err != nil {
+		logger.Error("Error fetching baker delegators", "error", err)
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{"error": err.Error()})
+		return
+	}
+
+	response := BakerDelegatorsResponse{
+		Baker:      address,
+		Delegators: stats.Delegators,
+		Amount:     strconv.Itoa(stats.Amount),
+		Offset:     offset,
+		Limit:      limit,
+	}
+	for _, d := range delegators {
+		response.Data = append(response.Data, toCurrentDelegationAPIResponse(d))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// dateParam returns the instant in the UTC form delegations are stored in. Unlike a timestampParam
+// bound, it includes the delegations of its second: a fraction is rounded down.
+func dateParam(values url.Values, name string) (string, error) {
+	value := values.Get(name)
+	if value == "" {
+		return "", nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return "", &InvalidParameterError{Name: name, Reason: "expected an RFC3339 timestamp, e.g. 2024-01-01T00:00:00Z"}
+	}
+	return t.Truncate(time.Second).UTC().Format(time.RFC3339), nil
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"tezos-delegation-service/internal/middleware"
+	"tezos-delegation-service/internal/model"
+	"tezos-delegation-service/mocks"
+
+	"github.com/gorilla/mux"
+)
+
+func TestHandleGetBakers(t *testing.T) {
+	get := func(mockService *mocks.MockXtzService, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/xtz/bakers"+query, nil)
+		req = req.WithContext(context.WithValue(req.Context(), middleware.LoggerKey, middleware.Logger))
+		w := httptest.NewRecorder()
+		newTestServer(t, mockService).handleGetBakers(w, req)
+		return w
+	}
+
+	tests := []struct {
+		query    string
+		expected model.BakerQuery
+	}{
+		{query: "", expected: model.BakerQuery{Sort: model.RankByDelegators, Limit: model.DefaultPageSize}},
+		{query: "?level=3000000&sort=amount", expected: model.BakerQuery{Level: 3000000, Sort: model.RankByAmount, Limit: model.DefaultPageSize}},
+		{query: "?date=2023-06-01T02:00:00.750%2B02:00", expected: model.BakerQuery{Timestamp: "2023-06-01T00:00:00Z", Sort: model.RankByDelegators, Limit: model.DefaultPageSize}},
+		{query: "?offset=20&limit=10", expected: model.BakerQuery{Sort: model.RankByDelegators, Offset: 20, Limit: 10}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			mockService := &mocks.MockXtzService{}
+			if w := get(mockService, tt.query); w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+			if mockService.BakerQuery != tt.expected {
+				t.Errorf("Expected query %+v, got %+v", tt.expected, mockService.BakerQuery)
+			}
+		})
+	}
+
+	// bakers are ranked from the offset on
+	mockService := &mocks.MockXtzService{Bakers: []model.BakerStats{
+		{Baker: "tz1big", Delegators: 3, Amount: 350},
+		{Baker: "tz1rich", Delegators: 1, Amount: 5000},
+	}}
+	w := get(mockService, "?level=120&offset=4")
+	expected := `{"data":[{"rank":5,"baker":"tz1big","delegators":3,"amount":"350"},{"rank":6,"baker":"tz1rich","delegators":1,"amount":"5000"}],"level":120,"sort":"delegators","offset":4,"limit":50}`
+	if body := strings.TrimSpace(w.Body.String()); body != expected {
+		t.Errorf("Expected body %s, got %s", expected, body)
+	}
+
+	for query, message := range map[string]string{
+		"?level=-1":                          "Invalid level parameter: expected a non-negative integer",
+		"?date=yesterday":                    "Invalid date parameter: expected an RFC3339 timestamp, e.g. 2024-01-01T00:00:00Z",
+		"?level=1&date=2024-01-01T00:00:00Z": "Invalid date parameter: cannot be combined with level",
+		"?sort=level":                        "Invalid sort parameter: expected delegators or amount",
+		"?limit=5000":                        "Invalid limit parameter: expected an integer between 1 and 1000",
+	} {
+		mockService := &mocks.MockXtzService{}
+		w := get(mockService, query)
+		var body map[string]string
+		json.NewDecoder(w.Body).Decode(&body)
+		if w.Code != http.StatusBadRequest || body["error"] != message {
+			t.Errorf("Expected %s to be rejected with %q, got %d %q", query, message, w.Code, body["error"])
+		}
+		if mockService.Ctx != nil {
+			t.Errorf("Expected %s not to reach the service", query)
+		}
+	}
+}
+
+func TestHandleGetBakerDelegators(t *testing.T) {
+	get := func(mockService *mocks.MockXtzService, address, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/xtz/bakers/"+address+"/delegators"+query, nil)
+		req = req.WithContext(context.WithValue(req.Context(), middleware.LoggerKey, middleware.Logger))
+		req = mux.SetURLVars(req, map[string]string{"address": address})
+		w := httptest.NewRecorder()
+		newTestServer(t, mockService).handleGetBakerDelegators(w, req)
+		return w
+	}
+
+	mockService := &mocks.MockXtzService{
+		Bakers: []model.BakerStats{{Baker: baker, Delegators: 2, Amount: 5150}},
+		Delegators: []model.CurrentDelegation{
+			{Delegator: "tz1c", Baker: baker, Level: 110, Timestamp: "2023-01-02T00:00:00Z", Amount: 5000},
+			{Delegator: "tz1a", Baker: baker, Level: 130, Timestamp: "2023-01-04T00:00:00Z", Amount: 150},
+		},
+	}
+	w := get(mockService, baker, "?limit=2&offset=2")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if mockService.Baker != baker || mockService.Query.Limit != 2 || mockService.Query.Offset != 2 {
+		t.Errorf("Expected the baker, limit and offset to be passed to the service, got %s %+v", mockService.Baker, mockService.Query)
+	}
+	expected := `{"baker":"` + baker + `","delegators":2,"amount":"5150","data":[` +
+		`{"delegator":"tz1c","baker":"` + baker + `","sinceLevel":"110","sinceTimestamp":"2023-01-02T00:00:00Z","amount":"5000"},` +
+		`{"delegator":"tz1a","baker":"` + baker + `","sinceLevel":"130","sinceTimestamp":"2023-01-04T00:00:00Z","amount":"150"}],"offset":2,"limit":2}`
+	if body := strings.TrimSpace(w.Body.String()); body != expected {
+		t.Errorf("Expected body %s, got %s", expected, body)
+	}
+
+	// contracts cannot bake
+	for _, address := range []string{"KT1PWx2mnDueood7fEmfbBDKx1D9BAnnXitn", "tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjc"} {
+		mockService := &mocks.MockXtzService{}
+		if w := get(mockService, address, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected %s to be rejected, got %d", address, w.Code)
+		}
+		if mockService.Ctx != nil {
+			t.Errorf("Expected %s not to reach the service", address)
+		}
+	}
+}
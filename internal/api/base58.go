@@ -0,0 +1,71 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"strings"
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// addressPrefixes are the bytes preceding the 20 byte key or contract hash of each kind of address
+// once base58 decoded, which make them start with their kind.
+var addressPrefixes = map[string][]byte{
+	"tz1": {6, 161, 159},
+	"tz2": {6, 161, 161},
+	"tz3": {6, 161, 164},
+	"tz4": {6, 161, 166},
+	"KT1": {2, 90, 121},
+}
+
+// operationHashPrefix precedes the 32 byte hash of an operation, which makes it start with o.
+var operationHashPrefix = []byte{5, 116}
+
+// validAddress tells whether value is an address of one of the kinds, with a valid checksum.
+func validAddress(value string, kinds ...string) bool {
+	for _, kind := range kinds {
+		if strings.HasPrefix(value, kind) && base58Check(value, addressPrefixes[kind], 20) {
+			return true
+		}
+	}
+	return false
+}
+
+// base58Check tells whether value is the base58check encoding of prefix followed by size bytes:
+// they must be followed by the first 4 bytes of their double SHA-256.
+func base58Check(value string, prefix []byte, size int) bool {
+	decoded, ok := base58Decode(value)
+	if !ok || len(decoded) != len(prefix)+size+4 || !bytes.HasPrefix(decoded, prefix) {
+		return false
+	}
+	data, checksum := decoded[:len(decoded)-4], decoded[len(decoded)-4:]
+	first := sha256.Sum256(data)
+	second := sha256.Sum256(first[:])
+	return bytes.Equal(checksum, second[:4])
+}
+
+func base58Decode(value string) ([]byte, bool) {
+	var decoded []byte
+	for _, c := range value {
+		carry := strings.IndexRune(base58Alphabet, c)
+		if carry < 0 {
+			return nil, false
+		}
+		for i := len(decoded) - 1; i >= 0; i-- {
+			carry += int(decoded[i]) * 58
+			decoded[i] = byte(carry)
+			carry >>= 8
+		}
+		for ; carry > 0; carry >>= 8 {
+			decoded = append([]byte{byte(carry)}, decoded...)
+		}
+	}
+	// each leading 1 encodes a leading zero byte
+	for _, c := range value {
+		if c != '1' {
+			break
+		}
+		decoded = append([]byte{0}, decoded...)
+	}
+	return decoded, true
+}
@@ -0,0 +1,33 @@
+package api
+
+import "testing"
+
+func TestValidAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		valid   bool
+	}{
+		{address: "tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb", valid: true},
+		{address: "tz2BFTyPeYRzxd5aiBchbXN3WCZhx7BqbMBq", valid: true},
+		{address: "tz3RDC3Jdn4j15J7bBHZd29EUee9gVB1CxD9", valid: true},
+		{address: "KT1PWx2mnDueood7fEmfbBDKx1D9BAnnXitn", valid: true},
+		{address: "tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjc", valid: false}, // checksum
+		{address: "tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcj", valid: false},  // truncated
+		{address: "tz1VSUr8wwNhLAzempoch5d6hLRiTh8CjcjO", valid: false}, // not base58
+		{address: "tz4VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb", valid: false}, // prefix
+		{address: "", valid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			if valid := validAddress(tt.address, "tz1", "tz2", "tz3", "tz4", "KT1"); valid != tt.valid {
+				t.Errorf("Expected valid %v, got %v", tt.valid, valid)
+			}
+		})
+	}
+
+	// the kind must be one of those accepted
+	if validAddress("KT1PWx2mnDueood7fEmfbBDKx1D9BAnnXitn", "tz1", "tz2", "tz3", "tz4") {
+		t.Error("Expected a contract not to be accepted as an implicit account")
+	}
+}
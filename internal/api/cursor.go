@@ -0,0 +1,86 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"tezos-delegation-service/internal/model"
+)
+
+// ErrInvalidCursor is returned for a cursor that was not issued with the server's secret, or not
+// for the sort order it is passed with.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursorPayload is a position in a listing and the order of that listing. Cursors issued before
+// the sort order could be chosen have none, and resume a listing by timestamp, newest first.
+type cursorPayload struct {
+	Sort      model.SortField `json:"s,omitempty"`
+	Ascending bool            `json:"asc,omitempty"`
+	Timestamp string          `json:"t"`
+	Amount    int             `json:"a,omitempty"`
+	Level     int             `json:"l,omitempty"`
+	ID        int             `json:"i"`
+}
+
+// encodeCursor returns the opaque cursor resuming a listing in the order of query after c: its
+// position, followed by an HMAC of it so that clients cannot forge positions.
+func encodeCursor(secret []byte, query model.DelegationQuery, c model.Cursor) string {
+	payload, _ := json.Marshal(cursorPayload{
+		Sort:      query.Sort,
+		Ascending: query.Ascending,
+		Timestamp: c.Timestamp,
+		Amount:    c.Amount,
+		Level:     c.Level,
+		ID:        c.ID,
+	})
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(sign(secret, payload))
+}
+
+// decodeCursor returns the position of the cursor, which must have been issued for a listing in
+// the order of query.
+func decodeCursor(secret []byte, query model.DelegationQuery, cursor string) (model.Cursor, error) {
+	encodedPayload, encodedMAC, ok := strings.Cut(cursor, ".")
+	if !ok {
+		return model.Cursor{}, ErrInvalidCursor
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return model.Cursor{}, ErrInvalidCursor
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+	if err != nil || !hmac.Equal(mac, sign(secret, payload)) {
+		return model.Cursor{}, ErrInvalidCursor
+	}
+
+	var p cursorPayload
+	if err := json.Unmarshal(payload, &p); err != nil || p.ID <= 0 || p.Amount < 0 || p.Level < 0 {
+		return model.Cursor{}, ErrInvalidCursor
+	}
+	if _, err := time.Parse(time.RFC3339, p.Timestamp); err != nil {
+		return model.Cursor{}, ErrInvalidCursor
+	}
+	if sortField(p.Sort) != sortField(query.Sort) || p.Ascending != query.Ascending {
+		return model.Cursor{}, fmt.Errorf("%w: issued for another sort order", ErrInvalidCursor)
+	}
+	return model.Cursor{Timestamp: p.Timestamp, Amount: p.Amount, Level: p.Level, ID: p.ID}, nil
+}
+
+// sortField returns the field a listing is sorted by, the timestamp when none is set.
+func sortField(f model.SortField) model.SortField {
+	if f == "" {
+		return model.SortByTimestamp
+	}
+	return f
+}
+
+func sign(secret []byte, payload []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
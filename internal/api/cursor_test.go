@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+
+	"tezos-delegation-service/internal/model"
+)
+
+func TestCursor_RoundTrip(t *testing.T) {
+	secret := []byte("0123456789abcdef")
+	position := model.Cursor{Timestamp: "2023-05-01T12:00:00Z", Amount: 1500, Level: 3700000, ID: 123456}
+
+	for _, query := range []model.DelegationQuery{
+		{Sort: model.SortByTimestamp},
+		{Sort: model.SortByAmount, Ascending: true},
+		{Sort: model.SortByLevel},
+	} {
+		cursor := encodeCursor(secret, query, position)
+		decoded, err := decodeCursor(secret, query, cursor)
+		if err != nil {
+			t.Fatalf("Expected the cursor to decode, got %v", err)
+		}
+		if decoded != position {
+			t.Errorf("Expected %+v, got %+v", position, decoded)
+		}
+	}
+}
+
+func TestCursor_WithoutSortOrder(t *testing.T) {
+	// cursors issued before the sort order could be chosen resume the listing by timestamp
+	secret := []byte("0123456789abcdef")
+	payload := []byte(`{"t":"2023-05-01T12:00:00Z","i":7}`)
+	cursor := base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(sign(secret, payload))
+
+	decoded, err := decodeCursor(secret, model.DelegationQuery{Sort: model.SortByTimestamp}, cursor)
+	if err != nil {
+		t.Fatalf("Expected the cursor to decode, got %v", err)
+	}
+	if expected := (model.Cursor{Timestamp: "2023-05-01T12:00:00Z", ID: 7}); decoded != expected {
+		t.Errorf("Expected %+v, got %+v", expected, decoded)
+	}
+	if _, err := decodeCursor(secret, model.DelegationQuery{Sort: model.SortByTimestamp, Ascending: true}, cursor); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("Expected ErrInvalidCursor in ascending order, got %v", err)
+	}
+}
+
+func TestCursor_Rejected(t *testing.T) {
+	secret := []byte("0123456789abcdef")
+	query := model.DelegationQuery{Sort: model.SortByTimestamp}
+	cursor := encodeCursor(secret, query, model.Cursor{Timestamp: "2023-05-01T12:00:00Z", ID: 123456})
+	payload, mac, _ := strings.Cut(cursor, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"t":"2023-05-01T12:00:00Z","i":1}`))
+
+	tests := []struct {
+		name   string
+		secret []byte
+		query  model.DelegationQuery
+		cursor string
+	}{
+		{name: "other secret", secret: []byte("another secret!!"), cursor: cursor},
+		{name: "forged position", secret: secret, cursor: forged + "." + mac},
+		{name: "missing signature", secret: secret, cursor: payload},
+		{name: "not base64", secret: secret, cursor: "not a cursor.at all"},
+		{name: "signed invalid position", secret: secret, cursor: encodeCursor(secret, query, model.Cursor{Timestamp: "yesterday", ID: 1})},
+		{name: "signed zero position", secret: secret, cursor: encodeCursor(secret, query, model.Cursor{})},
+		{name: "other sort field", secret: secret, query: model.DelegationQuery{Sort: model.SortByAmount}, cursor: cursor},
+		{name: "other direction", secret: secret, query: model.DelegationQuery{Sort: model.SortByTimestamp, Ascending: true}, cursor: cursor},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.query == (model.DelegationQuery{}) {
+				tt.query = query
+			}
+			if _, err := decodeCursor(tt.secret, tt.query, tt.cursor); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("Expected ErrInvalidCursor, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"tezos-delegation-service/internal/model"
+)
+
+// delegatorKinds are the addresses that may delegate: implicit accounts and originated contracts.
+var delegatorKinds = []string{"tz1", "tz2", "tz3", "tz4", "KT1"}
+
+// filterParams are the query parameters narrowing the listing down beyond a year.
+var filterParams = []string{"delegator", "baker", "hash", "min_level", "max_level", "from", "to", "min_amount", "max_amount", "cycle"}
+
+// InvalidParameterError is a query parameter that cannot be served, and why.
+type InvalidParameterError struct {
+	Name   string
+	Reason string
+}
+
+func (e *InvalidParameterError) Error() string {
+	return fmt.Sprintf("Invalid %s parameter: %s", e.Name, e.Reason)
+}
+
+// hasFilter tells whether the request narrows the listing down with a filter parameter, in which
+// case the year is no longer needed to bound it.
+func hasFilter(values url.Values) bool {
+	for _, name := range filterParams {
+		if values.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// parseFilters validates the filter parameters and sets them on the query.
+func parseFilters(values url.Values, query *model.DelegationQuery) error {
+	var err error
+	if query.Delegator, err = addressParam(values, "delegator", delegatorKinds...); err != nil {
+		return err
+	}
+	if query.Baker, err = addressParam(values, "baker", "tz1", "tz2", "tz3", "tz4"); err != nil {
+		return err
+	}
+	if query.Hash = values.Get("hash"); query.Hash != "" && !base58Check(query.Hash, operationHashPrefix, 32) {
+		return &InvalidParameterError{Name: "hash", Reason: "expected an operation hash"}
+	}
+
+	if query.MinLevel, err = countParam(values, "min_level"); err != nil {
+		return err
+	}
+	if query.MaxLevel, err = countParam(values, "max_level"); err != nil {
+		return err
+	}
+	if query.MinLevel != 0 && query.MaxLevel != 0 && query.MinLevel > query.MaxLevel {
+		return &InvalidParameterError{Name: "min_level", Reason: "must not exceed max_level"}
+	}
+
+	if values.Get("cycle") != "" {
+		cycle, err := countParam(values, "cycle")
+		if err != nil {
+			return err
+		}
+		query.Cycle = &cycle
+	}
+
+	if query.MinAmount, err = countParam(values, "min_amount"); err != nil {
+		return err
+	}
+	if query.MaxAmount, err = countParam(values, "max_amount"); err != nil {
+		return err
+	}
+	if query.MinAmount != 0 && query.MaxAmount != 0 && query.MinAmount > query.MaxAmount {
+		return &InvalidParameterError{Name: "min_amount", Reason: "must not exceed max_amount"}
+	}
+
+	if query.From, err = timestampParam(values, "from"); err != nil {
+		return err
+	}
+	if query.To, err = timestampParam(values, "to"); err != nil {
+		return err
+	}
+	if query.From != "" && query.To != "" && query.From >= query.To {
+		return &InvalidParameterError{Name: "from", Reason: "must be before to"}
+	}
+	return nil
+}
+
+func addressParam(values url.Values, name string, kinds ...string) (string, error) {
+	value := values.Get(name)
+	if value == "" {
+		return "", nil
+	}
+	if !validAddress(value, kinds...) {
+		return "", invalidAddress(name, kinds)
+	}
+	return value, nil
+}
+
+func invalidAddress(name string, kinds []string) error {
+	return &InvalidParameterError{Name: name, Reason: fmt.Sprintf("expected a %s address", strings.Join(kinds, ", "))}
+}
+
+func countParam(values url.Values, name string) (int, error) {
+	value := values.Get(name)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, &InvalidParameterError{Name: name, Reason: "expected a non-negative integer"}
+	}
+	return n, nil
+}
+
+// timestampParam returns the timestamp in the UTC form delegations are stored in, so that they can
+// be compared. Stored timestamps are whole seconds: a fraction is rounded up to the next one, which
+// leaves the same delegations on each side of the bound.
+func timestampParam(values url.Values, name string) (string, error) {
+	value := values.Get(name)
+	if value == "" {
+		return "", nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return "", &InvalidParameterError{Name: name, Reason: "expected an RFC3339 timestamp, e.g. 2024-01-01T00:00:00Z"}
+	}
+	if seconds := t.Truncate(time.Second); !seconds.Equal(t) {
+		t = seconds.Add(time.Second)
+	}
+	return t.UTC().Format(time.RFC3339), nil
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"tezos-delegation-service/internal/middleware"
+	"tezos-delegation-service/internal/model"
+	"tezos-delegation-service/mocks"
+)
+
+const (
+	delegator = "tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb"
+	baker     = "tz3RDC3Jdn4j15J7bBHZd29EUee9gVB1CxD9"
+	opHash    = "ooGMvQdYhsCsZg1BGHdAz7BioXYkn6dNHr5cAR4mFfiUBoxfAgz"
+)
+
+func TestParseFilters(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		expected model.DelegationQuery
+	}{
+		{
+			name:     "none",
+			query:    "",
+			expected: model.DelegationQuery{},
+		},
+		{
+			name:     "addresses and hash",
+			query:    "delegator=" + delegator + "&baker=" + baker + "&hash=" + opHash,
+			expected: model.DelegationQuery{Delegator: delegator, Baker: baker, Hash: opHash},
+		},
+		{
+			name:     "originated delegator",
+			query:    "delegator=KT1PWx2mnDueood7fEmfbBDKx1D9BAnnXitn",
+			expected: model.DelegationQuery{Delegator: "KT1PWx2mnDueood7fEmfbBDKx1D9BAnnXitn"},
+		},
+		{
+			name:     "ranges",
+			query:    "min_level=100&max_level=200&min_amount=0&max_amount=5000",
+			expected: model.DelegationQuery{MinLevel: 100, MaxLevel: 200, MaxAmount: 5000},
+		},
+		{
+			name:     "cycle zero",
+			query:    "cycle=0",
+			expected: model.DelegationQuery{Cycle: new(int)},
+		},
+		{
+			name:     "timestamps normalised to UTC",
+			query:    "from=2023-06-01T02:00:00%2B02:00&to=2023-07-01T00:00:00.250Z",
+			expected: model.DelegationQuery{From: "2023-06-01T00:00:00Z", To: "2023-07-01T00:00:01Z"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var query model.DelegationQuery
+			if err := parseFilters(values, &query); err != nil {
+				t.Fatalf("Expected the filters to be valid, got %v", err)
+			}
+			if !reflect.DeepEqual(query, tt.expected) {
+				t.Errorf("Expected %+v, got %+v", tt.expected, query)
+			}
+		})
+	}
+}
+
+func TestParseFilters_Invalid(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{query: "delegator=tz1short", expected: "Invalid delegator parameter: expected a tz1, tz2, tz3, tz4, KT1 address"},
+		{query: "delegator=tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcj0", expected: "Invalid delegator parameter: expected a tz1, tz2, tz3, tz4, KT1 address"},
+		{query: "delegator=tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjc", expected: "Invalid delegator parameter: expected a tz1, tz2, tz3, tz4, KT1 address"},
+		{query: "baker=KT1PWx2mnDueood7fEmfbBDKx1D9BAnnXitn", expected: "Invalid baker parameter: expected a tz1, tz2, tz3, tz4 address"},
+		{query: "hash=BLockHash", expected: "Invalid hash parameter: expected an operation hash"},
+		{query: "hash=ooGMvQdYhsCsZg1BGHdAz7BioXYkn6dNHr5cAR4mFfiUBoxfAgZ", expected: "Invalid hash parameter: expected an operation hash"},
+		{query: "min_level=-1", expected: "Invalid min_level parameter: expected a non-negative integer"},
+		{query: "max_level=ten", expected: "Invalid max_level parameter: expected a non-negative integer"},
+		{query: "min_level=200&max_level=100", expected: "Invalid min_level parameter: must not exceed max_level"},
+		{query: "cycle=-1", expected: "Invalid cycle parameter: expected a non-negative integer"},
+		{query: "min_amount=1.5", expected: "Invalid min_amount parameter: expected a non-negative integer"},
+		{query: "min_amount=10&max_amount=5", expected: "Invalid min_amount parameter: must not exceed max_amount"},
+		{query: "from=2023-01-01", expected: "Invalid from parameter: expected an RFC3339 timestamp, e.g. 2024-01-01T00:00:00Z"},
+		{query: "from=2023-02-01T00:00:00Z&to=2023-01-01T00:00:00Z", expected: "Invalid from parameter: must be before to"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = parseFilters(values, &model.DelegationQuery{})
+			if err == nil || err.Error() != tt.expected {
+				t.Errorf("Expected %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestHandleGetDelegations_Filters(t *testing.T) {
+	get := func(query string) (*mocks.MockXtzService, *httptest.ResponseRecorder) {
+		mockService := &mocks.MockXtzService{}
+		req := httptest.NewRequest("GET", "/xtz/delegations?"+query, nil)
+		req = req.WithContext(context.WithValue(req.Context(), middleware.LoggerKey, middleware.Logger))
+		w := httptest.NewRecorder()
+		newTestServer(t, mockService).handleGetDelegations(w, req)
+		return mockService, w
+	}
+
+	// a filter lifts the default year, an explicit one still applies
+	mockService, w := get("delegator=" + delegator + "&min_level=100")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	expected := model.DelegationQuery{Limit: model.DefaultPageSize, Status: model.StatusApplied, Delegator: delegator, MinLevel: 100, Sort: model.SortByTimestamp}
+	if mockService.Query != expected {
+		t.Errorf("Expected query %+v, got %+v", expected, mockService.Query)
+	}
+
+	mockService, _ = get("cycle=750")
+	if mockService.Query.Year != 0 || mockService.Query.Cycle == nil || *mockService.Query.Cycle != 750 {
+		t.Errorf("Expected the cycle filter without a year, got %+v", mockService.Query)
+	}
+
+	mockService, _ = get("year=2023&baker=" + baker)
+	if mockService.Query.Year != 2023 || mockService.Query.Baker != baker {
+		t.Errorf("Expected the year and baker filters, got %+v", mockService.Query)
+	}
+
+	// invalid filters are rejected before reaching the service
+	mockService, w = get("to=tomorrow")
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	var body map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body["error"] != "Invalid to parameter: expected an RFC3339 timestamp, e.g. 2024-01-01T00:00:00Z" {
+		t.Errorf("Expected the invalid parameter to be explained, got %q", body["error"])
+	}
+	if mockService.Ctx != nil {
+		t.Error("Expected the service not to be called")
+	}
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tezos-delegation-service/internal/middleware"
+	"tezos-delegation-service/internal/model"
+	"tezos-delegation-service/internal/repository"
+)
+
+type DelegationStatsAPIResponse struct {
+	Bucket        string `json:"bucket"`
+	Delegations   int    `json:"delegations"`
+	TotalAmount   string `json:"totalAmount"`
+	MedianAmount  string `json:"medianAmount"`
+	Delegators    int    `json:"delegators"`
+	Undelegations int    `json:"undelegations"`
+}
+
+type StatsResponse struct {
+	Interval model.StatsInterval          `json:"interval"`
+	From     string                       `json:"from"`
+	To       string                       `json:"to"`
+	Data     []DelegationStatsAPIResponse `json:"data"`
+}
+
+// handleGetDelegationStats aggregates the applied delegations by day, week, month or cycle.
+func (s *ApiServer) handleGetDelegationStats(w http.ResponseWriter, r *http.Request) {
+	logger := r.Context().Value(middleware.LoggerKey).(*slog.Logger)
+	values := r.URL.Query()
+
+	query, err := func() (model.StatsQuery, error) {
+		query := model.StatsQuery{Interval: model.StatsInterval(values.Get("interval"))}
+		if query.Interval == "" {
+			query.Interval = model.IntervalDay
+		}
+		if !query.Interval.Valid() {
+			return query, &InvalidParameterError{Name: "interval", Reason: "expected day, week, month or cycle"}
+		}
+
+		var err error
+		if query.From, err = timestampParam(values, "from"); err != nil {
+			return query, err
+		}
+		if query.To, err = timestampParam(values, "to"); err != nil {
+			return query, err
+		}
+		from, to := defaultStatsRange(query.Interval, time.Now())
+		if query.To == "" {
+			query.To = to
+		}
+		if query.From == "" {
+			query.From = from
+		}
+		if query.From >= query.To {
+			return query, &InvalidParameterError{Name: "from", Reason: "must be before to"}
+		}
+		return query, nil
+	}()
+
+	if err != nil {
+		logger.Error("Invalid stats parameter", "error", err)
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	}
+
+	stats, err := s.svc.GetDelegationStats(r.Context(), query)
+
+	if errors.Is(err, repository.ErrCyclesNotDerived) {
+		err = &InvalidParameterError{Name: "interval", Reason: "cycles are not known yet, the protocols of the chain have not been fetched from TzKT"}
+		logger.Error("Invalid stats parameter", "error", err)
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logger.Error("Error aggregating delegations", "error", err)
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{"error": err.Error()})
+		return
+	}
+
+	response := StatsResponse{Interval: query.Interval, From: query.From, To: query.To, Data: []DelegationStatsAPIResponse{}}
+	for _, bucket := range stats {
+		response.Data = append(response.Data, DelegationStatsAPIResponse{
+			Bucket:        bucket.Bucket,
+			Delegations:   bucket.Delegations,
+			TotalAmount:   strconv.Itoa(bucket.TotalAmount),
+			MedianAmount:  strconv.Itoa(int(math.Round(bucket.MedianAmount))),
+			Delegators:    bucket.Delegators,
+			Undelegations: bucket.Undelegations,
+		})
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// defaultStatsRange returns the range aggregated when the request sets no bound: the last 30 days,
+// 12 weeks or 12 months up to the end of the current day. Bounds fall on bucket starts, so that the
+// first bucket is whole and the range only changes once a day. Cycles do not start on a date, they
+// are aggregated over the last 30 days, the first one partly.
+func defaultStatsRange(interval model.StatsInterval, now time.Time) (string, string) {
+	today := now.UTC().Truncate(24 * time.Hour)
+	var from time.Time
+	switch interval {
+	case model.IntervalWeek:
+		monday := today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+		from = monday.AddDate(0, 0, -7*11)
+	case model.IntervalMonth:
+		from = time.Date(today.Year(), today.Month()-11, 1, 0, 0, 0, 0, time.UTC)
+	default:
+		from = today.AddDate(0, 0, -29)
+	}
+	return from.Format(time.RFC3339), today.AddDate(0, 0, 1).Format(time.RFC3339)
+}
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"tezos-delegation-service/internal/middleware"
+	"tezos-delegation-service/internal/model"
+	"tezos-delegation-service/internal/repository"
+	"tezos-delegation-service/mocks"
+)
+
+func TestHandleGetDelegationStats(t *testing.T) {
+	get := func(mockService *mocks.MockXtzService, query string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/xtz/stats/delegations"+query, nil)
+		req = req.WithContext(context.WithValue(req.Context(), middleware.LoggerKey, middleware.Logger))
+		w := httptest.NewRecorder()
+		newTestServer(t, mockService).handleGetDelegationStats(w, req)
+		return w
+	}
+
+	mockService := &mocks.MockXtzService{Stats: []model.DelegationStats{
+		{Bucket: "2024-01-01", Delegations: 4, TotalAmount: 1000, MedianAmount: 250.5, Delegators: 4, Undelegations: 1},
+	}}
+	w := get(mockService, "?interval=week&from=2024-01-01T00:00:00Z&to=2024-02-01T00:00:00Z")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	expected := model.StatsQuery{Interval: model.IntervalWeek, From: "2024-01-01T00:00:00Z", To: "2024-02-01T00:00:00Z"}
+	if mockService.StatsQuery != expected {
+		t.Errorf("Expected query %+v, got %+v", expected, mockService.StatsQuery)
+	}
+	body := `{"interval":"week","from":"2024-01-01T00:00:00Z","to":"2024-02-01T00:00:00Z","data":[{"bucket":"2024-01-01","delegations":4,"totalAmount":"1000","medianAmount":"251","delegators":4,"undelegations":1}]}`
+	if got := strings.TrimSpace(w.Body.String()); got != body {
+		t.Errorf("Expected body %s, got %s", body, got)
+	}
+
+	mockService = &mocks.MockXtzService{Stats: []model.DelegationStats{{Bucket: "750", Delegations: 1, TotalAmount: 10, MedianAmount: 10, Delegators: 1}}}
+	w = get(mockService, "?interval=cycle&from=2024-01-01T00:00:00Z&to=2024-02-01T00:00:00Z")
+	if mockService.StatsQuery.Interval != model.IntervalCycle || !strings.Contains(w.Body.String(), `"bucket":"750"`) {
+		t.Errorf("Expected cycle buckets, got %+v and %s", mockService.StatsQuery, w.Body.String())
+	}
+
+	// cycles cannot be bucketed before the protocols are known
+	mockService = &mocks.MockXtzService{Err: repository.ErrCyclesNotDerived}
+	w = get(mockService, "?interval=cycle")
+	var failure map[string]string
+	json.NewDecoder(w.Body).Decode(&failure)
+	if message := "Invalid interval parameter: cycles are not known yet, the protocols of the chain have not been fetched from TzKT"; w.Code != http.StatusBadRequest || failure["error"] != message {
+		t.Errorf("Expected cycle buckets to be rejected with %q, got %d %q", message, w.Code, failure["error"])
+	}
+
+	// without bounds, the last days up to the end of today are aggregated
+	mockService = &mocks.MockXtzService{}
+	w = get(mockService, "")
+	tomorrow := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, 1)
+	if mockService.StatsQuery.Interval != model.IntervalDay || mockService.StatsQuery.To != tomorrow.Format(time.RFC3339) {
+		t.Errorf("Expected daily stats up to %s, got %+v", tomorrow, mockService.StatsQuery)
+	}
+	if got := strings.TrimSpace(w.Body.String()); !strings.HasSuffix(got, `"data":[]}`) {
+		t.Errorf("Expected an empty list of buckets, got %s", got)
+	}
+
+	for query, message := range map[string]string{
+		"?interval=hour": "Invalid interval parameter: expected day, week, month or cycle",
+		"?from=monday":   "Invalid from parameter: expected an RFC3339 timestamp, e.g. 2024-01-01T00:00:00Z",
+		"?from=2024-02-01T00:00:00Z&to=2024-01-01T00:00:00Z": "Invalid from parameter: must be before to",
+	} {
+		mockService := &mocks.MockXtzService{}
+		w := get(mockService, query)
+		var body map[string]string
+		json.NewDecoder(w.Body).Decode(&body)
+		if w.Code != http.StatusBadRequest || body["error"] != message {
+			t.Errorf("Expected %s to be rejected with %q, got %d %q", query, message, w.Code, body["error"])
+		}
+		if mockService.Ctx != nil {
+			t.Errorf("Expected %s not to reach the service", query)
+		}
+	}
+}
+
+func TestDefaultStatsRange(t *testing.T) {
+	// a Wednesday
+	now := time.Date(2024, 3, 13, 15, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		interval model.StatsInterval
+		from     string
+	}{
+		{interval: model.IntervalDay, from: "2024-02-13T00:00:00Z"},
+		{interval: model.IntervalWeek, from: "2023-12-25T00:00:00Z"},
+		{interval: model.IntervalMonth, from: "2023-04-01T00:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.interval), func(t *testing.T) {
+			from, to := defaultStatsRange(tt.interval, now)
+			if from != tt.from || to != "2024-03-14T00:00:00Z" {
+				t.Errorf("Expected %s to 2024-03-14T00:00:00Z, got %s to %s", tt.from, from, to)
+			}
+		})
+	}
+}
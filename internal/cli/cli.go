@@ -0,0 +1,196 @@
+// Package cli implements the subcommands of the xtz binary. Every subcommand reads the settings
+// of the config package and exits with one of the Exit* codes.
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"tezos-delegation-service/internal/config"
+	"tezos-delegation-service/internal/middleware"
+	"tezos-delegation-service/internal/repository"
+	"tezos-delegation-service/internal/service"
+	"tezos-delegation-service/internal/transport"
+)
+
+// Exit codes returned by Run, for scripts.
+const (
+	ExitOK = 0
+	// ExitFailure reports that the command failed, e.g. the source or the database errored.
+	ExitFailure = 1
+	// ExitUsage reports an unknown command, invalid flags or an invalid configuration.
+	ExitUsage = 2
+	// ExitVerifyFailed reports that verify found the database inconsistent with the source.
+	ExitVerifyFailed = 3
+	// ExitInterrupted reports that a finite command was stopped by a signal before completing.
+	ExitInterrupted = 130
+)
+
+// defaultCommand runs when no subcommand is given, as the binary did before subcommands existed.
+const defaultCommand = "run"
+
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, env *env, args []string) int
+}
+
+func commands() []command {
+	return []command{
+		{name: "run", usage: "follow the chain and serve the API (default)", run: runRun},
+		{name: "serve", usage: "serve the API from a read-only database", run: runServe},
+		{name: "ingest", usage: "follow the chain without serving the API", run: runIngest},
+		{name: "backfill", usage: "store every delegation between two timestamps, then exit", run: runBackfill},
+		{name: "sync-once", usage: "store the delegations published since the last sync, then exit", run: runSyncOnce},
+		{name: "verify", usage: "check the database against the source", run: runVerify},
+		{name: "export", usage: "write stored delegations out", run: runExport},
+		{name: "migrate", usage: "create or upgrade the database schema, then exit", run: runMigrate},
+	}
+}
+
+// env is what every command writes to.
+type env struct {
+	stdout io.Writer
+	stderr io.Writer
+	logger *slog.Logger
+}
+
+// Run runs the subcommand named by the first argument, passing it the remaining ones, and returns
+// the process exit code. Logs go to stderr so that stdout only holds command output, e.g. exports.
+func Run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	e := &env{
+		stdout: stdout,
+		stderr: stderr,
+		logger: slog.New(slog.NewJSONHandler(stderr, nil)),
+	}
+	middleware.Logger = e.logger
+
+	name := defaultCommand
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	if name == "help" {
+		usage(stdout)
+		return ExitOK
+	}
+	for _, cmd := range commands() {
+		if cmd.name == name {
+			return cmd.run(ctx, e, args)
+		}
+	}
+
+	fmt.Fprintf(stderr, "unknown command %q\n\n", name)
+	usage(stderr)
+	return ExitUsage
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: xtz [command] [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands() {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.usage)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'xtz <command> -h' for the flags of a command.")
+}
+
+// flagSet returns the flag set of a command, on which it registers its own flags before calling
+// load.
+func (e *env) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("xtz "+name, flag.ContinueOnError)
+	fs.SetOutput(e.stderr)
+	return fs
+}
+
+// load parses the flags and loads the configuration. It returns the exit code to stop with when
+// they are invalid, or when help was asked for.
+func (e *env) load(fs *flag.FlagSet, args []string) (*config.Config, int, bool) {
+	cfg, err := config.Load(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil, ExitOK, false
+	}
+	if err != nil {
+		e.logger.Error("❌❌❌ Invalid configuration", "error", err)
+		return nil, ExitUsage, false
+	}
+	return cfg, ExitOK, true
+}
+
+// newClient builds the delegation source described by the configuration. Every client shares the
+// returned limiter so that they together stay within TzKT limits. Failing over between TzKT and a
+// node resumes the cursors of the checkpoints in repo from their level.
+func newClient(cfg *config.Config, repo repository.DelegationRepository, logger *slog.Logger) (transport.TzktClientInterface, *transport.RateLimiter) {
+	limiter := transport.NewRateLimiter(cfg.Source.RequestsPerSecond, cfg.Source.Burst)
+	primary := config.Fallback{Kind: "tzkt", URL: cfg.Source.DelegationsURL()}
+	if cfg.Source.Kind == "node" {
+		primary = config.Fallback{Kind: "node", URL: cfg.Source.NodeURL}
+	}
+
+	var sources []transport.TzktClientInterface
+	for _, source := range append([]config.Fallback{primary}, cfg.Source.Fallbacks()...) {
+		if source.Kind == "node" {
+			sources = append(sources, transport.NewNodeClient(source.URL))
+		} else {
+			sources = append(sources, transport.NewTzktClient(source.URL, transport.WithRateLimiter(limiter)))
+		}
+	}
+
+	if len(sources) == 1 && cfg.Source.CrossCheck == "" {
+		return sources[0], limiter
+	}
+
+	opts := []transport.MultiOption{
+		transport.WithMaxLag(cfg.Source.MaxLag),
+		transport.WithCursorResolver(service.CheckpointCursors(repo)),
+	}
+	if cfg.Source.CrossCheck != "" {
+		// the configuration was validated, kind is tzkt or node
+		kind, url, _ := strings.Cut(cfg.Source.CrossCheck, "=")
+		var verifier transport.LevelSource = transport.NewNodeClient(url)
+		if kind == "tzkt" {
+			verifier = transport.NewTzktClient(url, transport.WithRateLimiter(limiter))
+		}
+		opts = append(opts, transport.WithCrossCheck(verifier, cfg.Source.CrossCheckEvery))
+	}
+	return transport.NewMultiSource(logger, sources, opts...), limiter
+}
+
+// serviceOptions configures the service from the configuration. Cycles are derived from the
+// protocols listed by TzKT, whatever the delegations are read from, within the shared limiter.
+func serviceOptions(cfg *config.Config, limiter *transport.RateLimiter) []service.Option {
+	return []service.Option{
+		service.WithIngestionPolicy(service.IngestionPolicy(cfg.Poller.Ingest)),
+		service.WithCacheTTL(cfg.Poller.Interval),
+		service.WithProtocolSource(transport.NewTzktClient(cfg.Source.TzktURL, transport.WithRateLimiter(limiter))),
+	}
+}
+
+func pollerOptions(cfg *config.Config) []service.PollerOption {
+	opts := []service.PollerOption{
+		service.WithInterval(cfg.Poller.Interval),
+		service.WithConfirmationDepth(cfg.Poller.Confirmations),
+	}
+	if cfg.Poller.Stream {
+		opts = append(opts, service.WithStream(transport.NewTzktStream(cfg.Source.StreamURL)))
+	}
+	return opts
+}
+
+// exitCode maps the error a finite command stopped with to its exit code.
+func exitCode(ctx context.Context, err error) int {
+	switch {
+	case err == nil:
+		return ExitOK
+	case ctx.Err() != nil:
+		return ExitInterrupted
+	default:
+		return ExitFailure
+	}
+}
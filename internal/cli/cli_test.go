@@ -0,0 +1,303 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tezos-delegation-service/internal/model"
+	"tezos-delegation-service/internal/repository"
+	"tezos-delegation-service/internal/transport"
+)
+
+func run(t *testing.T, args ...string) (int, string, string) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := Run(context.Background(), args, &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+// seed creates a database holding the delegations, synced up to the last one.
+func seed(t *testing.T, delegations ...model.Delegation) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "delegations.db")
+	db, err := repository.NewDatabase(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkpoint := model.SyncCheckpoint{Name: model.HeadCheckpoint}
+	if len(delegations) > 0 {
+		last := delegations[len(delegations)-1]
+		checkpoint.LastID, checkpoint.Level, checkpoint.Timestamp = last.ID, last.Level, last.Timestamp
+	}
+	if err := db.SaveBatchWithCheckpoint(context.Background(), delegations, checkpoint); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// fakeTzkt serves the TzKT endpoints used by the commands: the head, blocks, protocols and
+// delegations. A single protocol runs from level 1 on, in cycles of 128 levels.
+func fakeTzkt(t *testing.T, head int, blocks []transport.BlockResponse, delegations []transport.DelegationResponse) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/head":
+			json.NewEncoder(w).Encode(transport.HeadResponse{Level: head})
+		case "/v1/blocks":
+			json.NewEncoder(w).Encode(blocks)
+		case "/v1/protocols":
+			w.Write([]byte(`[{"code":1,"hash":"PtTest","firstLevel":1,"firstCycle":0,"firstCycleLevel":1,"constants":{"blocksPerCycle":128}}]`))
+		case "/v1/operations/delegations":
+			if r.URL.Query().Get("id.gt") != "" && r.URL.Query().Get("id.gt") != "0" {
+				json.NewEncoder(w).Encode([]transport.DelegationResponse{})
+				return
+			}
+			json.NewEncoder(w).Encode(delegations)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server.URL + "/v1/operations/delegations"
+}
+
+var stored = []model.Delegation{
+	{ID: 1, Timestamp: "2023-01-01T00:00:00Z", Year: 2023, Level: 100, Delegator: "tz1a", Baker: "tz1baker", Block: "BLa", Status: model.StatusApplied, Kind: model.KindDelegate},
+	{ID: 2, Timestamp: "2024-01-01T00:00:00Z", Year: 2024, Level: 200, Delegator: "tz1b", Block: "BLb", Status: model.StatusFailed, Kind: model.KindUndelegate},
+}
+
+func TestRun_Usage(t *testing.T) {
+	code, stdout, _ := run(t, "help")
+	if code != ExitOK || !strings.Contains(stdout, "sync-once") {
+		t.Errorf("Expected the usage on stdout, got %d %q", code, stdout)
+	}
+
+	code, _, stderr := run(t, "bogus")
+	if code != ExitUsage || !strings.Contains(stderr, `unknown command "bogus"`) {
+		t.Errorf("Expected a usage error, got %d %q", code, stderr)
+	}
+}
+
+func TestRun_InvalidConfiguration(t *testing.T) {
+	for _, args := range [][]string{
+		{"migrate", "-page-size", "0"},
+		{"migrate", "-unknown-flag"},
+		{"-source", "rpc"},
+		{"backfill", "-from", "yesterday"},
+		{"backfill", "-from-level", "-1"},
+		{"export", "-format", "xml"},
+		{"verify", "-depth", "-1"},
+	} {
+		if code, _, _ := run(t, args...); code != ExitUsage {
+			t.Errorf("Expected %v to exit with %d, got %d", args, ExitUsage, code)
+		}
+	}
+}
+
+func TestRun_Help(t *testing.T) {
+	code, _, stderr := run(t, "export", "-h")
+	if code != ExitOK || !strings.Contains(stderr, "-format") {
+		t.Errorf("Expected the flags of export, got %d %q", code, stderr)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "delegations.db")
+
+	if code, _, stderr := run(t, "migrate", "-db", path); code != ExitOK {
+		t.Fatalf("Expected migrate to succeed, got %d: %s", code, stderr)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("Expected the database to be created, got %v", err)
+	}
+
+	if code, _, _ := run(t, "migrate", "-db", filepath.Join(path, "not-a-directory", "x.db")); code != ExitFailure {
+		t.Errorf("Expected migrate to fail, got %d", code)
+	}
+}
+
+func TestMigrate_DryRunAndDown(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "delegations.db")
+
+	code, stdout, _ := run(t, "migrate", "-db", path, "-dry-run")
+	if code != ExitOK || !strings.Contains(stdout, "up    0001_initial") || !strings.Contains(stdout, "CREATE TABLE") {
+		t.Fatalf("Expected the planned migrations with their SQL, got %d %q", code, stdout)
+	}
+	if _, err := repository.OpenReadOnly(path); err == nil {
+		t.Error("Expected a dry run to leave the database unmigrated")
+	}
+
+	if code, _, _ := run(t, "migrate", "-db", path); code != ExitOK {
+		t.Fatalf("Expected migrate to succeed, got %d", code)
+	}
+	code, stdout, _ = run(t, "migrate", "-db", path, "-to", "0")
+	if code != ExitOK || !strings.Contains(stdout, "down  0001_initial") {
+		t.Errorf("Expected the initial migration to be rolled back, got %d %q", code, stdout)
+	}
+
+	if code, _, _ := run(t, "migrate", "-db", path, "-to", "999"); code != ExitUsage {
+		t.Errorf("Expected an unknown version to be a usage error, got %d", code)
+	}
+}
+
+func TestServe_MissingDatabase(t *testing.T) {
+	code, _, _ := run(t, "serve", "-db", filepath.Join(t.TempDir(), "missing.db"))
+	if code != ExitFailure {
+		t.Errorf("Expected serve to fail without a database, got %d", code)
+	}
+}
+
+func TestExport(t *testing.T) {
+	path := seed(t, stored...)
+
+	code, stdout, stderr := run(t, "export", "-db", path)
+	if code != ExitOK {
+		t.Fatalf("Expected export to succeed, got %d: %s", code, stderr)
+	}
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "id,timestamp") {
+		t.Fatalf("Expected a header and 2 rows, got %q", stdout)
+	}
+	if !strings.HasPrefix(lines[1], "2,2024-01-01T00:00:00Z,200,tz1b") {
+		t.Errorf("Expected the newest delegation first, got %q", lines[1])
+	}
+
+	code, stdout, _ = run(t, "export", "-db", path, "-format", "jsonl", "-status", "applied")
+	if code != ExitOK {
+		t.Fatalf("Expected export to succeed, got %d", code)
+	}
+	var d model.Delegation
+	if err := json.Unmarshal([]byte(strings.TrimSpace(stdout)), &d); err != nil || d.ID != 1 {
+		t.Errorf("Expected the applied delegation only, got %q", stdout)
+	}
+
+	out := filepath.Join(t.TempDir(), "2023.csv")
+	if code, _, _ := run(t, "export", "-db", path, "-year", "2023", "-out", out); code != ExitOK {
+		t.Fatalf("Expected export to succeed, got %d", code)
+	}
+	content, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows := strings.Count(string(content), "\n"); rows != 2 {
+		t.Errorf("Expected a header and 1 row in the file, got %q", content)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	path := seed(t, stored...)
+
+	tests := []struct {
+		name     string
+		head     int
+		blocks   []transport.BlockResponse
+		expected int
+		output   string
+	}{
+		{
+			name:     "consistent",
+			head:     250,
+			blocks:   []transport.BlockResponse{{Level: 100, Hash: "BLa"}, {Level: 200, Hash: "BLb"}},
+			expected: ExitOK,
+		},
+		{
+			name:     "replaced block",
+			head:     250,
+			blocks:   []transport.BlockResponse{{Level: 100, Hash: "BLa"}, {Level: 200, Hash: "BLx"}},
+			expected: ExitVerifyFailed,
+			output:   "level 200 is no longer on the main chain",
+		},
+		{
+			name:     "checkpoint ahead of the source",
+			head:     150,
+			blocks:   []transport.BlockResponse{{Level: 100, Hash: "BLa"}, {Level: 200, Hash: "BLb"}},
+			expected: ExitVerifyFailed,
+			output:   "FAIL  head checkpoint at level 200",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := fakeTzkt(t, tt.head, tt.blocks, nil)
+
+			code, stdout, stderr := run(t, "verify", "-db", path, "-tzkt-url", url)
+			if code != tt.expected {
+				t.Fatalf("Expected exit code %d, got %d: %s %s", tt.expected, code, stdout, stderr)
+			}
+			if !strings.Contains(stdout, tt.output) {
+				t.Errorf("Expected the report to mention %q, got %q", tt.output, stdout)
+			}
+		})
+	}
+}
+
+func TestSyncOnce(t *testing.T) {
+	path := seed(t)
+	url := fakeTzkt(t, 300, nil, []transport.DelegationResponse{
+		{ID: 7, Timestamp: "2024-02-01T00:00:00Z", Level: 300, Status: model.StatusApplied, Sender: struct {
+			Address string `json:"address"`
+		}{Address: "tz1c"}},
+	})
+
+	if code, _, stderr := run(t, "sync-once", "-db", path, "-tzkt-url", url); code != ExitOK {
+		t.Fatalf("Expected sync-once to succeed, got %d: %s", code, stderr)
+	}
+
+	db, err := repository.NewDatabase(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoint, err := db.GetCheckpoint(context.Background(), model.HeadCheckpoint)
+	if err != nil || checkpoint.LastID != 7 {
+		t.Errorf("Expected the checkpoint to reach delegation 7, got %+v, %v", checkpoint, err)
+	}
+
+	cycle := 2
+	inCycle, err := db.GetDelegations(context.Background(), model.DelegationQuery{Cycle: &cycle})
+	if err != nil || len(inCycle) != 1 || inCycle[0].ID != 7 || inCycle[0].Protocol != "PtTest" {
+		t.Errorf("Expected delegation 7 in cycle 2 of PtTest, got %+v, %v", inCycle, err)
+	}
+}
+
+func TestSyncOnce_SourceDown(t *testing.T) {
+	path := seed(t)
+
+	code, _, _ := run(t, "sync-once", "-db", path, "-tzkt-url", "http://127.0.0.1:1/v1/operations/delegations")
+	if code != ExitFailure {
+		t.Errorf("Expected sync-once to fail, got %d", code)
+	}
+}
+
+func TestIngest_SourceDown(t *testing.T) {
+	path := seed(t)
+	// a poller that kept going after the failed first sync would only stop at the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	code := Run(ctx, []string{"ingest", "-db", path, "-tzkt-url", "http://127.0.0.1:1/v1/operations/delegations"}, &stdout, &stderr)
+	if code != ExitFailure {
+		t.Errorf("Expected ingest to fail, got %d", code)
+	}
+}
+
+func TestBackfill_Interrupted(t *testing.T) {
+	path := seed(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var stdout, stderr bytes.Buffer
+	code := Run(ctx, []string{"backfill", "-db", path, "-tzkt-url", fakeTzkt(t, 300, nil, nil)}, &stdout, &stderr)
+	if code != ExitInterrupted {
+		t.Errorf("Expected exit code %d, got %d: %s", ExitInterrupted, code, stderr.String())
+	}
+}
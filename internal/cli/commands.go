@@ -0,0 +1,241 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"tezos-delegation-service/internal/api"
+	"tezos-delegation-service/internal/config"
+	"tezos-delegation-service/internal/repository"
+	"tezos-delegation-service/internal/service"
+)
+
+func newApiServer(cfg *config.Config, svc service.XtzService) (*api.ApiServer, error) {
+	opts := []api.Option{api.WithPageSize(cfg.Server.PageSize), api.WithMaxPageSize(cfg.Server.MaxPageSize), api.WithMinYear(cfg.Server.MinYear)}
+	if cfg.Server.CursorSecret != "" {
+		opts = append(opts, api.WithCursorSecret([]byte(cfg.Server.CursorSecret)))
+	}
+	return api.NewApiServer(svc, opts...)
+}
+
+// runRun follows the chain and serves the API from the same process.
+func runRun(ctx context.Context, e *env, args []string) int {
+	cfg, code, ok := e.load(e.flagSet("run"), args)
+	if !ok {
+		return code
+	}
+
+	repo, err := repository.NewDatabase(cfg.Database.Path)
+	if err != nil {
+		e.logger.Error("❌❌❌ Failed to initialize database", "error", err)
+		return ExitFailure
+	}
+
+	client, limiter := newClient(cfg, repo, e.logger)
+	svc := service.NewXtzFetcherService(repo, client, serviceOptions(cfg, limiter)...)
+
+	server, err := newApiServer(cfg, svc)
+	if err != nil {
+		e.logger.Error("❌❌❌ Failed to initialize API server", "error", err)
+		return ExitFailure
+	}
+
+	poller := service.NewPoller(ctx, repo, svc, e.logger, pollerOptions(cfg)...)
+	poller.Start()
+	defer poller.Stop()
+
+	if err := server.Start(ctx, cfg.Server.Addr); err != nil {
+		e.logger.Error("❌❌❌ Server failed", "error", err)
+		return ExitFailure
+	}
+	return ExitOK
+}
+
+// runServe serves the API from a database written by another process, e.g. ingest.
+func runServe(ctx context.Context, e *env, args []string) int {
+	cfg, code, ok := e.load(e.flagSet("serve"), args)
+	if !ok {
+		return code
+	}
+
+	repo, err := repository.OpenReadOnly(cfg.Database.Path)
+	if err != nil {
+		e.logger.Error("❌❌❌ Failed to open database", "error", err)
+		return ExitFailure
+	}
+
+	// the API only reads the repository, the source is never called
+	client, limiter := newClient(cfg, repo, e.logger)
+	svc := service.NewXtzFetcherService(repo, client, serviceOptions(cfg, limiter)...)
+
+	server, err := newApiServer(cfg, svc)
+	if err != nil {
+		e.logger.Error("❌❌❌ Failed to initialize API server", "error", err)
+		return ExitFailure
+	}
+	if err := server.Start(ctx, cfg.Server.Addr); err != nil {
+		e.logger.Error("❌❌❌ Server failed", "error", err)
+		return ExitFailure
+	}
+	return ExitOK
+}
+
+// runIngest follows the chain until stopped by a signal or an error it cannot recover from.
+func runIngest(ctx context.Context, e *env, args []string) int {
+	cfg, code, ok := e.load(e.flagSet("ingest"), args)
+	if !ok {
+		return code
+	}
+
+	repo, err := repository.NewDatabase(cfg.Database.Path)
+	if err != nil {
+		e.logger.Error("❌❌❌ Failed to initialize database", "error", err)
+		return ExitFailure
+	}
+
+	client, limiter := newClient(cfg, repo, e.logger)
+	svc := service.NewXtzFetcherService(repo, client, serviceOptions(cfg, limiter)...)
+
+	poller := service.NewPoller(ctx, repo, svc, e.logger, pollerOptions(cfg)...)
+	if err := poller.Run(); err != nil {
+		e.logger.Error("❌❌❌ Ingestion stopped", "error", err)
+		return ExitFailure
+	}
+	return ExitOK
+}
+
+// runBackfill stores every delegation between a timestamp or level and a timestamp. An interrupted
+// backfill resumes from its checkpoint on the next run.
+func runBackfill(ctx context.Context, e *env, args []string) int {
+	fs := e.flagSet("backfill")
+	from := fs.String("from", "", "RFC3339 timestamp to start the historical backfill from (default genesis)")
+	fromLevel := fs.Int("from-level", 0, "level to start the historical backfill from (default genesis)")
+	to := fs.String("to", "", "RFC3339 timestamp to stop the historical backfill at (default head)")
+	cfg, code, ok := e.load(fs, args)
+	if !ok {
+		return code
+	}
+
+	for name, value := range map[string]string{"from": *from, "to": *to} {
+		if value == "" {
+			continue
+		}
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			e.logger.Error("❌❌❌ Invalid timestamp, expected RFC3339", name, value)
+			return ExitUsage
+		}
+	}
+
+	if *fromLevel < 0 {
+		e.logger.Error("❌❌❌ Invalid level, expected a non-negative integer", "from-level", *fromLevel)
+		return ExitUsage
+	}
+
+	repo, err := repository.NewDatabase(cfg.Database.Path)
+	if err != nil {
+		e.logger.Error("❌❌❌ Failed to initialize database", "error", err)
+		return ExitFailure
+	}
+
+	client, limiter := newClient(cfg, repo, e.logger)
+	svc := service.NewXtzFetcherService(repo, client, serviceOptions(cfg, limiter)...)
+
+	var opts []service.BackfillOption
+	if *to != "" {
+		opts = append(opts, service.WithUntil(*to))
+	}
+	if *fromLevel > 0 {
+		opts = append(opts, service.WithFromLevel(*fromLevel))
+	}
+	historical := service.NewHistoricalBackfill(ctx, repo, svc, e.logger, *from, opts...)
+	err = historical.Run()
+	stats := limiter.Stats()
+	e.logger.Info("TzKT rate limiter", "requests", stats.Requests, "throttled", stats.Throttled, "waited", stats.TotalWait.String())
+	if err != nil {
+		e.logger.Error("❌❌❌ Historical backfill failed, rerun to resume", "error", err)
+	}
+	return exitCode(ctx, err)
+}
+
+// runSyncOnce stores the delegations published since the last sync, e.g. from a cron job.
+func runSyncOnce(ctx context.Context, e *env, args []string) int {
+	cfg, code, ok := e.load(e.flagSet("sync-once"), args)
+	if !ok {
+		return code
+	}
+
+	repo, err := repository.NewDatabase(cfg.Database.Path)
+	if err != nil {
+		e.logger.Error("❌❌❌ Failed to initialize database", "error", err)
+		return ExitFailure
+	}
+
+	client, limiter := newClient(cfg, repo, e.logger)
+	svc := service.NewXtzFetcherService(repo, client, serviceOptions(cfg, limiter)...)
+
+	poller := service.NewPoller(ctx, repo, svc, e.logger, service.WithConfirmationDepth(cfg.Poller.Confirmations))
+	err = poller.SyncOnce()
+	if err != nil {
+		e.logger.Error("❌❌❌ Sync failed", "error", err)
+	}
+	return exitCode(ctx, err)
+}
+
+// runMigrate brings the database schema to a version, the latest by default, without syncing
+// anything. The steps are listed on stdout, with their SQL when only planned.
+func runMigrate(ctx context.Context, e *env, args []string) int {
+	fs := e.flagSet("migrate")
+	dryRun := fs.Bool("dry-run", false, "list the migrations that would run, with their SQL, without applying them")
+	to := fs.Int("to", repository.MigrateLatest, "schema version to migrate up or down to (default latest)")
+	lockTimeout := fs.Duration("lock-timeout", time.Minute, "how long to wait for another migrator to finish")
+	cfg, code, ok := e.load(fs, args)
+	if !ok {
+		return code
+	}
+
+	migrations, err := repository.Migrations(repository.DialectOf(cfg.Database.Path))
+	if err != nil {
+		e.logger.Error("❌❌❌ Invalid migrations", "error", err)
+		return ExitFailure
+	}
+	if latest := migrations[len(migrations)-1].Version; *to != repository.MigrateLatest && (*to < 0 || *to > latest) {
+		e.logger.Error("❌❌❌ Unknown schema version", "to", *to, "latest", latest)
+		return ExitUsage
+	}
+
+	repo, err := repository.Open(cfg.Database.Path)
+	if err != nil {
+		e.logger.Error("❌❌❌ Failed to open database", "error", err)
+		return ExitFailure
+	}
+
+	opts := []repository.MigrateOption{repository.WithLockTimeout(*lockTimeout)}
+	if *dryRun {
+		opts = append(opts, repository.WithDryRun())
+	}
+	steps, err := repo.Migrate(ctx, *to, opts...)
+	if err != nil {
+		e.logger.Error("❌❌❌ Failed to migrate database", "error", err)
+		return exitCode(ctx, err)
+	}
+
+	for _, step := range steps {
+		fmt.Fprintf(e.stdout, "%-4s  %04d_%s\n", step.Direction, step.Version, step.Name)
+		if *dryRun {
+			fmt.Fprintf(e.stdout, "%s\n", strings.TrimSpace(step.SQL))
+		}
+	}
+	version, err := repo.SchemaVersion(ctx)
+	if err != nil {
+		e.logger.Error("❌❌❌ Failed to read the schema version", "error", err)
+		return ExitFailure
+	}
+	if *dryRun {
+		e.logger.Info("Dry run, database left unchanged", "database", repository.Redact(cfg.Database.Path), "version", version, "pending", len(steps))
+		return ExitOK
+	}
+	e.logger.Info("Database migrated", "database", repository.Redact(cfg.Database.Path), "version", version, "applied", len(steps))
+	return ExitOK
+}
@@ -0,0 +1,171 @@
+package cli
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"tezos-delegation-service/internal/api"
+	"tezos-delegation-service/internal/model"
+	"tezos-delegation-service/internal/repository"
+	"tezos-delegation-service/internal/service"
+)
+
+var csvHeader = []string{
+	"id", "timestamp", "level", "delegator", "baker", "prev_baker", "amount", "kind", "status",
+	"hash", "block", "counter", "baker_fee", "gas_used", "cycle", "protocol",
+}
+
+// delegationWriter writes delegations in one export format.
+type delegationWriter interface {
+	Write(d model.Delegation) error
+	Flush() error
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) Write(d model.Delegation) error {
+	// left empty until derived
+	cycle := ""
+	if d.Cycle != nil {
+		cycle = strconv.Itoa(*d.Cycle)
+	}
+	return c.w.Write([]string{
+		strconv.Itoa(d.ID), d.Timestamp, strconv.Itoa(d.Level), d.Delegator, d.Baker, d.PrevBaker,
+		strconv.Itoa(d.Amount), string(d.Kind), d.Status, d.Hash, d.Block, strconv.Itoa(d.Counter),
+		strconv.Itoa(d.BakerFee), strconv.Itoa(d.GasUsed), cycle, d.Protocol,
+	})
+}
+
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func (j *jsonlWriter) Write(d model.Delegation) error {
+	return j.enc.Encode(d)
+}
+
+func (j *jsonlWriter) Flush() error {
+	return nil
+}
+
+func newDelegationWriter(format string, w io.Writer) (delegationWriter, error) {
+	switch format {
+	case "csv":
+		c := csv.NewWriter(w)
+		return &csvWriter{c}, c.Write(csvHeader)
+	case "jsonl":
+		return &jsonlWriter{json.NewEncoder(w)}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q, expected csv or jsonl", format)
+}
+
+// runExport writes the stored delegations, newest first, to stdout or a file.
+func runExport(ctx context.Context, e *env, args []string) int {
+	fs := e.flagSet("export")
+	format := fs.String("format", "csv", "output format: csv or jsonl")
+	year := fs.Int("year", 0, "only export the delegations of this year (default every year)")
+	kind := fs.String("kind", "", "only export delegations of this kind: delegate, redelegate or undelegate")
+	status := fs.String("status", "", "only export operations with this status, e.g. applied (default every status)")
+	out := fs.String("out", "", "file to write to (default stdout)")
+	cfg, code, ok := e.load(fs, args)
+	if !ok {
+		return code
+	}
+
+	if *format != "csv" && *format != "jsonl" {
+		e.logger.Error("❌❌❌ Invalid export format, expected csv or jsonl", "format", *format)
+		return ExitUsage
+	}
+	if *kind != "" && !model.DelegationKind(*kind).Valid() {
+		e.logger.Error("❌❌❌ Invalid delegation kind", "kind", *kind)
+		return ExitUsage
+	}
+	if *status != "" && !model.ValidStatus(*status) {
+		e.logger.Error("❌❌❌ Invalid operation status", "status", *status)
+		return ExitUsage
+	}
+
+	repo, err := repository.OpenReadOnly(cfg.Database.Path)
+	if err != nil {
+		e.logger.Error("❌❌❌ Failed to open database", "error", err)
+		return ExitFailure
+	}
+	client, limiter := newClient(cfg, repo, e.logger)
+	svc := service.NewXtzFetcherService(repo, client, serviceOptions(cfg, limiter)...)
+
+	w := e.stdout
+	var file *os.File
+	if *out != "" {
+		if file, err = os.Create(*out); err != nil {
+			e.logger.Error("❌❌❌ Failed to create export file", "error", err)
+			return ExitFailure
+		}
+		defer file.Close()
+		w = file
+	}
+
+	years := []int{*year}
+	if *year == 0 {
+		years = nil
+		for y := time.Now().Year(); y >= cfg.Server.MinYear; y-- {
+			years = append(years, y)
+		}
+	}
+
+	query := model.DelegationQuery{Kind: model.DelegationKind(*kind), Status: *status}
+	count, err := export(ctx, svc, w, *format, query, years)
+	if err == nil && file != nil {
+		err = file.Close()
+	}
+	if err != nil {
+		e.logger.Error("❌❌❌ Export failed", "error", err)
+		return exitCode(ctx, err)
+	}
+	e.logger.Info("Exported delegations", "count", count, "format", *format)
+	return ExitOK
+}
+
+// export pages through the delegations of every year in turn, each page resuming after the last
+// delegation of the previous one, and writes them to w.
+func export(ctx context.Context, svc service.XtzService, w io.Writer, format string, query model.DelegationQuery, years []int) (int, error) {
+	out, err := newDelegationWriter(format, w)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	query.Limit = api.DefaultMaxPageSize
+	for _, year := range years {
+		query.Year, query.After = year, model.Cursor{}
+		for {
+			page, err := svc.GetDelegations(ctx, query)
+			if err != nil {
+				return count, err
+			}
+			for _, d := range page {
+				if err := out.Write(d); err != nil {
+					return count, err
+				}
+			}
+			count += len(page)
+			if len(page) < query.Limit {
+				break
+			}
+			query.After = model.CursorOf(page[len(page)-1])
+		}
+	}
+	return count, out.Flush()
+}
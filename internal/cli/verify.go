@@ -0,0 +1,96 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"tezos-delegation-service/internal/model"
+	"tezos-delegation-service/internal/repository"
+	"tezos-delegation-service/internal/service"
+
+	"gorm.io/gorm"
+)
+
+// defaultVerifyDepth is how many levels below the head checkpoint verify compares with the source.
+const defaultVerifyDepth = 100
+
+// runVerify checks, without changing anything, that the database is consistent with the source:
+// the head checkpoint is not ahead of the source, the recently stored blocks are still on the main
+// chain and no historical backfill was left half done. Problems are listed on stdout.
+func runVerify(ctx context.Context, e *env, args []string) int {
+	fs := e.flagSet("verify")
+	depth := fs.Int("depth", defaultVerifyDepth, "levels below the head checkpoint whose blocks are compared with the source")
+	cfg, code, ok := e.load(fs, args)
+	if !ok {
+		return code
+	}
+	if *depth < 0 {
+		e.logger.Error("❌❌❌ Invalid depth, expected a positive number of levels", "depth", *depth)
+		return ExitUsage
+	}
+
+	repo, err := repository.OpenReadOnly(cfg.Database.Path)
+	if err != nil {
+		e.logger.Error("❌❌❌ Failed to open database", "error", err)
+		return ExitFailure
+	}
+
+	client, limiter := newClient(cfg, repo, e.logger)
+	svc := service.NewXtzFetcherService(repo, client, serviceOptions(cfg, limiter)...)
+
+	var problems []string
+	report := func(ok bool, format string, args ...any) {
+		status := "ok"
+		if !ok {
+			status = "FAIL"
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+		fmt.Fprintf(e.stdout, "%-4s  %s\n", status, fmt.Sprintf(format, args...))
+	}
+
+	head, err := repo.GetCheckpoint(ctx, model.HeadCheckpoint)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		fmt.Fprintln(e.stdout, "nothing synced yet")
+		return ExitOK
+	case err != nil:
+		e.logger.Error("❌❌❌ Failed to read the head checkpoint", "error", err)
+		return exitCode(ctx, err)
+	}
+
+	headLevel, err := svc.GetHeadLevel(ctx)
+	if err != nil {
+		e.logger.Error("❌❌❌ Failed to read the head of the source", "source", client.Source(), "error", err)
+		return exitCode(ctx, err)
+	}
+	report(head.Level <= headLevel, "head checkpoint at level %d, source %s at level %d", head.Level, client.Source(), headLevel)
+
+	fork, err := svc.FindFork(ctx, model.HeadCheckpoint, *depth)
+	if err != nil {
+		e.logger.Error("❌❌❌ Failed to compare stored blocks", "error", err)
+		return exitCode(ctx, err)
+	}
+	if fork == 0 {
+		report(true, "blocks of the last %d levels are on the main chain", *depth)
+	} else {
+		report(false, "block at level %d is no longer on the main chain, run sync-once to roll it back", fork)
+	}
+
+	backfill, err := repo.GetCheckpoint(ctx, model.BackfillCheckpoint)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		report(true, "no historical backfill in progress")
+	case err != nil:
+		e.logger.Error("❌❌❌ Failed to read the backfill checkpoint", "error", err)
+		return exitCode(ctx, err)
+	default:
+		report(false, "historical backfill stopped at level %d, run backfill to resume it", backfill.Level)
+	}
+
+	if len(problems) > 0 {
+		fmt.Fprintf(e.stdout, "%d problem(s) found\n", len(problems))
+		return ExitVerifyFailed
+	}
+	return ExitOK
+}
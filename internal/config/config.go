@@ -0,0 +1,366 @@
+// Package config loads the service settings. In increasing order of precedence they come from the
+// defaults, a YAML file, XTZ_* environment variables and command line flags.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"tezos-delegation-service/internal/api"
+	"tezos-delegation-service/internal/model"
+	"tezos-delegation-service/internal/service"
+	"tezos-delegation-service/internal/transport"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MaxPageSize bounds the largest page the API may be configured to list.
+const MaxPageSize = 10000
+
+// envPrefix prefixes the environment variable of every setting, e.g. XTZ_DB for -db.
+const envPrefix = "XTZ_"
+
+type Config struct {
+	Server   Server   `yaml:"server"`
+	Database Database `yaml:"database"`
+	Source   Source   `yaml:"source"`
+	Poller   Poller   `yaml:"poller"`
+}
+
+type Server struct {
+	Addr         string `yaml:"addr"`
+	PageSize     int    `yaml:"page_size"`
+	MaxPageSize  int    `yaml:"max_page_size"`
+	MinYear      int    `yaml:"min_year"`
+	CursorSecret string `yaml:"cursor_secret"`
+}
+
+type Database struct {
+	Path string `yaml:"path"`
+}
+
+type Source struct {
+	Kind              string   `yaml:"kind"`
+	TzktURL           string   `yaml:"tzkt_url"`
+	PageLimit         int      `yaml:"page_limit"`
+	StreamURL         string   `yaml:"stream_url"`
+	NodeURL           string   `yaml:"node_url"`
+	FallbackURLs      []string `yaml:"fallback_urls"`
+	MaxLag            int      `yaml:"max_lag"`
+	CrossCheck        string   `yaml:"cross_check"`
+	CrossCheckEvery   int      `yaml:"cross_check_every"`
+	RequestsPerSecond float64  `yaml:"requests_per_second"`
+	Burst             int      `yaml:"burst"`
+}
+
+type Poller struct {
+	Interval      time.Duration `yaml:"interval"`
+	Stream        bool          `yaml:"stream"`
+	Confirmations int           `yaml:"confirmations"`
+	Ingest        string        `yaml:"ingest"`
+}
+
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Addr:        ":3000",
+			PageSize:    model.DefaultPageSize,
+			MaxPageSize: api.DefaultMaxPageSize,
+			MinYear:     api.DefaultMinYear,
+		},
+		Database: Database{
+			Path: "delegations.db",
+		},
+		Source: Source{
+			Kind:              "tzkt",
+			TzktURL:           "https://api.tzkt.io/v1/operations/delegations",
+			PageLimit:         1000,
+			StreamURL:         "wss://api.tzkt.io/v1/ws",
+			NodeURL:           "http://localhost:8732",
+			MaxLag:            transport.DefaultMaxLag,
+			CrossCheckEvery:   10,
+			RequestsPerSecond: transport.DefaultRequestsPerSecond,
+			Burst:             transport.DefaultBurst,
+		},
+		Poller: Poller{
+			Interval:      service.DefaultPollInterval,
+			Confirmations: service.DefaultConfirmationDepth,
+			Ingest:        string(service.IngestAll),
+		},
+	}
+}
+
+// setting is a value that can be set from the environment or the command line.
+type setting struct {
+	flag    string
+	usage   string
+	boolean bool
+	apply   func(c *Config, value string) error
+}
+
+func (s setting) env() string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(s.flag, "-", "_"))
+}
+
+func settings() []setting {
+	return []setting{
+		{flag: "addr", usage: "address the API listens on", apply: stringValue(func(c *Config) *string { return &c.Server.Addr })},
+		{flag: "page-size", usage: "delegations listed per API page", apply: intValue(func(c *Config) *int { return &c.Server.PageSize })},
+		{flag: "max-page-size", usage: "largest limit an API request may set", apply: intValue(func(c *Config) *int { return &c.Server.MaxPageSize })},
+		{flag: "min-year", usage: "oldest year the API may be queried for", apply: intValue(func(c *Config) *int { return &c.Server.MinYear })},
+		{flag: "cursor-secret", usage: "secret signing pagination cursors, shared by every replica (default random per process)", apply: stringValue(func(c *Config) *string { return &c.Server.CursorSecret })},
+		{flag: "db", usage: "path of the SQLite database, or postgres:// URL of a PostgreSQL one", apply: stringValue(func(c *Config) *string { return &c.Database.Path })},
+		{flag: "source", usage: "where delegations are read from: tzkt, or node for an Octez node RPC", apply: stringValue(func(c *Config) *string { return &c.Source.Kind })},
+		{flag: "tzkt-url", usage: "TzKT delegations endpoint", apply: stringValue(func(c *Config) *string { return &c.Source.TzktURL })},
+		{flag: "tzkt-page-limit", usage: "delegations fetched from TzKT per request", apply: intValue(func(c *Config) *int { return &c.Source.PageLimit })},
+		{flag: "stream-url", usage: "TzKT websocket events endpoint", apply: stringValue(func(c *Config) *string { return &c.Source.StreamURL })},
+		{flag: "node-url", usage: "RPC URL of the Octez node used by -source node", apply: stringValue(func(c *Config) *string { return &c.Source.NodeURL })},
+		{flag: "fallback-urls", usage: "comma-separated URLs of other sources to fail over to, of -source kind unless prefixed with tzkt= or node=", apply: listValue(func(c *Config) *[]string { return &c.Source.FallbackURLs })},
+		{flag: "max-lag", usage: "levels a source may trail the others before failing over", apply: intValue(func(c *Config) *int { return &c.Source.MaxLag })},
+		{flag: "cross-check", usage: "source to compare samples with, as tzkt=<url> or node=<url>", apply: stringValue(func(c *Config) *string { return &c.Source.CrossCheck })},
+		{flag: "cross-check-every", usage: "compare one level of every this many pages with -cross-check", apply: intValue(func(c *Config) *int { return &c.Source.CrossCheckEvery })},
+		{flag: "tzkt-rps", usage: "maximum requests per second sent to TzKT (0 disables the limit)", apply: floatValue(func(c *Config) *float64 { return &c.Source.RequestsPerSecond })},
+		{flag: "tzkt-burst", usage: "requests that may be sent to TzKT in a burst", apply: intValue(func(c *Config) *int { return &c.Source.Burst })},
+		{flag: "poll-interval", usage: "how often the poller asks for new delegations", apply: durationValue(func(c *Config) *time.Duration { return &c.Poller.Interval })},
+		{flag: "stream", usage: "store delegations as TzKT streams them over its websocket API instead of polling", boolean: true, apply: boolValue(func(c *Config) *bool { return &c.Poller.Stream })},
+		{flag: "confirmations", usage: "levels below the head checked for chain reorganisations before delegations are final", apply: intValue(func(c *Config) *int { return &c.Poller.Confirmations })},
+		{flag: "ingest", usage: "which operations to store: all, or applied only", apply: stringValue(func(c *Config) *string { return &c.Poller.Ingest })},
+	}
+}
+
+// Load registers the flags of every setting, plus -config, on fs, parses args and builds the
+// configuration. fs may hold other flags, e.g. those of a subcommand.
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	configPath := fs.String("config", "", "YAML configuration file (env "+envPrefix+"CONFIG)")
+
+	type flagValue struct {
+		setting setting
+		value   string
+	}
+	var flagged []flagValue
+	for _, s := range settings() {
+		record := func(value string) error {
+			flagged = append(flagged, flagValue{s, value})
+			return nil
+		}
+		usage := fmt.Sprintf("%s (env %s)", s.usage, s.env())
+		if s.boolean {
+			fs.BoolFunc(s.flag, usage, record)
+		} else {
+			fs.Func(s.flag, usage, record)
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+
+	path := *configPath
+	if path == "" {
+		path = os.Getenv(envPrefix + "CONFIG")
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	for _, s := range settings() {
+		if value, ok := os.LookupEnv(s.env()); ok {
+			if err := s.apply(cfg, value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", s.env(), err))
+			}
+		}
+	}
+	for _, f := range flagged {
+		if err := f.setting.apply(cfg, f.value); err != nil {
+			errs = append(errs, fmt.Errorf("-%s: %w", f.setting.flag, err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// unknown keys are most likely typos, which would silently keep a default
+	decoder := yaml.NewDecoder(f)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && err != io.EOF {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, key, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: "+format, append([]any{key}, args...)...))
+		}
+	}
+
+	check(c.Server.Addr != "", "server.addr", "must be set")
+	check(c.Server.MaxPageSize >= 1 && c.Server.MaxPageSize <= MaxPageSize, "server.max_page_size", "must be between 1 and %d, got %d", MaxPageSize, c.Server.MaxPageSize)
+	check(c.Server.PageSize >= 1 && c.Server.PageSize <= c.Server.MaxPageSize, "server.page_size", "must be between 1 and server.max_page_size, got %d", c.Server.PageSize)
+	check(c.Server.MinYear >= api.DefaultMinYear && c.Server.MinYear <= time.Now().Year(), "server.min_year", "must be between %d and the current year, got %d", api.DefaultMinYear, c.Server.MinYear)
+
+	check(c.Server.CursorSecret == "" || len(c.Server.CursorSecret) >= 16, "server.cursor_secret", "must be at least 16 characters")
+
+	check(c.Database.Path != "", "database.path", "must be set")
+
+	check(c.Source.Kind == "tzkt" || c.Source.Kind == "node", "source.kind", "must be tzkt or node, got %q", c.Source.Kind)
+	check(validURL(c.Source.TzktURL, "http", "https"), "source.tzkt_url", "must be an http(s) URL, got %q", c.Source.TzktURL)
+	check(c.Source.PageLimit >= 1 && c.Source.PageLimit <= 10000, "source.page_limit", "must be between 1 and 10000, got %d", c.Source.PageLimit)
+	check(validURL(c.Source.StreamURL, "ws", "wss"), "source.stream_url", "must be a ws(s) URL, got %q", c.Source.StreamURL)
+	check(validURL(c.Source.NodeURL, "http", "https"), "source.node_url", "must be an http(s) URL, got %q", c.Source.NodeURL)
+	for _, fallback := range c.Source.Fallbacks() {
+		check(validURL(fallback.URL, "http", "https"), "source.fallback_urls", "must be http(s) URLs, optionally prefixed with tzkt= or node=, got %q", fallback.URL)
+	}
+	check(c.Source.MaxLag >= 0, "source.max_lag", "must not be negative, got %d", c.Source.MaxLag)
+	if c.Source.CrossCheck != "" {
+		kind, rawURL, _ := strings.Cut(c.Source.CrossCheck, "=")
+		check((kind == "tzkt" || kind == "node") && validURL(rawURL, "http", "https"), "source.cross_check", "must be tzkt=<url> or node=<url>, got %q", c.Source.CrossCheck)
+	}
+	check(c.Source.CrossCheckEvery >= 1, "source.cross_check_every", "must be at least 1, got %d", c.Source.CrossCheckEvery)
+	check(c.Source.RequestsPerSecond >= 0, "source.requests_per_second", "must not be negative, got %v", c.Source.RequestsPerSecond)
+	check(c.Source.Burst >= 1, "source.burst", "must be at least 1, got %d", c.Source.Burst)
+
+	check(c.Poller.Interval > 0, "poller.interval", "must be positive, got %s", c.Poller.Interval)
+	check(!c.Poller.Stream || c.Source.Kind == "tzkt", "poller.stream", "is only available from TzKT")
+	check(c.Poller.Confirmations >= 0, "poller.confirmations", "must not be negative, got %d", c.Poller.Confirmations)
+	check(service.IngestionPolicy(c.Poller.Ingest).Valid(), "poller.ingest", "must be all or applied, got %q", c.Poller.Ingest)
+
+	return errors.Join(errs...)
+}
+
+// Fallback is a source to fail over to: a TzKT delegations endpoint or a node RPC.
+type Fallback struct {
+	Kind string
+	URL  string
+}
+
+// Fallbacks returns the sources of source.fallback_urls. A URL is of source.kind unless prefixed
+// with tzkt= or node=, like source.cross_check.
+func (s Source) Fallbacks() []Fallback {
+	fallbacks := make([]Fallback, 0, len(s.FallbackURLs))
+	for _, raw := range s.FallbackURLs {
+		fallback := Fallback{Kind: s.Kind, URL: raw}
+		if kind, rawURL, found := strings.Cut(raw, "="); found && (kind == "tzkt" || kind == "node") {
+			fallback = Fallback{Kind: kind, URL: rawURL}
+		}
+		fallbacks = append(fallbacks, fallback)
+	}
+	return fallbacks
+}
+
+// DelegationsURL returns the TzKT delegations endpoint with the page limit applied.
+func (s Source) DelegationsURL() string {
+	u, err := url.Parse(s.TzktURL)
+	if err != nil {
+		return s.TzktURL
+	}
+	query := u.Query()
+	query.Set("limit", strconv.Itoa(s.PageLimit))
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func validURL(raw string, schemes ...string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			return true
+		}
+	}
+	return false
+}
+
+func stringValue(field func(*Config) *string) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}
+}
+
+func intValue(field func(*Config) *int) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", value)
+		}
+		*field(c) = n
+		return nil
+	}
+}
+
+func floatValue(field func(*Config) *float64) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid number %q", value)
+		}
+		*field(c) = f
+		return nil
+	}
+}
+
+func boolValue(field func(*Config) *bool) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", value)
+		}
+		*field(c) = b
+		return nil
+	}
+}
+
+func durationValue(field func(*Config) *time.Duration) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", value)
+		}
+		*field(c) = d
+		return nil
+	}
+}
+
+// listValue reads a comma-separated list, ignoring empty items.
+func listValue(field func(*Config) *[]string) func(*Config, string) error {
+	return func(c *Config, value string) error {
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		*field(c) = items
+		return nil
+	}
+}
@@ -0,0 +1,220 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func load(t *testing.T, args ...string) (*Config, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(nopWriter{})
+	return Load(fs, args)
+}
+
+type nopWriter struct{}
+
+func (nopWriter) Write(p []byte) (int, error) { return len(p), nil }
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad_Defaults(t *testing.T) {
+	cfg, err := load(t)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if cfg.Server.Addr != ":3000" || cfg.Server.PageSize != 50 || cfg.Server.MinYear != 2018 {
+		t.Errorf("Unexpected server defaults %+v", cfg.Server)
+	}
+	if cfg.Database.Path != "delegations.db" {
+		t.Errorf("Unexpected database path %q", cfg.Database.Path)
+	}
+	if cfg.Poller.Interval != time.Minute {
+		t.Errorf("Expected a one minute poll interval, got %s", cfg.Poller.Interval)
+	}
+	if got := cfg.Source.DelegationsURL(); got != "https://api.tzkt.io/v1/operations/delegations?limit=1000" {
+		t.Errorf("Unexpected delegations URL %q", got)
+	}
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeFile(t, `
+server:
+  addr: ":4000"
+  page_size: 20
+database:
+  path: file.db
+poller:
+  interval: 30s
+`)
+	t.Setenv("XTZ_CONFIG", path)
+	t.Setenv("XTZ_PAGE_SIZE", "30")
+	t.Setenv("XTZ_DB", "env.db")
+
+	cfg, err := load(t, "-db", "flag.db")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if cfg.Server.Addr != ":4000" {
+		t.Errorf("Expected the file to override the default address, got %q", cfg.Server.Addr)
+	}
+	if cfg.Server.PageSize != 30 {
+		t.Errorf("Expected the environment to override the file page size, got %d", cfg.Server.PageSize)
+	}
+	if cfg.Database.Path != "flag.db" {
+		t.Errorf("Expected the flag to override the environment database path, got %q", cfg.Database.Path)
+	}
+	if cfg.Poller.Interval != 30*time.Second {
+		t.Errorf("Expected a 30s poll interval, got %s", cfg.Poller.Interval)
+	}
+	if cfg.Server.MinYear != 2018 {
+		t.Errorf("Expected unset values to keep their default, got min year %d", cfg.Server.MinYear)
+	}
+}
+
+func TestLoad_ConfigFlagOverridesEnvironment(t *testing.T) {
+	t.Setenv("XTZ_CONFIG", filepath.Join(t.TempDir(), "missing.yaml"))
+	path := writeFile(t, "database:\n  path: flag-file.db\n")
+
+	cfg, err := load(t, "-config", path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if cfg.Database.Path != "flag-file.db" {
+		t.Errorf("Expected the database path of the -config file, got %q", cfg.Database.Path)
+	}
+}
+
+func TestLoad_Lists(t *testing.T) {
+	cfg, err := load(t, "-fallback-urls", "https://a.example/v1/operations/delegations, ,https://b.example/v1/operations/delegations", "-stream")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if strings.Join(cfg.Source.FallbackURLs, " ") != "https://a.example/v1/operations/delegations https://b.example/v1/operations/delegations" {
+		t.Errorf("Unexpected fallback URLs %q", cfg.Source.FallbackURLs)
+	}
+	if !cfg.Poller.Stream {
+		t.Error("Expected -stream to enable streaming")
+	}
+}
+
+func TestSource_Fallbacks(t *testing.T) {
+	cfg := Default()
+	cfg.Source.FallbackURLs = []string{
+		"https://mirror.example/v1/operations/delegations?limit=1000",
+		"node=http://localhost:8732",
+		"tzkt=https://api.tzkt.io/v1/operations/delegations",
+	}
+
+	expected := []Fallback{
+		{Kind: "tzkt", URL: "https://mirror.example/v1/operations/delegations?limit=1000"},
+		{Kind: "node", URL: "http://localhost:8732"},
+		{Kind: "tzkt", URL: "https://api.tzkt.io/v1/operations/delegations"},
+	}
+	if got := cfg.Source.Fallbacks(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected fallbacks %+v, got %+v", expected, got)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected failover between TzKT and a node to be valid, got %v", err)
+	}
+}
+
+func TestLoad_UnknownKey(t *testing.T) {
+	path := writeFile(t, "server:\n  adr: \":4000\"\n")
+
+	_, err := load(t, "-config", path)
+	if err == nil || !strings.Contains(err.Error(), "adr") {
+		t.Errorf("Expected an error naming the unknown key, got %v", err)
+	}
+}
+
+func TestLoad_InvalidValues(t *testing.T) {
+	t.Setenv("XTZ_PAGE_SIZE", "many")
+
+	_, err := load(t, "-poll-interval", "soon")
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	for _, want := range []string{"XTZ_PAGE_SIZE", "-poll-interval"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected the error to mention %s, got %v", want, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		want   string
+	}{
+		{"page size too large", func(c *Config) { c.Server.PageSize = c.Server.MaxPageSize + 1 }, "server.page_size"},
+		{"max page size too large", func(c *Config) { c.Server.MaxPageSize = MaxPageSize + 1 }, "server.max_page_size"},
+		{"zero max page size", func(c *Config) { c.Server.MaxPageSize = 0 }, "server.max_page_size"},
+		{"min year too old", func(c *Config) { c.Server.MinYear = 2017 }, "server.min_year"},
+		{"min year in the future", func(c *Config) { c.Server.MinYear = time.Now().Year() + 1 }, "server.min_year"},
+		{"cursor secret too short", func(c *Config) { c.Server.CursorSecret = "secret" }, "server.cursor_secret"},
+		{"unknown source", func(c *Config) { c.Source.Kind = "rpc" }, "source.kind"},
+		{"stream from a node", func(c *Config) { c.Source.Kind = "node"; c.Poller.Stream = true }, "poller.stream"},
+		{"invalid cross-check", func(c *Config) { c.Source.CrossCheck = "https://api.tzkt.io" }, "source.cross_check"},
+		{"invalid fallback", func(c *Config) { c.Source.FallbackURLs = []string{"api.tzkt.io"} }, "source.fallback_urls"},
+		{"invalid prefixed fallback", func(c *Config) { c.Source.FallbackURLs = []string{"node=localhost:8732"} }, "source.fallback_urls"},
+		{"zero interval", func(c *Config) { c.Poller.Interval = 0 }, "poller.interval"},
+		{"invalid ingestion policy", func(c *Config) { c.Poller.Ingest = "some" }, "poller.ingest"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Default()
+			tt.modify(cfg)
+
+			err := cfg.Validate()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected an error about %s, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestValidate_ReportsEveryError(t *testing.T) {
+	cfg := Default()
+	cfg.Server.PageSize = 0
+	cfg.Database.Path = ""
+
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	for _, want := range []string{"server.page_size", "database.path"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected the error to mention %s, got %v", want, err)
+		}
+	}
+}
+
+func TestLoad_ExampleFile(t *testing.T) {
+	cfg, err := load(t, "-config", filepath.Join("..", "..", "config.example.yaml"))
+	if err != nil {
+		t.Fatalf("Expected the example configuration to load, got %v", err)
+	}
+
+	// an empty list in the file decodes to an empty slice rather than nil
+	cfg.Source.FallbackURLs = nil
+	if !reflect.DeepEqual(cfg, Default()) {
+		t.Errorf("Expected the example configuration to hold the defaults, got %+v", cfg)
+	}
+}
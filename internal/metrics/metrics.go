@@ -0,0 +1,25 @@
+// Package metrics holds the service counters. They are published through expvar and served as
+// JSON at /debug/vars.
+package metrics
+
+import "expvar"
+
+var (
+	// TzktRequests counts HTTP attempts made against TzKT, retries included.
+	TzktRequests = expvar.NewInt("tzkt_requests_total")
+	// NodeRequests counts HTTP attempts made against the Tezos node RPC, retries included.
+	NodeRequests = expvar.NewInt("node_requests_total")
+	// TzktRateLimitWaits counts requests that had to wait for the client-side rate limiter.
+	TzktRateLimitWaits = expvar.NewInt("tzkt_rate_limit_waits_total")
+	// TzktRateLimitWaitSeconds is the total time spent waiting for the rate limiter.
+	TzktRateLimitWaitSeconds = expvar.NewFloat("tzkt_rate_limit_wait_seconds_total")
+	// SourceFailovers counts the switches from one delegation source to another.
+	SourceFailovers = expvar.NewInt("source_failovers_total")
+	// SourceDisagreements counts delegations on which a cross-checked source disagreed.
+	SourceDisagreements = expvar.NewInt("source_disagreements_total")
+	// ChainReorgs counts the chain reorganisations that rolled back stored delegations.
+	ChainReorgs = expvar.NewInt("chain_reorgs_total")
+	// ProtocolRefreshFailures counts the protocol table refreshes that failed. Delegations stored
+	// meanwhile get their cycle at the next refresh.
+	ProtocolRefreshFailures = expvar.NewInt("protocol_refresh_failures_total")
+)
@@ -1,10 +1,289 @@
 package model
 
+import "time"
+
+const (
+	// HeadCheckpoint is the checkpoint advanced by the Poller while following the chain head.
+	HeadCheckpoint = "head"
+	// BackfillCheckpoint is the checkpoint of an interrupted historical backfill.
+	BackfillCheckpoint = "backfill"
+)
+
+// DelegationKind tells a first delegation, a switch between bakers and an undelegation apart.
+type DelegationKind string
+
+const (
+	KindDelegate   DelegationKind = "delegate"
+	KindRedelegate DelegationKind = "redelegate"
+	KindUndelegate DelegationKind = "undelegate"
+)
+
+// KindOf derives the kind of a delegation operation from its previous and new baker.
+func KindOf(prevBaker string, baker string) DelegationKind {
+	switch {
+	case baker == "":
+		return KindUndelegate
+	case prevBaker != "":
+		return KindRedelegate
+	default:
+		return KindDelegate
+	}
+}
+
+func (k DelegationKind) Valid() bool {
+	return k == KindDelegate || k == KindRedelegate || k == KindUndelegate
+}
+
+// Operation statuses reported by TzKT. Only applied operations took effect on chain.
+const (
+	StatusApplied     = "applied"
+	StatusFailed      = "failed"
+	StatusBacktracked = "backtracked"
+	StatusSkipped     = "skipped"
+)
+
+func ValidStatus(status string) bool {
+	switch status {
+	case StatusApplied, StatusFailed, StatusBacktracked, StatusSkipped:
+		return true
+	}
+	return false
+}
+
 type Delegation struct {
-	ID        int    `gorm:"primaryKey" json:"id"`
-	Timestamp string `gorm:"index:idx_year_timestamp" json:"timestamp"`
-	Amount    int    `json:"amount"`
-	Delegator string `json:"address"`
+	ID        int            `gorm:"primaryKey" json:"id"`
+	Timestamp string         `gorm:"index:idx_year_timestamp" json:"timestamp"`
+	Amount    int            `json:"amount"`
+	Delegator string         `json:"address"`
+	Level     int            `gorm:"index" json:"level"`
+	Year      int            `gorm:"index:idx_year_timestamp" json:"year"`
+	Baker     string         `gorm:"index" json:"baker"`
+	PrevBaker string         `json:"prevBaker"`
+	Hash      string         `json:"hash"`
+	Block     string         `json:"block"`
+	Counter   int            `json:"counter"`
+	Status    string         `gorm:"index" json:"status"`
+	BakerFee  int            `json:"bakerFee"`
+	GasUsed   int            `json:"gasUsed"`
+	Kind      DelegationKind `gorm:"index" json:"kind"`
+	// Cycle and Protocol are derived from the level once the protocols are known, see Protocols.
+	Cycle    *int   `gorm:"index" json:"cycle"`
+	Protocol string `json:"protocol"`
+}
+
+// Protocol is an amendment of the Tezos protocol: the levels it ran and the cycles it set.
+type Protocol struct {
+	Code       int `gorm:"primaryKey;autoIncrement:false"`
+	Hash       string
+	FirstLevel int
+	// LastLevel is zero while the protocol is the current one.
+	LastLevel int
+	// FirstCycle starts at FirstCycleLevel, with BlocksPerCycle levels per cycle from there on.
+	FirstCycle      int
+	FirstCycleLevel int
+	BlocksPerCycle  int
+}
+
+// Protocols are the protocols of the chain, ordered by first level.
+type Protocols []Protocol
+
+// At returns the protocol running the level, which the last one is assumed to run from its first
+// level on.
+func (p Protocols) At(level int) (Protocol, bool) {
+	for i := len(p) - 1; i >= 0; i-- {
+		if p[i].FirstLevel <= level {
+			return p[i], true
+		}
+	}
+	return Protocol{}, false
+}
+
+// CycleOf returns the cycle of the level. A protocol may be activated within a cycle, which then
+// runs on with the length set by the previous protocol until its first cycle starts.
+func (p Protocols) CycleOf(level int) (int, bool) {
+	for i := len(p) - 1; i >= 0; i-- {
+		if p[i].FirstCycleLevel <= level && p[i].BlocksPerCycle > 0 {
+			return p[i].FirstCycle + (level-p[i].FirstCycleLevel)/p[i].BlocksPerCycle, true
+		}
+	}
+	return 0, false
+}
+
+// LastLevelOfCycle returns the last level of the cycle of the level, as long as the protocol keeps
+// its cycle length.
+func (p Protocols) LastLevelOfCycle(level int) (int, bool) {
+	for i := len(p) - 1; i >= 0; i-- {
+		if p[i].FirstCycleLevel <= level && p[i].BlocksPerCycle > 0 {
+			cycles := (level-p[i].FirstCycleLevel)/p[i].BlocksPerCycle + 1
+			return p[i].FirstCycleLevel + cycles*p[i].BlocksPerCycle - 1, true
+		}
+	}
+	return 0, false
+}
+
+// Derive sets the cycle and protocol of the delegation, when known.
+func (p Protocols) Derive(d *Delegation) {
+	protocol, ok := p.At(d.Level)
+	cycle, known := p.CycleOf(d.Level)
+	if !ok || !known {
+		return
+	}
+	d.Cycle = &cycle
+	d.Protocol = protocol.Hash
+}
+
+// CurrentDelegation is the delegation state of an address, folded from its applied delegation
+// operations: the baker it delegates to since its last one, or none after an undelegation.
+type CurrentDelegation struct {
+	Delegator string `gorm:"primaryKey"`
+	Baker     string
+	Level     int
+	Timestamp string
+	Amount    int
+	// DelegationID is the operation the state was set by; older ones no longer change it.
+	DelegationID int
+}
+
+// CurrentDelegationOf returns the state an applied delegation operation leaves its delegator in.
+func CurrentDelegationOf(d Delegation) CurrentDelegation {
+	return CurrentDelegation{
+		Delegator:    d.Delegator,
+		Baker:        d.Baker,
+		Level:        d.Level,
+		Timestamp:    d.Timestamp,
+		Amount:       d.Amount,
+		DelegationID: d.ID,
+	}
+}
+
+// BakerSort is the figure bakers are ranked by, highest first.
+type BakerSort string
+
+const (
+	RankByDelegators BakerSort = "delegators"
+	RankByAmount     BakerSort = "amount"
+)
+
+func (s BakerSort) Valid() bool {
+	return s == RankByDelegators || s == RankByAmount
+}
+
+// BakerQuery selects the page of the baker ranking to list, from the delegation state at a level
+// or at a timestamp, both inclusive, or from the current state when neither is set.
+type BakerQuery struct {
+	Level     int
+	Timestamp string
+	Sort      BakerSort
+	Offset    int
+	Limit     int
+}
+
+// BakerStats are the delegators of a baker and the amount they delegated to it.
+type BakerStats struct {
+	Baker      string
+	Delegators int
+	Amount     int
+}
+
+// StatsInterval is the period delegation statistics are bucketed by.
+type StatsInterval string
+
+const (
+	IntervalDay   StatsInterval = "day"
+	IntervalWeek  StatsInterval = "week"
+	IntervalMonth StatsInterval = "month"
+	IntervalCycle StatsInterval = "cycle"
+)
+
+func (i StatsInterval) Valid() bool {
+	return i == IntervalDay || i == IntervalWeek || i == IntervalMonth || i == IntervalCycle
+}
+
+// StatsQuery selects the applied delegations to aggregate, From inclusive and To exclusive, as UTC
+// RFC3339 timestamps.
+type StatsQuery struct {
+	Interval StatsInterval
+	From     string
+	To       string
+}
+
+// DelegationStats aggregates the applied delegations of a bucket, named after the date it starts
+// on: weeks start on Monday, months on their first day. Cycle buckets are named after the cycle.
+type DelegationStats struct {
+	Bucket        string
+	Delegations   int
+	TotalAmount   int
+	MedianAmount  float64
+	Delegators    int
+	Undelegations int
+}
+
+// DefaultPageSize is how many delegations are listed when a query sets no limit.
+const DefaultPageSize = 50
+
+// SortField is the field delegations are listed by, ties broken by ID in the same direction.
+type SortField string
+
+const (
+	SortByTimestamp SortField = "timestamp"
+	SortByAmount    SortField = "amount"
+	SortByLevel     SortField = "level"
+)
+
+func (f SortField) Valid() bool {
+	return f == SortByTimestamp || f == SortByAmount || f == SortByLevel
+}
+
+// Cursor is the position of a delegation in a listing: its value of every sort field, and its ID
+// breaking ties.
+type Cursor struct {
+	Timestamp string
+	Amount    int
+	Level     int
+	ID        int
+}
+
+// CursorOf returns the position of the delegation, to resume a listing after it.
+func CursorOf(d Delegation) Cursor {
+	return Cursor{Timestamp: d.Timestamp, Amount: d.Amount, Level: d.Level, ID: d.ID}
+}
+
+// DelegationQuery selects the delegations to list. Zero values leave a filter out.
+type DelegationQuery struct {
+	Year      int
+	Offset    int
+	Limit     int
+	Kind      DelegationKind
+	Status    string
+	Delegator string
+	Baker     string
+	Hash      string
+	// MinLevel and MaxLevel bound the level, inclusive.
+	MinLevel int
+	MaxLevel int
+	// From and To bound the timestamp, From inclusive and To exclusive, as RFC3339 UTC timestamps.
+	From string
+	To   string
+	// MinAmount and MaxAmount bound the amount, inclusive.
+	MinAmount int
+	MaxAmount int
+	// Cycle keeps the delegations of a cycle when set.
+	Cycle *int
+	// Sort is the field to list by, the timestamp by default, newest first unless Ascending.
+	Sort      SortField
+	Ascending bool
+	// After lists the delegations following this one, the last of the previous page.
+	After Cursor
+}
+
+// SyncCheckpoint records how far a sync stream has ingested delegations from its source.
+type SyncCheckpoint struct {
+	Name      string `gorm:"primaryKey" json:"name"`
+	LastID    int    `json:"lastId"`
 	Level     int    `json:"level"`
-	Year      int    `gorm:"index:idx_year_timestamp" json:"year"`
+	Timestamp string `json:"timestamp"`
+	Source    string `json:"source"`
+	// FromLevel is the level the stream was started from, zero for genesis or a timestamp.
+	FromLevel int       `json:"fromLevel"`
+	UpdatedAt time.Time `json:"updatedAt"`
 }
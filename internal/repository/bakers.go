@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"context"
+
+	"tezos-delegation-service/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// GetBakers ranks the bakers by their delegators, or by the amount delegated to them. The current
+// ranking is aggregated from current_delegations; a past one folds the delegations up to the level
+// or timestamp of the query first, which scans every delegation stored until then.
+func (d *Database) GetBakers(ctx context.Context, query model.BakerQuery) ([]model.BakerStats, error) {
+	db := d.db.WithContext(ctx)
+	var stats []model.BakerStats
+
+	order := "delegators DESC, amount DESC, baker"
+	if query.Sort == model.RankByAmount {
+		order = "amount DESC, delegators DESC, baker"
+	}
+	limit := query.Limit
+	if limit <= 0 {
+		limit = model.DefaultPageSize
+	}
+
+	err := delegationState(db, query.Level, query.Timestamp).
+		Select("baker, COUNT(*) AS delegators, SUM(amount) AS amount").
+		Where("baker <> ''").
+		Group("baker").
+		Order(order).
+		Offset(query.Offset).
+		Limit(limit).
+		Scan(&stats).Error
+
+	return stats, err
+}
+
+// GetBaker returns the current delegators of the baker and the amount they delegated to it.
+func (d *Database) GetBaker(ctx context.Context, baker string) (model.BakerStats, error) {
+	var stats model.BakerStats
+
+	err := d.db.WithContext(ctx).Model(&model.CurrentDelegation{}).
+		Select("COUNT(*) AS delegators, COALESCE(SUM(amount), 0) AS amount").
+		Where("baker = ?", baker).
+		Scan(&stats).Error
+
+	stats.Baker = baker
+	return stats, err
+}
+
+// GetBakerDelegators lists the addresses currently delegating to the baker, largest amount first.
+func (d *Database) GetBakerDelegators(ctx context.Context, baker string, limit int, offset int) ([]model.CurrentDelegation, error) {
+	var delegators []model.CurrentDelegation
+
+	if limit <= 0 {
+		limit = model.DefaultPageSize
+	}
+	err := d.db.WithContext(ctx).
+		Where("baker = ?", baker).
+		Order("amount DESC, delegator").
+		Offset(offset).
+		Limit(limit).
+		Find(&delegators).Error
+
+	return delegators, err
+}
+
+// delegationState selects the delegation state of every address: the current one when neither the
+// level nor the timestamp is set, otherwise the last applied delegation of each up to them.
+func delegationState(db *gorm.DB, level int, timestamp string) *gorm.DB {
+	if level == 0 && timestamp == "" {
+		return db.Model(&model.CurrentDelegation{})
+	}
+
+	latest := db.Model(&model.Delegation{}).
+		Select("MAX(id)").
+		Where("status = ? AND delegator <> ''", model.StatusApplied)
+	if level != 0 {
+		latest = latest.Where("level <= ?", level)
+	}
+	if timestamp != "" {
+		latest = latest.Where("timestamp <= ?", timestamp)
+	}
+	return db.Model(&model.Delegation{}).Where("id IN (?)", latest.Group("delegator"))
+}
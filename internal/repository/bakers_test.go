@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"tezos-delegation-service/internal/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabase_Bakers(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
+		ctx := context.Background()
+
+		applied := func(id int, timestamp string, level int, delegator, baker string, amount int) model.Delegation {
+			return model.Delegation{ID: id, Timestamp: timestamp, Year: 2023, Level: level, Delegator: delegator, Baker: baker, Amount: amount, Status: model.StatusApplied}
+		}
+		err := testDB.SaveBatch(ctx, []model.Delegation{
+			applied(1, "2023-01-01T00:00:00Z", 100, "tz1a", "tz1big", 100),
+			applied(2, "2023-01-01T00:00:00Z", 100, "tz1b", "tz1big", 200),
+			applied(3, "2023-01-02T00:00:00Z", 110, "tz1c", "tz1rich", 5000),
+			applied(4, "2023-01-03T00:00:00Z", 120, "tz1d", "tz1big", 50),
+			// at level 130, tz1a moves to tz1rich and tz1b undelegates
+			applied(5, "2023-01-04T00:00:00Z", 130, "tz1a", "tz1rich", 150),
+			applied(6, "2023-01-04T00:00:00Z", 130, "tz1b", "", 200),
+			{ID: 7, Timestamp: "2023-01-05T00:00:00Z", Year: 2023, Level: 140, Delegator: "tz1c", Baker: "tz1big", Amount: 5000, Status: model.StatusFailed},
+		})
+		require.NoError(t, err)
+
+		tests := []struct {
+			name     string
+			query    model.BakerQuery
+			expected []model.BakerStats
+		}{
+			{
+				name:  "current by delegators",
+				query: model.BakerQuery{Sort: model.RankByDelegators},
+				expected: []model.BakerStats{
+					{Baker: "tz1rich", Delegators: 2, Amount: 5150},
+					{Baker: "tz1big", Delegators: 1, Amount: 50},
+				},
+			},
+			{
+				name:  "at a level",
+				query: model.BakerQuery{Level: 120, Sort: model.RankByDelegators},
+				expected: []model.BakerStats{
+					{Baker: "tz1big", Delegators: 3, Amount: 350},
+					{Baker: "tz1rich", Delegators: 1, Amount: 5000},
+				},
+			},
+			{
+				name:  "at a date by amount",
+				query: model.BakerQuery{Timestamp: "2023-01-03T00:00:00Z", Sort: model.RankByAmount},
+				expected: []model.BakerStats{
+					{Baker: "tz1rich", Delegators: 1, Amount: 5000},
+					{Baker: "tz1big", Delegators: 3, Amount: 350},
+				},
+			},
+			{
+				name:     "paged",
+				query:    model.BakerQuery{Level: 120, Sort: model.RankByDelegators, Offset: 1, Limit: 1},
+				expected: []model.BakerStats{{Baker: "tz1rich", Delegators: 1, Amount: 5000}},
+			},
+			{
+				name:  "before any delegation",
+				query: model.BakerQuery{Level: 99},
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				stats, err := testDB.GetBakers(ctx, tt.query)
+				require.NoError(t, err)
+				assert.Equal(t, tt.expected, stats)
+			})
+		}
+
+		stats, err := testDB.GetBaker(ctx, "tz1rich")
+		require.NoError(t, err)
+		assert.Equal(t, model.BakerStats{Baker: "tz1rich", Delegators: 2, Amount: 5150}, stats)
+
+		stats, err = testDB.GetBaker(ctx, "tz1idle")
+		require.NoError(t, err)
+		assert.Equal(t, model.BakerStats{Baker: "tz1idle"}, stats)
+
+		delegators, err := testDB.GetBakerDelegators(ctx, "tz1rich", 10, 0)
+		require.NoError(t, err)
+		require.Len(t, delegators, 2)
+		assert.Equal(t, "tz1c", delegators[0].Delegator)
+		assert.Equal(t, "tz1a", delegators[1].Delegator)
+		assert.Equal(t, 130, delegators[1].Level)
+
+		delegators, err = testDB.GetBakerDelegators(ctx, "tz1rich", 1, 1)
+		require.NoError(t, err)
+		require.Len(t, delegators, 1)
+		assert.Equal(t, "tz1a", delegators[0].Delegator)
+	})
+}
@@ -0,0 +1,73 @@
+package repository
+
+import (
+	"context"
+
+	"tezos-delegation-service/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrNotFound is returned when the record looked up does not exist.
+var ErrNotFound = gorm.ErrRecordNotFound
+
+// GetCurrentDelegation returns the delegation state of the address, or ErrNotFound when none of
+// its delegation operations was applied.
+func (d *Database) GetCurrentDelegation(ctx context.Context, delegator string) (model.CurrentDelegation, error) {
+	var current model.CurrentDelegation
+
+	err := d.db.WithContext(ctx).Where("delegator = ?", delegator).First(&current).Error
+
+	return current, err
+}
+
+// foldCurrent updates the delegation state of the delegators of a stored batch: the last applied
+// operation of each sets it, unless a later one already did. Batches may then be stored in any
+// order, as a backfill does behind the head.
+func foldCurrent(tx *gorm.DB, delegations []model.Delegation) error {
+	latest := make(map[string]model.Delegation)
+	for _, d := range delegations {
+		if d.Status != model.StatusApplied || d.Delegator == "" {
+			continue
+		}
+		if last, ok := latest[d.Delegator]; !ok || d.ID > last.ID {
+			latest[d.Delegator] = d
+		}
+	}
+	if len(latest) == 0 {
+		return nil
+	}
+
+	current := make([]model.CurrentDelegation, 0, len(latest))
+	for _, d := range latest {
+		current = append(current, model.CurrentDelegationOf(d))
+	}
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "delegator"}},
+		DoUpdates: clause.AssignmentColumns([]string{"baker", "level", "timestamp", "amount", "delegation_id"}),
+		Where:     clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "excluded.delegation_id > current_delegations.delegation_id"}}},
+	}).Create(&current).Error
+}
+
+// refoldCurrent rebuilds the delegation state of the delegators from the delegations left once
+// later ones were deleted. Those left without an applied operation have none.
+func refoldCurrent(tx *gorm.DB, delegators []string) error {
+	if len(delegators) == 0 {
+		return nil
+	}
+	if err := tx.Where("delegator IN ?", delegators).Delete(&model.CurrentDelegation{}).Error; err != nil {
+		return err
+	}
+
+	var latest []model.Delegation
+	err := tx.Where("id IN (?)", tx.Model(&model.Delegation{}).
+		Select("MAX(id)").
+		Where("status = ? AND delegator IN ?", model.StatusApplied, delegators).
+		Group("delegator")).
+		Find(&latest).Error
+	if err != nil {
+		return err
+	}
+	return foldCurrent(tx, latest)
+}
@@ -0,0 +1,443 @@
+package repository
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Migrations are numbered SQL files, NNNN_name.up.sql and NNNN_name.down.sql, applied in order,
+// in a directory per dialect. The versions applied to a database are recorded in schema_migrations.
+//
+//go:embed migrations
+var migrationFiles embed.FS
+
+const (
+	// MigrateLatest migrates up to the newest embedded migration.
+	MigrateLatest = -1
+
+	defaultLockTimeout = time.Minute
+	// defaultStaleLock is how old a lock must be before it is considered abandoned by a crashed
+	// migrator and taken over. Its holder refreshes it every third of that.
+	defaultStaleLock = 10 * time.Minute
+	lockPollInterval = 200 * time.Millisecond
+
+	// migrationLockKey is the PostgreSQL advisory lock migrators take, an arbitrary constant.
+	migrationLockKey = 7_316_204_917
+)
+
+// ErrMigrationLocked is returned when another migrator kept the lock for the whole lock timeout.
+var ErrMigrationLocked = errors.New("database is being migrated by another process")
+
+// ErrMigrationLockLost is returned when the lock was lost while migrating, before the migration
+// being applied was recorded.
+var ErrMigrationLockLost = errors.New("migration lock was lost")
+
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a numbered schema change and the SQL undoing it.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStep is a migration to apply in one direction.
+type MigrationStep struct {
+	Version   int
+	Name      string
+	Direction string
+	SQL       string
+}
+
+type schemaMigration struct {
+	Version   int `gorm:"primaryKey"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (schemaMigration) TableName() string { return "schema_migrations" }
+
+type migrationLock struct {
+	ID         int `gorm:"primaryKey"`
+	Owner      string
+	AcquiredAt time.Time
+}
+
+func (migrationLock) TableName() string { return "schema_migrations_lock" }
+
+// heldLock is a migration lock being held. verify fails the transaction of a step when the lock was
+// lost meanwhile, release gives it up.
+type heldLock struct {
+	verify  func(tx *gorm.DB) error
+	release func()
+}
+
+type migrateOptions struct {
+	dryRun      bool
+	lockTimeout time.Duration
+	staleLock   time.Duration
+}
+
+type MigrateOption func(*migrateOptions)
+
+// WithDryRun plans the migrations without applying them or taking the lock.
+func WithDryRun() MigrateOption {
+	return func(o *migrateOptions) {
+		o.dryRun = true
+	}
+}
+
+// WithLockTimeout sets how long to wait for another migrator to release the lock.
+func WithLockTimeout(timeout time.Duration) MigrateOption {
+	return func(o *migrateOptions) {
+		o.lockTimeout = timeout
+	}
+}
+
+// Migrations returns the embedded migrations of the dialect, ordered by version.
+func Migrations(dialect Dialect) ([]Migration, error) {
+	dir := path.Join("migrations", string(dialect))
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := migrationName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		content, err := fs.ReadFile(migrationFiles, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m := byVersion[version]
+		if m == nil {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names, %q and %q", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d needs both an up and a down file", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration %d is missing", i+1)
+		}
+	}
+	return migrations, nil
+}
+
+// SchemaVersion returns the version of the last migration applied to the database, zero when none
+// was.
+func (d *Database) SchemaVersion(ctx context.Context) (int, error) {
+	db := d.db.WithContext(ctx)
+	if !db.Migrator().HasTable(&schemaMigration{}) {
+		return 0, nil
+	}
+
+	var version int
+	err := db.Model(&schemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error
+	return version, err
+}
+
+// Migrate brings the schema to the target version, applying the up migrations above the current
+// version or the down migrations down to the target, each in its own transaction. It returns the
+// steps applied, or only planned with WithDryRun. Concurrent migrators wait for each other.
+func (d *Database) Migrate(ctx context.Context, target int, opts ...MigrateOption) ([]MigrationStep, error) {
+	o := migrateOptions{lockTimeout: defaultLockTimeout, staleLock: defaultStaleLock}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	migrations, err := Migrations(d.dialect)
+	if err != nil {
+		return nil, err
+	}
+	latest := migrations[len(migrations)-1].Version
+	if target == MigrateLatest {
+		target = latest
+	}
+	if target < 0 || target > latest {
+		return nil, fmt.Errorf("unknown schema version %d, expected 0 to %d", target, latest)
+	}
+
+	db := d.db.WithContext(ctx)
+	var lock *heldLock
+	if !o.dryRun {
+		if err := createMigrationTables(db); err != nil {
+			return nil, err
+		}
+		acquire := acquireMigrationLock
+		if d.dialect == Postgres {
+			acquire = acquireAdvisoryLock
+		}
+		held, err := acquire(ctx, db, o)
+		if err != nil {
+			return nil, err
+		}
+		lock = held
+		defer lock.release()
+	}
+
+	// read the version once the lock is held, another migrator may just have moved it
+	current, err := d.SchemaVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	steps := plan(migrations, current, target)
+	if current == 0 && len(steps) > 0 {
+		adoption, err := d.adoptLegacy(db)
+		if err != nil {
+			return nil, err
+		}
+		steps[0].SQL = adoption + steps[0].SQL
+	}
+	if o.dryRun {
+		return steps, nil
+	}
+
+	for _, step := range steps {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec(step.SQL).Error; err != nil {
+				return err
+			}
+			if err := lock.verify(tx); err != nil {
+				return err
+			}
+			if step.Direction == "down" {
+				return tx.Delete(&schemaMigration{}, step.Version).Error
+			}
+			return tx.Create(&schemaMigration{Version: step.Version, Name: step.Name, AppliedAt: time.Now().UTC()}).Error
+		})
+		if err != nil {
+			return nil, fmt.Errorf("migration %d %s %s: %w", step.Version, step.Name, step.Direction, err)
+		}
+	}
+	return steps, nil
+}
+
+// plan lists the steps moving the schema from the current version to the target one.
+func plan(migrations []Migration, current, target int) []MigrationStep {
+	var steps []MigrationStep
+	if target >= current {
+		for _, m := range migrations {
+			if m.Version > current && m.Version <= target {
+				steps = append(steps, MigrationStep{Version: m.Version, Name: m.Name, Direction: "up", SQL: m.Up})
+			}
+		}
+		return steps
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version <= current && m.Version > target {
+			steps = append(steps, MigrationStep{Version: m.Version, Name: m.Name, Direction: "down", SQL: m.Down})
+		}
+	}
+	return steps
+}
+
+// legacyColumns are the columns AutoMigrate added to delegations after the table was first
+// created, before migrations existed. The list is frozen: later columns belong in migrations.
+var legacyColumns = []struct{ name, kind string }{
+	{"baker", "text"},
+	{"prev_baker", "text"},
+	{"hash", "text"},
+	{"block", "text"},
+	{"counter", "integer"},
+	{"status", "text"},
+	{"baker_fee", "integer"},
+	{"gas_used", "integer"},
+	{"kind", "text"},
+}
+
+// adoptLegacy returns the statements bringing a delegations table created by an older AutoMigrate
+// to the shape of the first migration, which can then be applied to it. Only SQLite databases
+// predate migrations.
+func (d *Database) adoptLegacy(db *gorm.DB) (string, error) {
+	if d.dialect != SQLite || !db.Migrator().HasTable("delegations") {
+		return "", nil
+	}
+
+	var sql string
+	for _, column := range legacyColumns {
+		if !db.Migrator().HasColumn("delegations", column.name) {
+			sql += fmt.Sprintf("ALTER TABLE `delegations` ADD COLUMN `%s` %s;\n", column.name, column.kind)
+		}
+	}
+	return sql, nil
+}
+
+func createMigrationTables(db *gorm.DB) error {
+	return db.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL
+		);
+		CREATE TABLE IF NOT EXISTS schema_migrations_lock (
+			id INTEGER PRIMARY KEY,
+			owner TEXT NOT NULL,
+			acquired_at TIMESTAMP NOT NULL
+		);
+	`).Error
+}
+
+// acquireMigrationLock takes the single row of schema_migrations_lock, waiting up to the lock
+// timeout for its holder to release it. A lock older than staleLock was left by a migrator that
+// crashed and is taken over, so the holder refreshes it while migrating and checks it still owns it
+// before recording each migration.
+func acquireMigrationLock(ctx context.Context, db *gorm.DB, o migrateOptions) (*heldLock, error) {
+	hostname, _ := os.Hostname()
+	owner := fmt.Sprintf("%s:%d:%d", hostname, os.Getpid(), time.Now().UnixNano())
+	deadline := time.Now().Add(o.lockTimeout)
+
+	for {
+		lock := migrationLock{ID: 1, Owner: owner, AcquiredAt: time.Now().UTC()}
+		result := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&lock)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 1 {
+			return holdMigrationLock(db, owner, o.staleLock), nil
+		}
+
+		var holder migrationLock
+		if err := db.First(&holder, 1).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if holder.Owner != "" && time.Since(holder.AcquiredAt) > o.staleLock {
+			db.Where("id = ? AND owner = ?", 1, holder.Owner).Delete(&migrationLock{})
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%w: locked by %s since %s", ErrMigrationLocked, holder.Owner, holder.AcquiredAt.Format(time.RFC3339))
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
+
+// holdMigrationLock refreshes the lock row of owner every third of staleLock until released.
+func holdMigrationLock(db *gorm.DB, owner string, staleLock time.Duration) *heldLock {
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(staleLock / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				// a failed refresh is retried on the next tick, well before the lock turns stale
+				db.Model(&migrationLock{}).Where("id = ? AND owner = ?", 1, owner).Update("acquired_at", time.Now().UTC())
+			}
+		}
+	}()
+
+	return &heldLock{
+		verify: func(tx *gorm.DB) error {
+			var holder migrationLock
+			if err := tx.First(&holder, 1).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+			if holder.Owner != owner {
+				return fmt.Errorf("%w: taken over by %q", ErrMigrationLockLost, holder.Owner)
+			}
+			return nil
+		},
+		release: func() {
+			close(stop)
+			<-stopped
+			db.Where("id = ? AND owner = ?", 1, owner).Delete(&migrationLock{})
+		},
+	}
+}
+
+// acquireAdvisoryLock takes the migration advisory lock of PostgreSQL on a connection of its own,
+// waiting up to the lock timeout for another migrator to release it. PostgreSQL releases the lock
+// when that connection drops, so a crashed migrator never leaves it behind and it never turns stale.
+func acquireAdvisoryLock(ctx context.Context, db *gorm.DB, o migrateOptions) (*heldLock, error) {
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(o.lockTimeout)
+
+	for {
+		var acquired bool
+		if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", migrationLockKey).Scan(&acquired); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if acquired {
+			return &heldLock{
+				verify: func(*gorm.DB) error {
+					// the lock lives as long as its connection
+					if err := conn.PingContext(ctx); err != nil {
+						return fmt.Errorf("%w: %v", ErrMigrationLockLost, err)
+					}
+					return nil
+				},
+				release: func() {
+					conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockKey)
+					conn.Close()
+				},
+			}, nil
+		}
+
+		if time.Now().After(deadline) {
+			conn.Close()
+			return nil, fmt.Errorf("%w: advisory lock %d is held by another session", ErrMigrationLocked, migrationLockKey)
+		}
+		select {
+		case <-ctx.Done():
+			conn.Close()
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
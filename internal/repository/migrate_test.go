@@ -0,0 +1,244 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"tezos-delegation-service/internal/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newLegacyDatabase creates a database as AutoMigrate left it before migrations existed: the
+// original delegations columns plus the given ones, holding the rows inserted by insert.
+func newLegacyDatabase(t *testing.T, columns string, insert string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "legacy.db")
+
+	d, err := Open(path)
+	require.NoError(t, err)
+
+	create := "CREATE TABLE `delegations` (`id` integer PRIMARY KEY AUTOINCREMENT,`timestamp` text,`amount` integer,`delegator` text,`level` integer,`year` integer"
+	if columns != "" {
+		create += "," + columns
+	}
+	require.NoError(t, d.db.Exec(create+")").Error)
+	if insert != "" {
+		require.NoError(t, d.db.Exec(insert).Error)
+	}
+	return path
+}
+
+func latestVersion(t *testing.T, dialect Dialect) int {
+	t.Helper()
+	migrations, err := Migrations(dialect)
+	require.NoError(t, err)
+	return migrations[len(migrations)-1].Version
+}
+
+func TestMigrations(t *testing.T) {
+	sqlite, err := Migrations(SQLite)
+	require.NoError(t, err)
+	require.NotEmpty(t, sqlite)
+
+	for i, m := range sqlite {
+		assert.Equal(t, i+1, m.Version)
+		assert.NotEmpty(t, m.Name)
+		assert.NotEmpty(t, m.Up)
+		assert.NotEmpty(t, m.Down)
+	}
+
+	// both dialects go through the same versions
+	postgres, err := Migrations(Postgres)
+	require.NoError(t, err)
+	require.Len(t, postgres, len(sqlite))
+	for i, m := range postgres {
+		assert.Equal(t, sqlite[i].Version, m.Version)
+		assert.Equal(t, sqlite[i].Name, m.Name)
+	}
+}
+
+func TestMigrate_NewDatabase(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		d, err := NewDatabase(testDSN(t, dialect))
+		require.NoError(t, err)
+
+		version, err := d.SchemaVersion(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, latestVersion(t, dialect), version)
+
+		var locks int64
+		require.NoError(t, d.db.Model(&migrationLock{}).Count(&locks).Error)
+		assert.Zero(t, locks, "expected the lock to be released")
+
+		// migrating an up to date database does nothing
+		steps, err := d.Migrate(context.Background(), MigrateLatest)
+		require.NoError(t, err)
+		assert.Empty(t, steps)
+	})
+}
+
+func TestMigrate_AdoptsLegacyDatabase(t *testing.T) {
+	path := newLegacyDatabase(t, "", "INSERT INTO delegations (id, timestamp, amount, delegator, level, year) VALUES (7, '2021-05-01T00:00:00Z', 100, 'tz1a', 1000, 2021)")
+
+	d, err := NewDatabase(path)
+	require.NoError(t, err)
+
+	for _, column := range legacyColumns {
+		assert.True(t, d.db.Migrator().HasColumn("delegations", column.name), column.name)
+	}
+	assert.True(t, d.db.Migrator().HasTable(&model.SyncCheckpoint{}))
+
+	delegations, err := d.GetDelegations(context.Background(), model.DelegationQuery{Year: 2021})
+	require.NoError(t, err)
+	require.Len(t, delegations, 1)
+	assert.Equal(t, 7, delegations[0].ID)
+	assert.Equal(t, model.StatusApplied, delegations[0].Status)
+
+	// the delegation state is folded from the delegations already stored
+	current, err := d.GetCurrentDelegation(context.Background(), "tz1a")
+	require.NoError(t, err)
+	assert.Equal(t, 7, current.DelegationID)
+	assert.Equal(t, 1000, current.Level)
+}
+
+func TestMigrate_DryRun(t *testing.T) {
+	path := newLegacyDatabase(t, "`baker` text", "")
+	d, err := Open(path)
+	require.NoError(t, err)
+
+	steps, err := d.Migrate(context.Background(), MigrateLatest, WithDryRun())
+	require.NoError(t, err)
+	require.Len(t, steps, latestVersion(t, SQLite))
+	assert.Equal(t, "up", steps[0].Direction)
+	assert.Contains(t, steps[0].SQL, "ADD COLUMN `kind`")
+	assert.NotContains(t, steps[0].SQL, "ADD COLUMN `baker`")
+
+	version, err := d.SchemaVersion(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, version)
+	assert.False(t, d.db.Migrator().HasColumn("delegations", "kind"))
+	assert.False(t, d.db.Migrator().HasTable(&schemaMigration{}))
+}
+
+func TestMigrate_Down(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		d, err := NewDatabase(testDSN(t, dialect))
+		require.NoError(t, err)
+		ctx := context.Background()
+
+		steps, err := d.Migrate(ctx, 0)
+		require.NoError(t, err)
+		require.Len(t, steps, latestVersion(t, dialect))
+		assert.Equal(t, "down", steps[0].Direction)
+		assert.Equal(t, latestVersion(t, dialect), steps[0].Version)
+		assert.False(t, d.db.Migrator().HasTable("delegations"))
+
+		version, err := d.SchemaVersion(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, version)
+
+		_, err = d.Migrate(ctx, MigrateLatest)
+		require.NoError(t, err)
+		assert.True(t, d.db.Migrator().HasTable("delegations"))
+	})
+}
+
+func TestMigrate_UnknownVersion(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		d, err := Open(testDSN(t, dialect))
+		require.NoError(t, err)
+
+		_, err = d.Migrate(context.Background(), latestVersion(t, dialect)+1)
+		assert.Error(t, err)
+	})
+}
+
+func TestMigrate_Lock(t *testing.T) {
+	d, err := Open(testDSN(t, SQLite))
+	require.NoError(t, err)
+	require.NoError(t, createMigrationTables(d.db))
+	ctx := context.Background()
+
+	require.NoError(t, d.db.Create(&migrationLock{ID: 1, Owner: "other", AcquiredAt: time.Now().UTC()}).Error)
+
+	_, err = d.Migrate(ctx, MigrateLatest, WithLockTimeout(300*time.Millisecond))
+	assert.True(t, errors.Is(err, ErrMigrationLocked), "expected ErrMigrationLocked, got %v", err)
+	assert.True(t, err != nil && strings.Contains(err.Error(), "other"))
+
+	version, err := d.SchemaVersion(ctx)
+	require.NoError(t, err)
+	assert.Zero(t, version)
+
+	// a crashed migrator's lock is taken over once stale
+	require.NoError(t, d.db.Model(&migrationLock{}).Where("id = 1").Update("acquired_at", time.Now().UTC().Add(-time.Hour)).Error)
+
+	_, err = d.Migrate(ctx, MigrateLatest, WithLockTimeout(300*time.Millisecond))
+	require.NoError(t, err)
+
+	version, err = d.SchemaVersion(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, latestVersion(t, SQLite), version)
+}
+
+func TestMigrate_LockRefreshedWhileHeld(t *testing.T) {
+	d, err := Open(testDSN(t, SQLite))
+	require.NoError(t, err)
+	require.NoError(t, createMigrationTables(d.db))
+	ctx := context.Background()
+	o := migrateOptions{lockTimeout: 100 * time.Millisecond, staleLock: 300 * time.Millisecond}
+
+	held, err := acquireMigrationLock(ctx, d.db, o)
+	require.NoError(t, err)
+
+	// a migration running longer than staleLock keeps the lock
+	time.Sleep(2 * o.staleLock)
+	_, err = acquireMigrationLock(ctx, d.db, o)
+	assert.ErrorIs(t, err, ErrMigrationLocked)
+	require.NoError(t, d.db.Transaction(held.verify))
+
+	// a lock taken over is not used to record migrations
+	require.NoError(t, d.db.Model(&migrationLock{}).Where("id = 1").Update("owner", "other").Error)
+	assert.ErrorIs(t, d.db.Transaction(held.verify), ErrMigrationLockLost)
+	held.release()
+}
+
+func TestMigrate_AdvisoryLock(t *testing.T) {
+	d, err := Open(testDSN(t, Postgres))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	// another migrator holds the advisory lock on its own connection
+	sqlDB, err := d.db.DB()
+	require.NoError(t, err)
+	other, err := sqlDB.Conn(ctx)
+	require.NoError(t, err)
+	defer other.Close()
+	_, err = other.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockKey)
+	require.NoError(t, err)
+
+	_, err = d.Migrate(ctx, MigrateLatest, WithLockTimeout(300*time.Millisecond))
+	assert.ErrorIs(t, err, ErrMigrationLocked)
+
+	_, err = other.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockKey)
+	require.NoError(t, err)
+
+	_, err = d.Migrate(ctx, MigrateLatest, WithLockTimeout(300*time.Millisecond))
+	require.NoError(t, err)
+
+	version, err := d.SchemaVersion(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, latestVersion(t, Postgres), version)
+}
+
+func TestOpenReadOnly_RequiresMigratedSchema(t *testing.T) {
+	path := newLegacyDatabase(t, "", "")
+
+	_, err := OpenReadOnly(path)
+	assert.ErrorContains(t, err, "migrate it")
+}
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS sync_checkpoints;
+DROP TABLE IF EXISTS delegations;
@@ -0,0 +1,36 @@
+-- Delegations are appended in chain order, so timestamp and level grow with the physical order of
+-- the rows: BRIN indexes cover their range scans at a fraction of the size of a b-tree. Listing a
+-- year newest first still needs a b-tree to stop after a page.
+CREATE TABLE IF NOT EXISTS delegations (
+	id bigint PRIMARY KEY,
+	timestamp text,
+	amount bigint,
+	delegator text,
+	level bigint,
+	year bigint,
+	baker text,
+	prev_baker text,
+	hash text,
+	block text,
+	counter bigint,
+	status text,
+	baker_fee bigint,
+	gas_used bigint,
+	kind text
+);
+
+CREATE INDEX IF NOT EXISTS idx_year_timestamp_desc ON delegations (year, timestamp DESC);
+CREATE INDEX IF NOT EXISTS idx_delegations_timestamp_brin ON delegations USING brin (timestamp);
+CREATE INDEX IF NOT EXISTS idx_delegations_level_brin ON delegations USING brin (level);
+CREATE INDEX IF NOT EXISTS idx_delegations_baker ON delegations (baker);
+-- nearly every operation is applied: index only the few others, listed when filtering on status
+CREATE INDEX IF NOT EXISTS idx_delegations_not_applied ON delegations (year, timestamp DESC) WHERE status <> 'applied';
+
+CREATE TABLE IF NOT EXISTS sync_checkpoints (
+	name text PRIMARY KEY,
+	last_id bigint,
+	level bigint,
+	timestamp text,
+	source text,
+	updated_at timestamptz
+);
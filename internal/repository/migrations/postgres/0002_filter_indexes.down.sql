@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_delegations_hash;
+DROP INDEX IF EXISTS idx_delegations_delegator;
@@ -0,0 +1,3 @@
+-- listing a year by amount or by level, in either direction
+CREATE INDEX IF NOT EXISTS idx_year_amount ON delegations (year, amount);
+CREATE INDEX IF NOT EXISTS idx_year_level ON delegations (year, level);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_delegations_delegator;
+CREATE INDEX IF NOT EXISTS idx_delegations_delegator ON delegations (delegator, timestamp DESC);
@@ -0,0 +1,4 @@
+-- paging through the timeline of an address: the operation ID breaking ties within a block lets
+-- each page resume from the index alone
+DROP INDEX IF EXISTS idx_delegations_delegator;
+CREATE INDEX IF NOT EXISTS idx_delegations_delegator ON delegations (delegator, timestamp DESC, id DESC);
@@ -0,0 +1,18 @@
+-- the delegation state of every address, maintained as delegations are stored
+CREATE TABLE IF NOT EXISTS current_delegations (
+	delegator text PRIMARY KEY,
+	baker text,
+	level bigint,
+	timestamp text,
+	amount bigint,
+	delegation_id bigint
+);
+
+CREATE INDEX IF NOT EXISTS idx_current_delegations_baker ON current_delegations (baker);
+
+-- fold the delegations stored so far: the last applied operation of each address sets its state
+INSERT INTO current_delegations (delegator, baker, level, timestamp, amount, delegation_id)
+SELECT DISTINCT ON (delegator) delegator, baker, level, timestamp, amount, id
+FROM delegations
+WHERE status = 'applied' AND delegator IS NOT NULL AND delegator <> ''
+ORDER BY delegator, id DESC;
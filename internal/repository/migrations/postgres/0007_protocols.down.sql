@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_delegations_cycle;
+ALTER TABLE delegations DROP COLUMN IF EXISTS protocol;
+ALTER TABLE delegations DROP COLUMN IF EXISTS cycle;
+DROP TABLE IF EXISTS protocols;
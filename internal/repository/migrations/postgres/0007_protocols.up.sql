@@ -0,0 +1,16 @@
+-- the protocols of the chain as published by TzKT, to derive the cycle and protocol of delegations
+CREATE TABLE IF NOT EXISTS protocols (
+	code bigint PRIMARY KEY,
+	hash text,
+	first_level bigint,
+	last_level bigint,
+	first_cycle bigint,
+	first_cycle_level bigint,
+	blocks_per_cycle bigint
+);
+
+-- derived once the protocols are fetched, until then the cycle is unknown
+ALTER TABLE delegations ADD COLUMN IF NOT EXISTS cycle bigint;
+ALTER TABLE delegations ADD COLUMN IF NOT EXISTS protocol text;
+
+CREATE INDEX IF NOT EXISTS idx_delegations_cycle ON delegations (cycle);
@@ -0,0 +1,2 @@
+-- the level a backfill was started from, which a resumed run keeps
+ALTER TABLE sync_checkpoints ADD COLUMN IF NOT EXISTS from_level bigint NOT NULL DEFAULT 0;
@@ -0,0 +1,49 @@
+-- The schema AutoMigrate built before migrations existed. Every statement is a no-op on a database
+-- that already has it, so that such databases can be adopted.
+CREATE TABLE IF NOT EXISTS `delegations` (
+	`id` integer PRIMARY KEY AUTOINCREMENT,
+	`timestamp` text,
+	`amount` integer,
+	`delegator` text,
+	`level` integer,
+	`year` integer,
+	`baker` text,
+	`prev_baker` text,
+	`hash` text,
+	`block` text,
+	`counter` integer,
+	`status` text,
+	`baker_fee` integer,
+	`gas_used` integer,
+	`kind` text
+);
+
+CREATE INDEX IF NOT EXISTS `idx_year_timestamp` ON `delegations`(`timestamp`, `year`);
+CREATE INDEX IF NOT EXISTS `idx_year_timestamp_desc` ON `delegations`(`year`, `timestamp` DESC);
+CREATE INDEX IF NOT EXISTS `idx_delegations_level` ON `delegations`(`level`);
+CREATE INDEX IF NOT EXISTS `idx_delegations_baker` ON `delegations`(`baker`);
+CREATE INDEX IF NOT EXISTS `idx_delegations_status` ON `delegations`(`status`);
+CREATE INDEX IF NOT EXISTS `idx_delegations_kind` ON `delegations`(`kind`);
+
+CREATE TABLE IF NOT EXISTS `sync_checkpoints` (
+	`name` text,
+	`last_id` integer,
+	`level` integer,
+	`timestamp` text,
+	`source` text,
+	`updated_at` datetime,
+	PRIMARY KEY (`name`)
+);
+
+-- derive the kind of delegations stored before it existed; rows stored before bakers were
+-- captured (no hash) cannot be told apart and are left without a kind
+UPDATE `delegations` SET `kind` = CASE
+	WHEN `baker` IS NULL OR `baker` = '' THEN 'undelegate'
+	WHEN `prev_baker` IS NOT NULL AND `prev_baker` <> '' THEN 'redelegate'
+	ELSE 'delegate'
+END
+WHERE (`kind` IS NULL OR `kind` = '') AND `hash` IS NOT NULL AND `hash` <> '';
+
+-- operations stored before their status was captured were nearly all applied: failed
+-- delegations are rare, and hiding every older row from the default listing would be worse
+UPDATE `delegations` SET `status` = 'applied' WHERE `status` IS NULL OR `status` = '';
@@ -0,0 +1,3 @@
+-- listing the delegations of an address, newest first, and looking up an operation
+CREATE INDEX IF NOT EXISTS `idx_delegations_delegator` ON `delegations`(`delegator`, `timestamp` DESC);
+CREATE INDEX IF NOT EXISTS `idx_delegations_hash` ON `delegations`(`hash`);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS `idx_year_level`;
+DROP INDEX IF EXISTS `idx_year_amount`;
@@ -0,0 +1,22 @@
+-- the delegation state of every address, maintained as delegations are stored
+CREATE TABLE IF NOT EXISTS `current_delegations` (
+	`delegator` text,
+	`baker` text,
+	`level` integer,
+	`timestamp` text,
+	`amount` integer,
+	`delegation_id` integer,
+	PRIMARY KEY (`delegator`)
+);
+
+CREATE INDEX IF NOT EXISTS `idx_current_delegations_baker` ON `current_delegations`(`baker`);
+
+-- fold the delegations stored so far: the last applied operation of each address sets its state
+INSERT INTO `current_delegations` (`delegator`, `baker`, `level`, `timestamp`, `amount`, `delegation_id`)
+SELECT `delegator`, `baker`, `level`, `timestamp`, `amount`, `id`
+FROM `delegations`
+WHERE `id` IN (
+	SELECT MAX(`id`) FROM `delegations`
+	WHERE `status` = 'applied' AND `delegator` IS NOT NULL AND `delegator` <> ''
+	GROUP BY `delegator`
+);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS `idx_current_delegations_baker`;
+CREATE INDEX IF NOT EXISTS `idx_current_delegations_baker` ON `current_delegations`(`baker`);
@@ -0,0 +1,3 @@
+-- listing the delegators of a baker, largest amount first
+DROP INDEX IF EXISTS `idx_current_delegations_baker`;
+CREATE INDEX IF NOT EXISTS `idx_current_delegations_baker` ON `current_delegations`(`baker`, `amount` DESC);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS `idx_delegations_cycle`;
+ALTER TABLE `delegations` DROP COLUMN `protocol`;
+ALTER TABLE `delegations` DROP COLUMN `cycle`;
+DROP TABLE IF EXISTS `protocols`;
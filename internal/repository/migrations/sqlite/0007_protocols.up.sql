@@ -0,0 +1,17 @@
+-- the protocols of the chain as published by TzKT, to derive the cycle and protocol of delegations
+CREATE TABLE IF NOT EXISTS `protocols` (
+	`code` integer,
+	`hash` text,
+	`first_level` integer,
+	`last_level` integer,
+	`first_cycle` integer,
+	`first_cycle_level` integer,
+	`blocks_per_cycle` integer,
+	PRIMARY KEY (`code`)
+);
+
+-- derived once the protocols are fetched, until then the cycle is unknown
+ALTER TABLE `delegations` ADD COLUMN `cycle` integer;
+ALTER TABLE `delegations` ADD COLUMN `protocol` text;
+
+CREATE INDEX IF NOT EXISTS `idx_delegations_cycle` ON `delegations`(`cycle`);
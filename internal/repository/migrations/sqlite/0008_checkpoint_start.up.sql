@@ -0,0 +1,2 @@
+-- the level a backfill was started from, which a resumed run keeps
+ALTER TABLE `sync_checkpoints` ADD COLUMN `from_level` integer NOT NULL DEFAULT 0;
@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"tezos-delegation-service/internal/model"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+)
+
+var delegationColumns = []string{
+	"id", "timestamp", "amount", "delegator", "level", "year", "baker", "prev_baker",
+	"hash", "block", "counter", "status", "baker_fee", "gas_used", "kind", "cycle", "protocol",
+}
+
+// copyBatch stores the delegations on PostgreSQL with COPY, much faster than INSERT for the large
+// pages of a backfill, and advances the checkpoint when given, in a single transaction. COPY cannot
+// skip the delegations already stored, so they are copied to a staging table first and moved from
+// there.
+func (d *Database) copyBatch(ctx context.Context, delegations []model.Delegation, checkpoint *model.SyncCheckpoint) error {
+	sqlDB, err := d.db.DB()
+	if err != nil {
+		return err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Raw(func(driverConn any) error {
+		stdConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return fmt.Errorf("unexpected PostgreSQL driver connection %T", driverConn)
+		}
+
+		tx, err := stdConn.Conn().Begin(ctx)
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback(ctx)
+
+		if len(delegations) > 0 {
+			if err := copyDelegations(ctx, tx, delegations); err != nil {
+				return err
+			}
+		}
+		if checkpoint != nil {
+			_, err := tx.Exec(ctx, `
+				INSERT INTO sync_checkpoints (name, last_id, level, timestamp, source, from_level, updated_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)
+				ON CONFLICT (name) DO UPDATE SET
+					last_id = EXCLUDED.last_id,
+					level = EXCLUDED.level,
+					timestamp = EXCLUDED.timestamp,
+					source = EXCLUDED.source,
+					from_level = EXCLUDED.from_level,
+					updated_at = EXCLUDED.updated_at`,
+				checkpoint.Name, checkpoint.LastID, checkpoint.Level, checkpoint.Timestamp, checkpoint.Source, checkpoint.FromLevel, time.Now())
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Commit(ctx)
+	})
+}
+
+func copyDelegations(ctx context.Context, tx pgx.Tx, delegations []model.Delegation) error {
+	_, err := tx.Exec(ctx, "CREATE TEMPORARY TABLE delegations_staging (LIKE delegations) ON COMMIT DROP")
+	if err != nil {
+		return err
+	}
+
+	rows := pgx.CopyFromSlice(len(delegations), func(i int) ([]any, error) {
+		d := delegations[i]
+		return []any{
+			d.ID, d.Timestamp, d.Amount, d.Delegator, d.Level, d.Year, d.Baker, d.PrevBaker,
+			d.Hash, d.Block, d.Counter, d.Status, d.BakerFee, d.GasUsed, string(d.Kind), d.Cycle, d.Protocol,
+		}, nil
+	})
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"delegations_staging"}, delegationColumns, rows); err != nil {
+		return err
+	}
+
+	// name the columns, their order in either table depends on the migrations that added them
+	columns := strings.Join(delegationColumns, ", ")
+	_, err = tx.Exec(ctx, "INSERT INTO delegations ("+columns+") SELECT "+columns+" FROM delegations_staging ON CONFLICT (id) DO NOTHING")
+	if err != nil {
+		return err
+	}
+
+	// the delegation state is folded in SQL as well, see foldCurrent
+	_, err = tx.Exec(ctx, `
+		INSERT INTO current_delegations (delegator, baker, level, timestamp, amount, delegation_id)
+		SELECT DISTINCT ON (delegator) delegator, baker, level, timestamp, amount, id
+		FROM delegations_staging
+		WHERE status = 'applied' AND delegator <> ''
+		ORDER BY delegator, id DESC
+		ON CONFLICT (delegator) DO UPDATE SET
+			baker = EXCLUDED.baker,
+			level = EXCLUDED.level,
+			timestamp = EXCLUDED.timestamp,
+			amount = EXCLUDED.amount,
+			delegation_id = EXCLUDED.delegation_id
+		WHERE EXCLUDED.delegation_id > current_delegations.delegation_id`)
+	return err
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+
+	"tezos-delegation-service/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GetProtocols returns the protocols stored by SaveProtocols, ordered by first level.
+func (d *Database) GetProtocols(ctx context.Context) (model.Protocols, error) {
+	var protocols model.Protocols
+
+	err := d.db.WithContext(ctx).Order("first_level").Find(&protocols).Error
+
+	return protocols, err
+}
+
+// SaveProtocols stores the protocols of the chain and derives again the cycle and protocol of the
+// delegations from the first level of the lowest new or changed protocol on. Saving them for the
+// first time derives those of every delegation stored before.
+func (d *Database) SaveProtocols(ctx context.Context, protocols model.Protocols) error {
+	if len(protocols) == 0 {
+		return nil
+	}
+
+	return d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var stored model.Protocols
+		if err := tx.Find(&stored).Error; err != nil {
+			return err
+		}
+		known := make(map[int]model.Protocol, len(stored))
+		for _, p := range stored {
+			known[p.Code] = p
+		}
+
+		from := -1
+		for _, p := range protocols {
+			if known[p.Code] != p && (from < 0 || p.FirstLevel < from) {
+				from = p.FirstLevel
+			}
+		}
+		if from < 0 {
+			return nil
+		}
+
+		if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&protocols).Error; err != nil {
+			return err
+		}
+		return deriveCycles(tx, protocols, from)
+	})
+}
+
+// deriveCycles sets the cycle and protocol of the delegations at or above the level, range by range
+// as Protocols.CycleOf and Protocols.At do.
+func deriveCycles(tx *gorm.DB, protocols model.Protocols, from int) error {
+	for i, p := range protocols {
+		next := 0
+		if i+1 < len(protocols) {
+			next = protocols[i+1].FirstLevel
+		}
+		if err := levelRange(tx, max(p.FirstLevel, from), next).Update("protocol", p.Hash).Error; err != nil {
+			return err
+		}
+	}
+
+	var cycles model.Protocols
+	for _, p := range protocols {
+		if p.BlocksPerCycle > 0 {
+			cycles = append(cycles, p)
+		}
+	}
+	for i, p := range cycles {
+		next := 0
+		if i+1 < len(cycles) {
+			next = cycles[i+1].FirstCycleLevel
+		}
+		cycle := gorm.Expr("? + (level - ?) / ?", p.FirstCycle, p.FirstCycleLevel, p.BlocksPerCycle)
+		if err := levelRange(tx, max(p.FirstCycleLevel, from), next).Update("cycle", cycle).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// levelRange selects the delegations from the level on, up to but excluding to unless it is zero.
+func levelRange(tx *gorm.DB, from, to int) *gorm.DB {
+	db := tx.Model(&model.Delegation{}).Where("level >= ?", from)
+	if to != 0 {
+		db = db.Where("level < ?", to)
+	}
+	return db
+}
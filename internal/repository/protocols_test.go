@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"tezos-delegation-service/internal/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabase_SaveProtocols(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
+		ctx := context.Background()
+
+		delegation := func(id int, level int) model.Delegation {
+			return model.Delegation{ID: id, Level: level, Timestamp: "2024-01-01T00:00:00Z", Year: 2024, Status: model.StatusApplied}
+		}
+		require.NoError(t, testDB.SaveBatch(ctx, []model.Delegation{
+			delegation(1, 10), delegation(2, 100), delegation(3, 150), delegation(4, 250), delegation(5, 280),
+		}))
+
+		// the cycle and protocol of each delegation, by ID
+		derived := func() map[int]string {
+			var delegations []model.Delegation
+			require.NoError(t, testDB.db.Order("id").Find(&delegations).Error)
+			result := map[int]string{}
+			for _, d := range delegations {
+				if d.Cycle != nil {
+					result[d.ID] = fmt.Sprintf("%s/%d", d.Protocol, *d.Cycle)
+				}
+			}
+			return result
+		}
+		assert.Empty(t, derived(), "nothing is derived before the protocols are known")
+
+		protocols := model.Protocols{
+			{Code: 1, Hash: "PtOne", FirstLevel: 1, LastLevel: 149, FirstCycle: 0, FirstCycleLevel: 1, BlocksPerCycle: 64},
+			// activated within cycle 2, which runs on with 64 levels
+			{Code: 2, Hash: "PtTwo", FirstLevel: 150, FirstCycle: 3, FirstCycleLevel: 193, BlocksPerCycle: 32},
+		}
+		require.NoError(t, testDB.SaveProtocols(ctx, protocols))
+		assert.Equal(t, map[int]string{1: "PtOne/0", 2: "PtOne/1", 3: "PtTwo/2", 4: "PtTwo/4", 5: "PtTwo/5"}, derived())
+
+		stored, err := testDB.GetProtocols(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, protocols, stored)
+
+		// the same cycles as derived while storing
+		for _, d := range []model.Delegation{delegation(3, 150), delegation(4, 250)} {
+			protocols.Derive(&d)
+			inCycle, err := testDB.GetDelegations(ctx, model.DelegationQuery{Cycle: d.Cycle})
+			require.NoError(t, err)
+			assert.Equal(t, []int{d.ID}, delegationIDs(inCycle))
+		}
+
+		// a new protocol derives again the delegations from its first level on
+		protocols[1].LastLevel = 256
+		protocols = append(protocols, model.Protocol{Code: 3, Hash: "PtThree", FirstLevel: 257, FirstCycle: 5, FirstCycleLevel: 257, BlocksPerCycle: 16})
+		require.NoError(t, testDB.SaveProtocols(ctx, protocols))
+		assert.Equal(t, map[int]string{1: "PtOne/0", 2: "PtOne/1", 3: "PtTwo/2", 4: "PtTwo/4", 5: "PtThree/6"}, derived())
+	})
+}
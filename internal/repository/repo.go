@@ -1,51 +1,179 @@
 package repository
 
 import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
 	"tezos-delegation-service/internal/model"
 
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 )
 
+// Dialect is the database engine behind a Database.
+type Dialect string
+
+const (
+	SQLite   Dialect = "sqlite"
+	Postgres Dialect = "postgres"
+)
+
+// DialectOf tells which engine a DSN designates: postgres:// and postgresql:// URLs are PostgreSQL
+// databases, anything else is the path of a SQLite file.
+func DialectOf(dsn string) Dialect {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return Postgres
+	}
+	return SQLite
+}
+
+// Redact returns the DSN without its password, to be logged.
+func Redact(dsn string) string {
+	if DialectOf(dsn) != Postgres {
+		return dsn
+	}
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return "postgres://"
+	}
+	return u.Redacted()
+}
+
 type Database struct {
-	db *gorm.DB
+	db      *gorm.DB
+	dialect Dialect
 }
 
 type DelegationRepository interface {
-	GetDelegations(year int, offset int) ([]model.Delegation, error)
-	SaveBatch([]model.Delegation) error
-	GetLatestDelegation(year int) (model.Delegation, error)
+	GetDelegations(ctx context.Context, query model.DelegationQuery) ([]model.Delegation, error)
+	GetDelegatorDelegations(ctx context.Context, delegator string, query model.DelegationQuery) ([]model.Delegation, error)
+	GetCurrentDelegation(ctx context.Context, delegator string) (model.CurrentDelegation, error)
+	GetBakers(ctx context.Context, query model.BakerQuery) ([]model.BakerStats, error)
+	GetBaker(ctx context.Context, baker string) (model.BakerStats, error)
+	GetBakerDelegators(ctx context.Context, baker string, limit int, offset int) ([]model.CurrentDelegation, error)
+	GetDelegationStats(ctx context.Context, query model.StatsQuery) ([]model.DelegationStats, error)
+	GetProtocols(ctx context.Context) (model.Protocols, error)
+	SaveProtocols(ctx context.Context, protocols model.Protocols) error
+	CountDelegations(ctx context.Context, query model.DelegationQuery) (int, error)
+	SaveBatch(ctx context.Context, delegations []model.Delegation) error
+	SaveBatchWithCheckpoint(ctx context.Context, delegations []model.Delegation, checkpoint model.SyncCheckpoint) error
+	GetLatestDelegation(ctx context.Context, year int) (model.Delegation, error)
+	GetLastDelegation(ctx context.Context) (model.Delegation, error)
+	GetCheckpoint(ctx context.Context, name string) (model.SyncCheckpoint, error)
+	DeleteCheckpoint(ctx context.Context, name string) error
+	GetBlockHashes(ctx context.Context, fromLevel, toLevel int) (map[int]string, error)
+	RollbackFrom(ctx context.Context, level int, checkpoint string) error
 }
 
-func NewDatabase(path string) (*Database, error) {
-	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+// NewDatabase opens the database, creating it if needed, and migrates it to the latest schema.
+func NewDatabase(dsn string) (*Database, error) {
+	d, err := Open(dsn)
 	if err != nil {
 		return nil, err
 	}
-	if err := db.AutoMigrate(&model.Delegation{}); err != nil {
+	if _, err := d.Migrate(context.Background(), MigrateLatest); err != nil {
 		return nil, err
 	}
+	return d, nil
+}
+
+// Open opens the database designated by the DSN without migrating it. A SQLite file is created if
+// needed, a PostgreSQL database must exist.
+func Open(dsn string) (*Database, error) {
+	dialect := DialectOf(dsn)
+	dialector := sqlite.Open(dsn)
+	if dialect == Postgres {
+		dialector = postgres.Open(dsn)
+	}
 
-	rawIndex := `
-		CREATE INDEX IF NOT EXISTS idx_year_timestamp_desc 
-		ON delegations (year, timestamp DESC);
-	`
-	if err := db.Exec(rawIndex).Error; err != nil {
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
 		return nil, err
 	}
+	return &Database{db: db, dialect: dialect}, nil
+}
+
+// OpenReadOnly opens an existing database without migrating it, for processes that only serve it.
+// Writes fail, and so does opening a database whose schema is not up to date.
+func OpenReadOnly(dsn string) (*Database, error) {
+	dialect := DialectOf(dsn)
+
+	var dialector gorm.Dialector
+	switch dialect {
+	case Postgres:
+		readOnly, err := url.Parse(dsn)
+		if err != nil {
+			return nil, err
+		}
+		query := readOnly.Query()
+		query.Set("default_transaction_read_only", "on")
+		readOnly.RawQuery = query.Encode()
+		dialector = postgres.Open(readOnly.String())
+	default:
+		if _, err := os.Stat(dsn); err != nil {
+			return nil, err
+		}
+		dialector = sqlite.Open("file:" + dsn + "?mode=ro")
+	}
 
-	return &Database{db}, nil
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+	d := &Database{db: db, dialect: dialect}
+
+	migrations, err := Migrations(dialect)
+	if err != nil {
+		return nil, err
+	}
+	version, err := d.SchemaVersion(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if latest := migrations[len(migrations)-1].Version; version != latest {
+		return nil, fmt.Errorf("database schema is at version %d, migrate it to version %d first", version, latest)
+	}
+	return d, nil
 }
 
-func (d *Database) GetDelegations(year int, offset int) ([]model.Delegation, error) {
-	db := d.db
+func (d *Database) GetDelegations(ctx context.Context, query model.DelegationQuery) ([]model.Delegation, error) {
 	var delegations []model.Delegation
 
-	limit := 50
-	err := db.Where("year = ?", year).
-		Order("timestamp DESC").
-		Offset(offset).
+	db := filter(d.db.WithContext(ctx), query)
+
+	// the sort column and the cursor value of the last delegation of the previous page
+	column, value := "timestamp", any(query.After.Timestamp)
+	switch query.Sort {
+	case model.SortByAmount:
+		column, value = "amount", query.After.Amount
+	case model.SortByLevel:
+		column, value = "level", query.After.Level
+	}
+	direction := "DESC"
+	if query.Ascending {
+		direction = "ASC"
+	}
+
+	if query.After != (model.Cursor{}) {
+		// keyset pagination: the first condition bounds the scan of the index on the sort column,
+		// the second skips the delegations of the previous page sharing its last value
+		if query.Ascending {
+			db = db.Where(column+" >= ? AND ("+column+" > ? OR id > ?)", value, value, query.After.ID)
+		} else {
+			db = db.Where(column+" <= ? AND ("+column+" < ? OR id < ?)", value, value, query.After.ID)
+		}
+	}
+
+	limit := query.Limit
+	if limit <= 0 {
+		limit = model.DefaultPageSize
+	}
+	err := db.Order(column + " " + direction + ", id " + direction).
+		Offset(query.Offset).
 		Limit(limit).
 		Find(&delegations).Error
 
@@ -56,8 +184,67 @@ func (d *Database) GetDelegations(year int, offset int) ([]model.Delegation, err
 	return delegations, err
 }
 
-func (d *Database) GetLatestDelegation(year int) (model.Delegation, error) {
-	db := d.db
+// GetDelegatorDelegations lists the delegations of delegator across every year, paged through the
+// (delegator, timestamp, id) index.
+func (d *Database) GetDelegatorDelegations(ctx context.Context, delegator string, query model.DelegationQuery) ([]model.Delegation, error) {
+	query.Delegator, query.Year = delegator, 0
+	return d.GetDelegations(ctx, query)
+}
+
+// CountDelegations returns how many delegations the query selects over every page.
+func (d *Database) CountDelegations(ctx context.Context, query model.DelegationQuery) (int, error) {
+	var count int64
+	err := filter(d.db.WithContext(ctx).Model(&model.Delegation{}), query).Count(&count).Error
+	return int(count), err
+}
+
+// filter narrows db down to the delegations matching the filters of the query.
+func filter(db *gorm.DB, query model.DelegationQuery) *gorm.DB {
+	if query.Year != 0 {
+		db = db.Where("year = ?", query.Year)
+	}
+	if query.Kind != "" {
+		db = db.Where("kind = ?", query.Kind)
+	}
+	if query.Status != "" {
+		db = db.Where("status = ?", query.Status)
+	}
+	if query.Delegator != "" {
+		db = db.Where("delegator = ?", query.Delegator)
+	}
+	if query.Baker != "" {
+		db = db.Where("baker = ?", query.Baker)
+	}
+	if query.Hash != "" {
+		db = db.Where("hash = ?", query.Hash)
+	}
+	if query.MinLevel != 0 {
+		db = db.Where("level >= ?", query.MinLevel)
+	}
+	if query.MaxLevel != 0 {
+		db = db.Where("level <= ?", query.MaxLevel)
+	}
+	if query.Cycle != nil {
+		db = db.Where("cycle = ?", *query.Cycle)
+	}
+	// timestamps are stored as RFC3339 UTC strings, which sort like the instants they represent
+	if query.From != "" {
+		db = db.Where("timestamp >= ?", query.From)
+	}
+	if query.To != "" {
+		db = db.Where("timestamp < ?", query.To)
+	}
+	if query.MinAmount != 0 {
+		db = db.Where("amount >= ?", query.MinAmount)
+	}
+	if query.MaxAmount != 0 {
+		db = db.Where("amount <= ?", query.MaxAmount)
+	}
+	return db
+}
+
+func (d *Database) GetLatestDelegation(ctx context.Context, year int) (model.Delegation, error) {
+	db := d.db.WithContext(ctx)
 	var delegation model.Delegation
 
 	err := db.Select("id", "timestamp").
@@ -69,18 +256,131 @@ func (d *Database) GetLatestDelegation(year int) (model.Delegation, error) {
 	return delegation, err
 }
 
-func (d *Database) SaveBatch(delegations []model.Delegation) error {
+// GetLastDelegation returns the stored delegation with the highest ID, whatever its year.
+func (d *Database) GetLastDelegation(ctx context.Context) (model.Delegation, error) {
+	var delegation model.Delegation
+
+	err := d.db.WithContext(ctx).Select("id", "timestamp", "level").
+		Order("id DESC").
+		First(&delegation).Error
+
+	return delegation, err
+}
+
+func (d *Database) GetCheckpoint(ctx context.Context, name string) (model.SyncCheckpoint, error) {
+	var checkpoint model.SyncCheckpoint
+
+	err := d.db.WithContext(ctx).Where("name = ?", name).First(&checkpoint).Error
+
+	return checkpoint, err
+}
+
+func (d *Database) DeleteCheckpoint(ctx context.Context, name string) error {
+	return d.db.WithContext(ctx).Where("name = ?", name).Delete(&model.SyncCheckpoint{}).Error
+}
+
+// GetBlockHashes returns the hash of the block each delegation stored between fromLevel and
+// toLevel inclusive was included in, by level. Rows stored before blocks were captured are left out.
+func (d *Database) GetBlockHashes(ctx context.Context, fromLevel, toLevel int) (map[int]string, error) {
+	var rows []struct {
+		Level int
+		Block string
+	}
+
+	err := d.db.WithContext(ctx).Model(&model.Delegation{}).
+		Distinct("level", "block").
+		Where("level BETWEEN ? AND ? AND block IS NOT NULL AND block <> ''", fromLevel, toLevel).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make(map[int]string, len(rows))
+	for _, row := range rows {
+		hashes[row.Level] = row.Block
+	}
+	return hashes, nil
+}
+
+// RollbackFrom deletes the delegations stored at or above level, whose blocks were orphaned by a
+// chain reorganisation, restores the delegation state they set, and rewinds the named checkpoint to
+// the last delegation left below it so that the replacing blocks are fetched again.
+func (d *Database) RollbackFrom(ctx context.Context, level int, checkpoint string) error {
+	return d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var orphaned []string
+		if err := tx.Model(&model.CurrentDelegation{}).Where("level >= ?", level).Pluck("delegator", &orphaned).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("level >= ?", level).Delete(&model.Delegation{}).Error; err != nil {
+			return err
+		}
+		if err := refoldCurrent(tx, orphaned); err != nil {
+			return err
+		}
+
+		// by level first: after failing over between TzKT and a node, IDs of both kinds are stored
+		var last model.Delegation
+		if err := tx.Where("level < ?", level).Order("level DESC, id DESC").Limit(1).Find(&last).Error; err != nil {
+			return err
+		}
+		if last.ID == 0 {
+			return tx.Where("name = ?", checkpoint).Delete(&model.SyncCheckpoint{}).Error
+		}
+
+		return tx.Model(&model.SyncCheckpoint{}).Where("name = ?", checkpoint).Updates(map[string]any{
+			"last_id":   last.ID,
+			"level":     last.Level,
+			"timestamp": last.Timestamp,
+		}).Error
+	})
+}
+
+func (d *Database) SaveBatch(ctx context.Context, delegations []model.Delegation) error {
 	if len(delegations) == 0 {
 		return nil
 	}
+	if d.dialect == Postgres {
+		return d.copyBatch(ctx, delegations, nil)
+	}
 
-	return d.db.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Clauses(clause.OnConflict{
-			Columns:   []clause.Column{{Name: "id"}},
-			DoNothing: true,
-		}).Create(&delegations).Error; err != nil {
+	return d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := insertDelegations(tx, delegations); err != nil {
 			return err
 		}
+		return foldCurrent(tx, delegations)
+	})
+}
+
+// SaveBatchWithCheckpoint stores the delegations and advances the checkpoint in a single
+// transaction, so the checkpoint never points past rows that were not committed. The checkpoint
+// may advance past a page whose delegations were all filtered out before storing.
+func (d *Database) SaveBatchWithCheckpoint(ctx context.Context, delegations []model.Delegation, checkpoint model.SyncCheckpoint) error {
+	if len(delegations) == 0 && checkpoint.LastID == 0 {
 		return nil
+	}
+	if d.dialect == Postgres {
+		return d.copyBatch(ctx, delegations, &checkpoint)
+	}
+
+	return d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if len(delegations) > 0 {
+			if err := insertDelegations(tx, delegations); err != nil {
+				return err
+			}
+			if err := foldCurrent(tx, delegations); err != nil {
+				return err
+			}
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "name"}},
+			UpdateAll: true,
+		}).Create(&checkpoint).Error
 	})
 }
+
+func insertDelegations(tx *gorm.DB, delegations []model.Delegation) error {
+	return tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoNothing: true,
+	}).Create(&delegations).Error
+}
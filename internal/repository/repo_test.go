@@ -1,43 +1,47 @@
 package repository
 
 import (
+	"context"
+	"fmt"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
 	"tezos-delegation-service/internal/model"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
 )
 
 type TestDatabase struct {
 	*Database
-	tempPath string
+	dsn string
 }
 
-func NewTestDatabase(t *testing.T) *TestDatabase {
-	tempFile, err := os.CreateTemp("", "test_db_*.db")
-	if err != nil {
-		t.Fatalf("Failed to create temp file: %v", err)
-	}
-	tempFile.Close()
+// NewTestDatabase creates a migrated database of the dialect, dropped at the end of the test.
+func NewTestDatabase(t *testing.T, dialect Dialect) *TestDatabase {
+	t.Helper()
+	dsn := testDSN(t, dialect)
 
-	db, err := NewDatabase(tempFile.Name())
+	db, err := NewDatabase(dsn)
 	if err != nil {
-		os.Remove(tempFile.Name())
 		t.Fatalf("Failed to create test database: %v", err)
 	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
 
 	return &TestDatabase{
 		Database: db,
-		tempPath: tempFile.Name(),
+		dsn:      dsn,
 	}
 }
 
-func (td *TestDatabase) Cleanup() {
-	os.Remove(td.tempPath)
-}
-
 func TestNewDatabase(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -72,433 +76,1126 @@ func TestNewDatabase(t *testing.T) {
 	}
 }
 
-func TestDatabase_GetDelegations(t *testing.T) {
-	testDB := NewTestDatabase(t)
-	defer testDB.Cleanup()
+func TestOpenReadOnly(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
 
-	testDelegations := []model.Delegation{
-		{
-			ID:        1,
-			Timestamp: "2023-01-01T00:00:00Z",
-			Amount:    1000,
-			Delegator: "addr1",
-			Level:     100,
-			Year:      2023,
-		},
-		{
-			ID:        2,
-			Timestamp: "2023-01-02T00:00:00Z",
-			Amount:    2000,
-			Delegator: "addr2",
-			Level:     101,
-			Year:      2023,
-		},
-		{
-			ID:        3,
-			Timestamp: "2023-01-03T00:00:00Z",
-			Amount:    3000,
-			Delegator: "addr3",
-			Level:     102,
-			Year:      2023,
-		},
-		{
-			ID:        4,
-			Timestamp: "2024-01-01T00:00:00Z",
-			Amount:    4000,
-			Delegator: "addr4",
-			Level:     200,
-			Year:      2024,
-		},
-	}
+		ctx := context.Background()
+		err := testDB.SaveBatch(ctx, []model.Delegation{{ID: 1, Timestamp: "2023-01-01T00:00:00Z", Year: 2023}})
+		assert.NoError(t, err)
 
-	for _, delegation := range testDelegations {
-		err := testDB.db.Create(&delegation).Error
+		db, err := OpenReadOnly(testDB.dsn)
 		assert.NoError(t, err)
-	}
 
-	tests := []struct {
-		name          string
-		year          int
-		offset        int
-		expectedCount int
-		expectedFirst *model.Delegation
-		expectedLast  *model.Delegation
-		expectError   bool
-	}{
-		{
-			name:          "get all delegations for 2023",
-			year:          2023,
-			offset:        0,
-			expectedCount: 3,
-			expectedFirst: &testDelegations[2], // ordered by timestamp DESC
-			expectedLast:  &testDelegations[0],
-			expectError:   false,
-		},
-		{
-			name:          "get delegations for 2023 with offset",
-			year:          2023,
-			offset:        1,
-			expectedCount: 2,
-			expectedFirst: &testDelegations[1],
-			expectedLast:  &testDelegations[0],
-			expectError:   false,
-		},
-		{
-			name:          "get delegations for 2024",
-			year:          2024,
-			offset:        0,
-			expectedCount: 1,
-			expectedFirst: &testDelegations[3],
-			expectedLast:  &testDelegations[3],
-			expectError:   false,
-		},
-		{
-			name:          "get delegations for non-existent year",
-			year:          2025,
-			offset:        0,
-			expectedCount: 0,
-			expectedFirst: nil,
-			expectedLast:  nil,
-			expectError:   false,
-		},
-		{
-			name:          "get delegations with large offset",
-			year:          2023,
-			offset:        10,
-			expectedCount: 0,
-			expectedFirst: nil,
-			expectedLast:  nil,
-			expectError:   false,
-		},
-	}
+		delegations, err := db.GetDelegations(ctx, model.DelegationQuery{Year: 2023})
+		assert.NoError(t, err)
+		assert.Len(t, delegations, 1)
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			delegations, err := testDB.GetDelegations(tt.year, tt.offset)
+		err = db.SaveBatch(ctx, []model.Delegation{{ID: 2, Timestamp: "2023-01-02T00:00:00Z", Year: 2023}})
+		assert.Error(t, err)
 
-			if tt.expectError {
-				assert.Error(t, err)
-			} else {
-				assert.NoError(t, err)
-			}
+		missing := testDB.dsn + ".missing"
+		if dialect == Postgres {
+			missing = strings.Replace(testDB.dsn, "/xtz_test_", "/xtz_missing_", 1)
+		}
+		_, err = OpenReadOnly(missing)
+		assert.Error(t, err)
+	})
+}
 
-			assert.Len(t, delegations, tt.expectedCount)
+func TestDatabase_GetDelegations(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
 
-			if tt.expectedFirst != nil && len(delegations) > 0 {
-				assert.Equal(t, tt.expectedFirst.ID, delegations[0].ID)
-				assert.Equal(t, tt.expectedFirst.Timestamp, delegations[0].Timestamp)
-			}
+		testDelegations := []model.Delegation{
+			{
+				ID:        1,
+				Timestamp: "2023-01-01T00:00:00Z",
+				Amount:    1000,
+				Delegator: "addr1",
+				Level:     100,
+				Year:      2023,
+			},
+			{
+				ID:        2,
+				Timestamp: "2023-01-02T00:00:00Z",
+				Amount:    2000,
+				Delegator: "addr2",
+				Level:     101,
+				Year:      2023,
+			},
+			{
+				ID:        3,
+				Timestamp: "2023-01-03T00:00:00Z",
+				Amount:    3000,
+				Delegator: "addr3",
+				Level:     102,
+				Year:      2023,
+			},
+			{
+				ID:        4,
+				Timestamp: "2024-01-01T00:00:00Z",
+				Amount:    4000,
+				Delegator: "addr4",
+				Level:     200,
+				Year:      2024,
+			},
+		}
 
-			if tt.expectedLast != nil && len(delegations) > 0 {
-				lastIndex := len(delegations) - 1
-				assert.Equal(t, tt.expectedLast.ID, delegations[lastIndex].ID)
-				assert.Equal(t, tt.expectedLast.Timestamp, delegations[lastIndex].Timestamp)
-			}
+		for _, delegation := range testDelegations {
+			err := testDB.db.Create(&delegation).Error
+			assert.NoError(t, err)
+		}
 
-			// verify ordering - should be DESC
-			for i := 1; i < len(delegations); i++ {
-				prevTime, _ := time.Parse(time.RFC3339, delegations[i-1].Timestamp)
-				currTime, _ := time.Parse(time.RFC3339, delegations[i].Timestamp)
-				assert.True(t, prevTime.After(currTime) || prevTime.Equal(currTime))
-			}
-		})
-	}
+		tests := []struct {
+			name          string
+			year          int
+			offset        int
+			expectedCount int
+			expectedFirst *model.Delegation
+			expectedLast  *model.Delegation
+			expectError   bool
+		}{
+			{
+				name:          "get all delegations for 2023",
+				year:          2023,
+				offset:        0,
+				expectedCount: 3,
+				expectedFirst: &testDelegations[2], // ordered by timestamp DESC
+				expectedLast:  &testDelegations[0],
+				expectError:   false,
+			},
+			{
+				name:          "get delegations for 2023 with offset",
+				year:          2023,
+				offset:        1,
+				expectedCount: 2,
+				expectedFirst: &testDelegations[1],
+				expectedLast:  &testDelegations[0],
+				expectError:   false,
+			},
+			{
+				name:          "get delegations for 2024",
+				year:          2024,
+				offset:        0,
+				expectedCount: 1,
+				expectedFirst: &testDelegations[3],
+				expectedLast:  &testDelegations[3],
+				expectError:   false,
+			},
+			{
+				name:          "get delegations for non-existent year",
+				year:          2025,
+				offset:        0,
+				expectedCount: 0,
+				expectedFirst: nil,
+				expectedLast:  nil,
+				expectError:   false,
+			},
+			{
+				name:          "get delegations with large offset",
+				year:          2023,
+				offset:        10,
+				expectedCount: 0,
+				expectedFirst: nil,
+				expectedLast:  nil,
+				expectError:   false,
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				delegations, err := testDB.GetDelegations(context.Background(), model.DelegationQuery{Year: tt.year, Offset: tt.offset})
+
+				if tt.expectError {
+					assert.Error(t, err)
+				} else {
+					assert.NoError(t, err)
+				}
+
+				assert.Len(t, delegations, tt.expectedCount)
+
+				if tt.expectedFirst != nil && len(delegations) > 0 {
+					assert.Equal(t, tt.expectedFirst.ID, delegations[0].ID)
+					assert.Equal(t, tt.expectedFirst.Timestamp, delegations[0].Timestamp)
+				}
+
+				if tt.expectedLast != nil && len(delegations) > 0 {
+					lastIndex := len(delegations) - 1
+					assert.Equal(t, tt.expectedLast.ID, delegations[lastIndex].ID)
+					assert.Equal(t, tt.expectedLast.Timestamp, delegations[lastIndex].Timestamp)
+				}
+
+				// verify ordering - should be DESC
+				for i := 1; i < len(delegations); i++ {
+					prevTime, _ := time.Parse(time.RFC3339, delegations[i-1].Timestamp)
+					currTime, _ := time.Parse(time.RFC3339, delegations[i].Timestamp)
+					assert.True(t, prevTime.After(currTime) || prevTime.Equal(currTime))
+				}
+			})
+		}
+	})
 }
 
 func TestDatabase_GetLatestDelegation(t *testing.T) {
-	testDB := NewTestDatabase(t)
-	defer testDB.Cleanup()
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
 
-	testDelegations := []model.Delegation{
-		{
-			ID:        1,
-			Timestamp: "2023-01-01T00:00:00Z",
-			Amount:    1000,
-			Delegator: "addr1",
-			Level:     100,
-			Year:      2023,
-		},
-		{
-			ID:        2,
-			Timestamp: "2023-01-02T00:00:00Z",
-			Amount:    2000,
-			Delegator: "addr2",
-			Level:     101,
-			Year:      2023,
-		},
-		{
-			ID:        3,
-			Timestamp: "2023-01-03T00:00:00Z",
-			Amount:    3000,
-			Delegator: "addr3",
-			Level:     102,
-			Year:      2023,
-		},
-	}
+		testDelegations := []model.Delegation{
+			{
+				ID:        1,
+				Timestamp: "2023-01-01T00:00:00Z",
+				Amount:    1000,
+				Delegator: "addr1",
+				Level:     100,
+				Year:      2023,
+			},
+			{
+				ID:        2,
+				Timestamp: "2023-01-02T00:00:00Z",
+				Amount:    2000,
+				Delegator: "addr2",
+				Level:     101,
+				Year:      2023,
+			},
+			{
+				ID:        3,
+				Timestamp: "2023-01-03T00:00:00Z",
+				Amount:    3000,
+				Delegator: "addr3",
+				Level:     102,
+				Year:      2023,
+			},
+		}
 
-	for _, delegation := range testDelegations {
-		err := testDB.db.Create(&delegation).Error
-		assert.NoError(t, err)
-	}
+		for _, delegation := range testDelegations {
+			err := testDB.db.Create(&delegation).Error
+			assert.NoError(t, err)
+		}
 
-	tests := []struct {
-		name        string
-		year        int
-		expected    *model.Delegation
-		expectError bool
-	}{
-		{
-			name:        "get latest delegation for 2023",
-			year:        2023,
-			expected:    &testDelegations[2],
-			expectError: false,
-		},
-		{
-			name:        "get latest delegation for non-existent year",
-			year:        2025,
-			expected:    nil,
-			expectError: true,
-		},
-	}
+		tests := []struct {
+			name        string
+			year        int
+			expected    *model.Delegation
+			expectError bool
+		}{
+			{
+				name:        "get latest delegation for 2023",
+				year:        2023,
+				expected:    &testDelegations[2],
+				expectError: false,
+			},
+			{
+				name:        "get latest delegation for non-existent year",
+				year:        2025,
+				expected:    nil,
+				expectError: true,
+			},
+		}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			delegation, err := testDB.GetLatestDelegation(tt.year)
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				delegation, err := testDB.GetLatestDelegation(context.Background(), tt.year)
 
-			if tt.expectError {
-				assert.Error(t, err)
-			} else {
-				assert.NoError(t, err)
-				assert.NotNil(t, delegation)
-				assert.Equal(t, tt.expected.ID, delegation.ID)
-				assert.Equal(t, tt.expected.Timestamp, delegation.Timestamp)
-
-				assert.Equal(t, 0, delegation.Amount)
-				assert.Equal(t, "", delegation.Delegator)
-				assert.Equal(t, 0, delegation.Level)
-				assert.Equal(t, 0, delegation.Year)
-			}
-		})
-	}
+				if tt.expectError {
+					assert.Error(t, err)
+				} else {
+					assert.NoError(t, err)
+					assert.NotNil(t, delegation)
+					assert.Equal(t, tt.expected.ID, delegation.ID)
+					assert.Equal(t, tt.expected.Timestamp, delegation.Timestamp)
+
+					assert.Equal(t, 0, delegation.Amount)
+					assert.Equal(t, "", delegation.Delegator)
+					assert.Equal(t, 0, delegation.Level)
+					assert.Equal(t, 0, delegation.Year)
+				}
+			})
+		}
+	})
 }
 
 func TestDatabase_SaveBatch(t *testing.T) {
-	testDB := NewTestDatabase(t)
-	defer testDB.Cleanup()
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
 
-	tests := []struct {
-		name        string
-		delegations []model.Delegation
-		expectError bool
-	}{
-		{
-			name: "save single delegation",
-			delegations: []model.Delegation{
-				{
-					ID:        1,
-					Timestamp: "2023-01-01T00:00:00Z",
-					Amount:    1000,
-					Delegator: "addr1",
-					Level:     100,
-					Year:      2023,
+		tests := []struct {
+			name        string
+			delegations []model.Delegation
+			expectError bool
+		}{
+			{
+				name: "save single delegation",
+				delegations: []model.Delegation{
+					{
+						ID:        1,
+						Timestamp: "2023-01-01T00:00:00Z",
+						Amount:    1000,
+						Delegator: "addr1",
+						Level:     100,
+						Year:      2023,
+					},
 				},
+				expectError: false,
 			},
-			expectError: false,
-		},
-		{
-			name: "save multiple delegations",
-			delegations: []model.Delegation{
-				{
-					ID:        1,
-					Timestamp: "2023-01-01T00:00:00Z",
-					Amount:    1000,
-					Delegator: "addr1",
-					Level:     100,
-					Year:      2023,
-				},
-				{
-					ID:        2,
-					Timestamp: "2023-01-02T00:00:00Z",
-					Amount:    2000,
-					Delegator: "addr2",
-					Level:     101,
-					Year:      2023,
+			{
+				name: "save multiple delegations",
+				delegations: []model.Delegation{
+					{
+						ID:        1,
+						Timestamp: "2023-01-01T00:00:00Z",
+						Amount:    1000,
+						Delegator: "addr1",
+						Level:     100,
+						Year:      2023,
+					},
+					{
+						ID:        2,
+						Timestamp: "2023-01-02T00:00:00Z",
+						Amount:    2000,
+						Delegator: "addr2",
+						Level:     101,
+						Year:      2023,
+					},
 				},
+				expectError: false,
 			},
-			expectError: false,
-		},
-		{
-			name:        "save empty batch",
-			delegations: []model.Delegation{},
-			expectError: false,
-		},
-		{
-			name: "save delegation with duplicate ID (should ignore)",
-			delegations: []model.Delegation{
-				{
-					ID:        1,
-					Timestamp: "2023-01-01T00:00:00Z",
-					Amount:    1000,
-					Delegator: "addr1",
-					Level:     100,
-					Year:      2023,
-				},
-				{
-					ID:        1,
-					Timestamp: "2023-01-02T00:00:00Z",
-					Amount:    2000,
-					Delegator: "addr2",
-					Level:     101,
-					Year:      2023,
+			{
+				name:        "save empty batch",
+				delegations: []model.Delegation{},
+				expectError: false,
+			},
+			{
+				name: "save delegation with duplicate ID (should ignore)",
+				delegations: []model.Delegation{
+					{
+						ID:        1,
+						Timestamp: "2023-01-01T00:00:00Z",
+						Amount:    1000,
+						Delegator: "addr1",
+						Level:     100,
+						Year:      2023,
+					},
+					{
+						ID:        1,
+						Timestamp: "2023-01-02T00:00:00Z",
+						Amount:    2000,
+						Delegator: "addr2",
+						Level:     101,
+						Year:      2023,
+					},
 				},
+				expectError: false,
 			},
-			expectError: false,
-		},
-	}
+		}
 
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			err := testDB.SaveBatch(tt.delegations)
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				err := testDB.SaveBatch(context.Background(), tt.delegations)
 
-			if tt.expectError {
-				assert.Error(t, err)
-			} else {
-				assert.NoError(t, err)
-			}
+				if tt.expectError {
+					assert.Error(t, err)
+				} else {
+					assert.NoError(t, err)
+				}
 
-			if len(tt.delegations) > 0 {
-				var count int64
-				testDB.db.Model(&model.Delegation{}).Count(&count)
-				assert.GreaterOrEqual(t, count, int64(len(tt.delegations)))
-			}
-		})
-	}
+				if len(tt.delegations) > 0 {
+					var count int64
+					testDB.db.Model(&model.Delegation{}).Count(&count)
+					assert.GreaterOrEqual(t, count, int64(len(tt.delegations)))
+				}
+			})
+		}
+	})
 }
 
 func TestDatabase_SaveBatch_Transaction(t *testing.T) {
-	testDB := NewTestDatabase(t)
-	defer testDB.Cleanup()
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
 
-	delegations := []model.Delegation{
-		{
+		delegations := []model.Delegation{
+			{
+				ID:        1,
+				Timestamp: "2023-01-01T00:00:00Z",
+				Amount:    1000,
+				Delegator: "addr1",
+				Level:     100,
+				Year:      2023,
+			},
+			{
+				ID:        2,
+				Timestamp: "2023-01-02T00:00:00Z",
+				Amount:    2000,
+				Delegator: "addr2",
+				Level:     101,
+				Year:      2023,
+			},
+		}
+
+		err := testDB.SaveBatch(context.Background(), delegations)
+		assert.NoError(t, err)
+
+		var savedDelegations []model.Delegation
+		err = testDB.db.Find(&savedDelegations).Error
+		assert.NoError(t, err)
+		assert.Len(t, savedDelegations, 2)
+	})
+}
+
+func TestDatabase_IndexCreation(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
+
+		// verify that the index was created
+		assert.True(t, testDB.db.Migrator().HasIndex("delegations", "idx_year_timestamp_desc"))
+	})
+}
+
+func TestDatabase_GetDelegations_Limit(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
+
+		for i := 1; i <= 150; i++ {
+			delegation := model.Delegation{
+				ID:        i,
+				Timestamp: time.Now().Add(time.Duration(i) * time.Hour).Format(time.RFC3339),
+				Amount:    i * 1000,
+				Delegator: "addr" + string(rune(i)),
+				Level:     i,
+				Year:      2023,
+			}
+			err := testDB.db.Create(&delegation).Error
+			assert.NoError(t, err)
+		}
+
+		// limit is 50
+		delegations, err := testDB.GetDelegations(context.Background(), model.DelegationQuery{Year: 2023, Offset: 0})
+		assert.NoError(t, err)
+		assert.Len(t, delegations, 50)
+
+		// test offset works correctly
+		delegations, err = testDB.GetDelegations(context.Background(), model.DelegationQuery{Year: 2023, Offset: 100})
+		assert.NoError(t, err)
+		assert.Len(t, delegations, 50)
+	})
+}
+
+func TestDatabase_GetLatestDelegation_EmptyDatabase(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
+
+		delegation, err := testDB.GetLatestDelegation(context.Background(), 2023)
+		assert.Error(t, err) // should return error when no records found
+		assert.Equal(t, model.Delegation{}, delegation)
+	})
+}
+
+func TestDatabase_GetLastDelegation(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
+
+		_, err := testDB.GetLastDelegation(context.Background())
+		assert.Error(t, err) // should return error when no records found
+
+		// the newest delegation is from a past year
+		for _, delegation := range []model.Delegation{
+			{ID: 1, Timestamp: "2022-06-01T00:00:00Z", Delegator: "addr1", Level: 100, Year: 2022},
+			{ID: 3, Timestamp: "2023-12-31T23:00:00Z", Delegator: "addr3", Level: 102, Year: 2023},
+			{ID: 2, Timestamp: "2023-01-01T00:00:00Z", Delegator: "addr2", Level: 101, Year: 2023},
+		} {
+			require.NoError(t, testDB.db.Create(&delegation).Error)
+		}
+
+		delegation, err := testDB.GetLastDelegation(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, 3, delegation.ID)
+		assert.Equal(t, "2023-12-31T23:00:00Z", delegation.Timestamp)
+		assert.Equal(t, 102, delegation.Level)
+	})
+}
+
+func TestDatabase_SaveBatch_DuplicateHandling(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
+
+		delegation1 := model.Delegation{
 			ID:        1,
 			Timestamp: "2023-01-01T00:00:00Z",
 			Amount:    1000,
 			Delegator: "addr1",
 			Level:     100,
 			Year:      2023,
-		},
-		{
-			ID:        2,
-			Timestamp: "2023-01-02T00:00:00Z",
-			Amount:    2000,
-			Delegator: "addr2",
+		}
+
+		err := testDB.SaveBatch(context.Background(), []model.Delegation{delegation1})
+		assert.NoError(t, err)
+
+		// try to save the same delegation again (should be ignored due to ON CONFLICT DO NOTHING)
+		delegation2 := model.Delegation{
+			ID:        1,                      // same ID
+			Timestamp: "2023-01-02T00:00:00Z", // different timestamp
+			Amount:    2000,                   // different amount
+			Delegator: "addr2",                // different delegator
+			Level:     101,                    // different level
+			Year:      2024,                   // different year
+		}
+
+		err = testDB.SaveBatch(context.Background(), []model.Delegation{delegation2})
+		assert.NoError(t, err)
+
+		var delegations []model.Delegation
+		err = testDB.db.Find(&delegations).Error
+		assert.NoError(t, err)
+		assert.Len(t, delegations, 1)
+		assert.Equal(t, delegation1.ID, delegations[0].ID)
+		assert.Equal(t, delegation1.Timestamp, delegations[0].Timestamp)
+		assert.Equal(t, delegation1.Amount, delegations[0].Amount)
+		assert.Equal(t, delegation1.Delegator, delegations[0].Delegator)
+		assert.Equal(t, delegation1.Level, delegations[0].Level)
+		assert.Equal(t, delegation1.Year, delegations[0].Year)
+	})
+}
+
+func TestDatabase_InterfaceCompliance(t *testing.T) {
+	var _ DelegationRepository = (*Database)(nil)
+}
+
+func TestDatabase_SaveBatchWithCheckpoint(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
+
+		first := []model.Delegation{
+			{ID: 1, Timestamp: "2023-01-01T00:00:00Z", Amount: 1000, Delegator: "addr1", Level: 100, Year: 2023},
+			{ID: 2, Timestamp: "2023-01-02T00:00:00Z", Amount: 2000, Delegator: "addr2", Level: 101, Year: 2023},
+		}
+		err := testDB.SaveBatchWithCheckpoint(context.Background(), first, model.SyncCheckpoint{
+			Name:      model.HeadCheckpoint,
+			LastID:    2,
 			Level:     101,
+			Timestamp: "2023-01-02T00:00:00Z",
+			Source:    "https://api.tzkt.io",
+			FromLevel: 90,
+		})
+		assert.NoError(t, err)
+
+		checkpoint, err := testDB.GetCheckpoint(context.Background(), model.HeadCheckpoint)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, checkpoint.LastID)
+		assert.Equal(t, 101, checkpoint.Level)
+		assert.Equal(t, "2023-01-02T00:00:00Z", checkpoint.Timestamp)
+		assert.Equal(t, "https://api.tzkt.io", checkpoint.Source)
+		assert.Equal(t, 90, checkpoint.FromLevel)
+		assert.False(t, checkpoint.UpdatedAt.IsZero())
+
+		// the checkpoint advances across years
+		second := []model.Delegation{
+			{ID: 3, Timestamp: "2024-01-01T00:00:00Z", Amount: 3000, Delegator: "addr3", Level: 200, Year: 2024},
+		}
+		err = testDB.SaveBatchWithCheckpoint(context.Background(), second, model.SyncCheckpoint{
+			Name:      model.HeadCheckpoint,
+			LastID:    3,
+			Level:     200,
+			Timestamp: "2024-01-01T00:00:00Z",
+			Source:    "https://api.tzkt.io",
+		})
+		assert.NoError(t, err)
+
+		checkpoint, err = testDB.GetCheckpoint(context.Background(), model.HeadCheckpoint)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, checkpoint.LastID)
+		assert.Equal(t, 200, checkpoint.Level)
+
+		var count int64
+		testDB.db.Model(&model.SyncCheckpoint{}).Count(&count)
+		assert.Equal(t, int64(1), count)
+	})
+}
+
+func TestDatabase_SaveBatchWithCheckpoint_Rollback(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
+
+		// make the checkpoint write fail: the delegations must not be committed either
+		err := testDB.db.Exec("DROP TABLE sync_checkpoints").Error
+		assert.NoError(t, err)
+
+		err = testDB.SaveBatchWithCheckpoint(context.Background(), []model.Delegation{
+			{ID: 1, Timestamp: "2023-01-01T00:00:00Z", Amount: 1000, Delegator: "addr1", Level: 100, Year: 2023},
+		}, model.SyncCheckpoint{Name: model.HeadCheckpoint, LastID: 1})
+		assert.Error(t, err)
+
+		var count int64
+		testDB.db.Model(&model.Delegation{}).Count(&count)
+		assert.Equal(t, int64(0), count)
+	})
+}
+
+func TestDatabase_GetCheckpoint_NotFound(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
+
+		checkpoint, err := testDB.GetCheckpoint(context.Background(), model.HeadCheckpoint)
+		assert.Error(t, err)
+		assert.Equal(t, model.SyncCheckpoint{}, checkpoint)
+	})
+}
+
+func TestDatabase_DeleteCheckpoint(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
+
+		err := testDB.SaveBatchWithCheckpoint(context.Background(), []model.Delegation{
+			{ID: 1, Timestamp: "2018-07-01T00:00:00Z", Amount: 1000, Delegator: "addr1", Level: 100, Year: 2018},
+		}, model.SyncCheckpoint{Name: model.BackfillCheckpoint, LastID: 1, Level: 100, Timestamp: "2018-07-01T00:00:00Z"})
+		assert.NoError(t, err)
+
+		err = testDB.DeleteCheckpoint(context.Background(), model.BackfillCheckpoint)
+		assert.NoError(t, err)
+
+		_, err = testDB.GetCheckpoint(context.Background(), model.BackfillCheckpoint)
+		assert.Error(t, err)
+
+		// the stored delegations are kept
+		var count int64
+		testDB.db.Model(&model.Delegation{}).Count(&count)
+		assert.Equal(t, int64(1), count)
+	})
+}
+
+func TestDatabase_CancelledContext(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := testDB.GetDelegations(ctx, model.DelegationQuery{Year: 2023, Offset: 0})
+		assert.ErrorIs(t, err, context.Canceled)
+
+		err = testDB.SaveBatch(ctx, []model.Delegation{
+			{ID: 1, Timestamp: "2023-01-01T00:00:00Z", Amount: 1000, Delegator: "addr1", Level: 100, Year: 2023},
+		})
+		assert.ErrorIs(t, err, context.Canceled)
+
+		var count int64
+		testDB.db.Model(&model.Delegation{}).Count(&count)
+		assert.Equal(t, int64(0), count)
+	})
+}
+
+func TestDatabase_SaveBatch_OperationDetails(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
+
+		delegation := model.Delegation{
+			ID:        1,
+			Timestamp: "2023-01-01T00:00:00Z",
+			Amount:    1000,
+			Delegator: "tz1delegator",
+			Level:     100,
 			Year:      2023,
-		},
-	}
+			Baker:     "tz1new",
+			PrevBaker: "tz1old",
+			Hash:      "oohash",
+			Block:     "BLblock",
+			Counter:   42,
+			Status:    "applied",
+			BakerFee:  397,
+			GasUsed:   1000,
+		}
+
+		err := testDB.SaveBatch(context.Background(), []model.Delegation{delegation})
+		assert.NoError(t, err)
+
+		delegations, err := testDB.GetDelegations(context.Background(), model.DelegationQuery{Year: 2023, Offset: 0})
+		assert.NoError(t, err)
+		assert.Len(t, delegations, 1)
+		assert.Equal(t, delegation, delegations[0])
+	})
+}
 
-	err := testDB.SaveBatch(delegations)
+func TestNewDatabase_MigratesExistingFile(t *testing.T) {
+	tempFile, err := os.CreateTemp("", "test_db_*.db")
 	assert.NoError(t, err)
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
 
-	var savedDelegations []model.Delegation
-	err = testDB.db.Find(&savedDelegations).Error
+	// a database file created before baker and operation details were stored
+	legacy, err := gorm.Open(sqlite.Open(tempFile.Name()), &gorm.Config{})
 	assert.NoError(t, err)
-	assert.Len(t, savedDelegations, 2)
-}
+	err = legacy.Exec(`CREATE TABLE delegations (id integer PRIMARY KEY, timestamp text, amount integer, delegator text, level integer, year integer)`).Error
+	assert.NoError(t, err)
+	err = legacy.Exec(`INSERT INTO delegations VALUES (1, '2023-01-01T00:00:00Z', 1000, 'addr1', 100, 2023)`).Error
+	assert.NoError(t, err)
+	sqlDB, _ := legacy.DB()
+	sqlDB.Close()
 
-func TestDatabase_IndexCreation(t *testing.T) {
-	testDB := NewTestDatabase(t)
-	defer testDB.Cleanup()
+	db, err := NewDatabase(tempFile.Name())
+	assert.NoError(t, err)
+
+	delegations, err := db.GetDelegations(context.Background(), model.DelegationQuery{Year: 2023, Offset: 0})
+	assert.NoError(t, err)
+	assert.Len(t, delegations, 1)
+	assert.Equal(t, "addr1", delegations[0].Delegator)
+	assert.Equal(t, "", delegations[0].Baker)
 
-	// verify that the index was created
 	var indexes []struct {
 		Name string
 	}
-	err := testDB.db.Raw("SELECT name FROM sqlite_master WHERE type='index' AND name='idx_year_timestamp_desc'").Scan(&indexes).Error
+	err = db.db.Raw("SELECT name FROM sqlite_master WHERE type='index' AND name='idx_delegations_baker'").Scan(&indexes).Error
 	assert.NoError(t, err)
 	assert.Len(t, indexes, 1)
-	assert.Equal(t, "idx_year_timestamp_desc", indexes[0].Name)
 }
 
-func TestDatabase_GetDelegations_Limit(t *testing.T) {
-	testDB := NewTestDatabase(t)
-	defer testDB.Cleanup()
+func TestDatabase_GetDelegations_KindFilter(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
 
-	for i := 1; i <= 150; i++ {
-		delegation := model.Delegation{
-			ID:        i,
-			Timestamp: time.Now().Add(time.Duration(i) * time.Hour).Format(time.RFC3339),
-			Amount:    i * 1000,
-			Delegator: "addr" + string(rune(i)),
-			Level:     i,
-			Year:      2023,
-		}
-		err := testDB.db.Create(&delegation).Error
+		err := testDB.SaveBatch(context.Background(), []model.Delegation{
+			{ID: 1, Timestamp: "2023-01-01T00:00:00Z", Delegator: "addr1", Year: 2023, Baker: "tz1a", Kind: model.KindDelegate},
+			{ID: 2, Timestamp: "2023-01-02T00:00:00Z", Delegator: "addr1", Year: 2023, Baker: "tz1b", PrevBaker: "tz1a", Kind: model.KindRedelegate},
+			{ID: 3, Timestamp: "2023-01-03T00:00:00Z", Delegator: "addr1", Year: 2023, PrevBaker: "tz1b", Kind: model.KindUndelegate},
+			{ID: 4, Timestamp: "2023-01-04T00:00:00Z", Delegator: "addr2", Year: 2023, Baker: "tz1b", PrevBaker: "tz1a", Kind: model.KindRedelegate},
+		})
 		assert.NoError(t, err)
-	}
 
-	// limit is 50
-	delegations, err := testDB.GetDelegations(2023, 0)
+		tests := []struct {
+			kind        model.DelegationKind
+			expectedIDs []int
+		}{
+			{kind: "", expectedIDs: []int{4, 3, 2, 1}},
+			{kind: model.KindDelegate, expectedIDs: []int{1}},
+			{kind: model.KindRedelegate, expectedIDs: []int{4, 2}},
+			{kind: model.KindUndelegate, expectedIDs: []int{3}},
+		}
+
+		for _, tt := range tests {
+			t.Run(string(tt.kind), func(t *testing.T) {
+				delegations, err := testDB.GetDelegations(context.Background(), model.DelegationQuery{Year: 2023, Kind: tt.kind})
+				assert.NoError(t, err)
+
+				var ids []int
+				for _, d := range delegations {
+					ids = append(ids, d.ID)
+				}
+				assert.Equal(t, tt.expectedIDs, ids)
+			})
+		}
+	})
+}
+
+func TestNewDatabase_DerivesKindOfExistingRows(t *testing.T) {
+	// rows stored by AutoMigrate databases before the kind was derived
+	path := newLegacyDatabase(t, "`baker` text,`prev_baker` text,`hash` text",
+		"INSERT INTO delegations (id, timestamp, year, hash, baker, prev_baker) VALUES "+
+			"(1, '2023-01-01T00:00:00Z', 2023, 'oo1', 'tz1a', NULL), "+
+			"(2, '2023-01-02T00:00:00Z', 2023, 'oo2', 'tz1b', 'tz1a'), "+
+			"(3, '2023-01-03T00:00:00Z', 2023, 'oo3', '', 'tz1b'), "+
+			"(4, '2023-01-04T00:00:00Z', 2023, NULL, NULL, NULL)")
+
+	db, err := NewDatabase(path)
 	assert.NoError(t, err)
-	assert.Len(t, delegations, 50)
 
-	// test offset works correctly
-	delegations, err = testDB.GetDelegations(2023, 100)
+	delegations, err := db.GetDelegations(context.Background(), model.DelegationQuery{Year: 2023})
 	assert.NoError(t, err)
-	assert.Len(t, delegations, 50)
+
+	kinds := map[int]model.DelegationKind{}
+	for _, d := range delegations {
+		kinds[d.ID] = d.Kind
+	}
+	assert.Equal(t, map[int]model.DelegationKind{
+		1: model.KindDelegate,
+		2: model.KindRedelegate,
+		3: model.KindUndelegate,
+		4: "", // stored before bakers were captured
+	}, kinds)
 }
 
-func TestDatabase_GetLatestDelegation_EmptyDatabase(t *testing.T) {
-	testDB := NewTestDatabase(t)
-	defer testDB.Cleanup()
+func TestDatabase_GetDelegations_StatusFilter(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
 
-	delegation, err := testDB.GetLatestDelegation(2023)
-	assert.Error(t, err) // should return error when no records found
-	assert.Equal(t, model.Delegation{}, delegation)
-}
+		err := testDB.SaveBatch(context.Background(), []model.Delegation{
+			{ID: 1, Timestamp: "2023-01-01T00:00:00Z", Year: 2023, Status: model.StatusApplied},
+			{ID: 2, Timestamp: "2023-01-02T00:00:00Z", Year: 2023, Status: model.StatusFailed},
+			{ID: 3, Timestamp: "2023-01-03T00:00:00Z", Year: 2023, Status: model.StatusBacktracked},
+			{ID: 4, Timestamp: "2023-01-04T00:00:00Z", Year: 2023, Status: model.StatusApplied},
+		})
+		assert.NoError(t, err)
 
-func TestDatabase_SaveBatch_DuplicateHandling(t *testing.T) {
-	testDB := NewTestDatabase(t)
-	defer testDB.Cleanup()
-
-	delegation1 := model.Delegation{
-		ID:        1,
-		Timestamp: "2023-01-01T00:00:00Z",
-		Amount:    1000,
-		Delegator: "addr1",
-		Level:     100,
-		Year:      2023,
-	}
+		tests := []struct {
+			status      string
+			expectedIDs []int
+		}{
+			{status: "", expectedIDs: []int{4, 3, 2, 1}},
+			{status: model.StatusApplied, expectedIDs: []int{4, 1}},
+			{status: model.StatusFailed, expectedIDs: []int{2}},
+			{status: model.StatusSkipped, expectedIDs: nil},
+		}
 
-	err := testDB.SaveBatch([]model.Delegation{delegation1})
-	assert.NoError(t, err)
+		for _, tt := range tests {
+			t.Run(tt.status, func(t *testing.T) {
+				delegations, err := testDB.GetDelegations(context.Background(), model.DelegationQuery{Year: 2023, Status: tt.status})
+				assert.NoError(t, err)
 
-	// try to save the same delegation again (should be ignored due to ON CONFLICT DO NOTHING)
-	delegation2 := model.Delegation{
-		ID:        1,                      // same ID
-		Timestamp: "2023-01-02T00:00:00Z", // different timestamp
-		Amount:    2000,                   // different amount
-		Delegator: "addr2",                // different delegator
-		Level:     101,                    // different level
-		Year:      2024,                   // different year
-	}
+				var ids []int
+				for _, d := range delegations {
+					ids = append(ids, d.ID)
+				}
+				assert.Equal(t, tt.expectedIDs, ids)
+			})
+		}
+	})
+}
 
-	err = testDB.SaveBatch([]model.Delegation{delegation2})
+func TestNewDatabase_AssumesExistingRowsApplied(t *testing.T) {
+	// rows stored by AutoMigrate databases before the status was captured
+	path := newLegacyDatabase(t, "`status` text",
+		"INSERT INTO delegations (id, timestamp, year, status) VALUES "+
+			"(1, '2023-01-01T00:00:00Z', 2023, NULL), "+
+			"(2, '2023-01-02T00:00:00Z', 2023, 'failed')")
+
+	db, err := NewDatabase(path)
 	assert.NoError(t, err)
 
-	var delegations []model.Delegation
-	err = testDB.db.Find(&delegations).Error
+	delegations, err := db.GetDelegations(context.Background(), model.DelegationQuery{Year: 2023, Status: model.StatusApplied})
 	assert.NoError(t, err)
 	assert.Len(t, delegations, 1)
-	assert.Equal(t, delegation1.ID, delegations[0].ID)
-	assert.Equal(t, delegation1.Timestamp, delegations[0].Timestamp)
-	assert.Equal(t, delegation1.Amount, delegations[0].Amount)
-	assert.Equal(t, delegation1.Delegator, delegations[0].Delegator)
-	assert.Equal(t, delegation1.Level, delegations[0].Level)
-	assert.Equal(t, delegation1.Year, delegations[0].Year)
+	assert.Equal(t, 1, delegations[0].ID)
 }
 
-func TestDatabase_InterfaceCompliance(t *testing.T) {
-	var _ DelegationRepository = (*Database)(nil)
+func TestDatabase_SaveBatchWithCheckpoint_EmptyBatch(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
+
+		// every operation of the page was filtered out, the checkpoint still moves past it
+		checkpoint := model.SyncCheckpoint{Name: model.HeadCheckpoint, LastID: 42, Level: 1000, Timestamp: "2023-01-01T00:00:00Z"}
+		err := testDB.SaveBatchWithCheckpoint(context.Background(), nil, checkpoint)
+		assert.NoError(t, err)
+
+		stored, err := testDB.GetCheckpoint(context.Background(), model.HeadCheckpoint)
+		assert.NoError(t, err)
+		assert.Equal(t, 42, stored.LastID)
+	})
+}
+
+func TestDatabase_GetBlockHashes(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
+
+		err := testDB.SaveBatch(context.Background(), []model.Delegation{
+			{ID: 1, Timestamp: "2023-01-01T00:00:00Z", Year: 2023, Level: 100, Block: "BLa"},
+			{ID: 2, Timestamp: "2023-01-01T00:00:00Z", Year: 2023, Level: 100, Block: "BLa"},
+			{ID: 3, Timestamp: "2023-01-01T00:01:00Z", Year: 2023, Level: 101, Block: "BLb"},
+			{ID: 4, Timestamp: "2023-01-01T00:02:00Z", Year: 2023, Level: 102}, // stored before blocks were captured
+			{ID: 5, Timestamp: "2023-01-01T00:03:00Z", Year: 2023, Level: 103, Block: "BLd"},
+		})
+		assert.NoError(t, err)
+
+		hashes, err := testDB.GetBlockHashes(context.Background(), 100, 102)
+		assert.NoError(t, err)
+		assert.Equal(t, map[int]string{100: "BLa", 101: "BLb"}, hashes)
+	})
+}
+
+func TestDatabase_RollbackFrom(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
+
+		err := testDB.SaveBatchWithCheckpoint(context.Background(), []model.Delegation{
+			{ID: 1, Timestamp: "2023-01-01T00:00:00Z", Year: 2023, Level: 100, Block: "BLa"},
+			{ID: 2, Timestamp: "2023-01-01T00:01:00Z", Year: 2023, Level: 101, Block: "BLb"},
+			{ID: 3, Timestamp: "2023-01-01T00:02:00Z", Year: 2023, Level: 102, Block: "BLc"},
+		}, model.SyncCheckpoint{Name: model.HeadCheckpoint, LastID: 3, Level: 102, Timestamp: "2023-01-01T00:02:00Z"})
+		assert.NoError(t, err)
+
+		err = testDB.RollbackFrom(context.Background(), 101, model.HeadCheckpoint)
+		assert.NoError(t, err)
+
+		delegations, err := testDB.GetDelegations(context.Background(), model.DelegationQuery{Year: 2023})
+		assert.NoError(t, err)
+		assert.Len(t, delegations, 1)
+		assert.Equal(t, 1, delegations[0].ID)
+
+		checkpoint, err := testDB.GetCheckpoint(context.Background(), model.HeadCheckpoint)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, checkpoint.LastID)
+		assert.Equal(t, 100, checkpoint.Level)
+		assert.Equal(t, "2023-01-01T00:00:00Z", checkpoint.Timestamp)
+	})
+}
+
+func TestDatabase_RollbackFrom_Everything(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
+
+		err := testDB.SaveBatchWithCheckpoint(context.Background(), []model.Delegation{
+			{ID: 1, Timestamp: "2023-01-01T00:00:00Z", Year: 2023, Level: 100, Block: "BLa"},
+		}, model.SyncCheckpoint{Name: model.HeadCheckpoint, LastID: 1, Level: 100})
+		assert.NoError(t, err)
+
+		err = testDB.RollbackFrom(context.Background(), 100, model.HeadCheckpoint)
+		assert.NoError(t, err)
+
+		_, err = testDB.GetCheckpoint(context.Background(), model.HeadCheckpoint)
+		assert.Error(t, err)
+	})
+}
+
+func TestDatabase_GetDelegations_Cursor(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
+		ctx := context.Background()
+
+		// delegations of a block share its timestamp
+		err := testDB.SaveBatch(ctx, []model.Delegation{
+			{ID: 1, Timestamp: "2023-01-01T00:00:00Z", Year: 2023},
+			{ID: 2, Timestamp: "2023-01-02T00:00:00Z", Year: 2023},
+			{ID: 3, Timestamp: "2023-01-02T00:00:00Z", Year: 2023},
+			{ID: 4, Timestamp: "2023-01-02T00:00:00Z", Year: 2023},
+			{ID: 5, Timestamp: "2023-01-03T00:00:00Z", Year: 2023},
+		})
+		assert.NoError(t, err)
+
+		var ids []int
+		query := model.DelegationQuery{Year: 2023, Limit: 2}
+		for page := 0; page < 5; page++ {
+			delegations, err := testDB.GetDelegations(ctx, query)
+			assert.NoError(t, err)
+			for _, d := range delegations {
+				ids = append(ids, d.ID)
+			}
+			if len(delegations) < query.Limit {
+				break
+			}
+			last := delegations[len(delegations)-1]
+			query.After = model.Cursor{Timestamp: last.Timestamp, ID: last.ID}
+
+			// delegations stored while paging do not shift the following pages
+			if page == 0 {
+				err := testDB.SaveBatch(ctx, []model.Delegation{{ID: 6, Timestamp: "2023-01-04T00:00:00Z", Year: 2023}})
+				assert.NoError(t, err)
+			}
+		}
+		assert.Equal(t, []int{5, 4, 3, 2, 1}, ids)
+	})
+}
+
+func TestDatabase_GetDelegations_Filters(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
+
+		err := testDB.SaveBatch(context.Background(), []model.Delegation{
+			{ID: 1, Timestamp: "2022-12-31T23:59:59Z", Year: 2022, Level: 90, Amount: 100, Delegator: "tz1a", Baker: "tz1baker", Hash: "oo1"},
+			{ID: 2, Timestamp: "2023-01-01T00:00:00Z", Year: 2023, Level: 100, Amount: 200, Delegator: "tz1a", Baker: "tz1other", Hash: "oo2"},
+			{ID: 3, Timestamp: "2023-01-02T00:00:00Z", Year: 2023, Level: 110, Amount: 300, Delegator: "tz1b", Baker: "tz1baker", Hash: "oo3"},
+			{ID: 4, Timestamp: "2023-02-01T00:00:00Z", Year: 2023, Level: 120, Amount: 400, Delegator: "tz1a", Baker: "tz1baker", Hash: "oo4"},
+		})
+		assert.NoError(t, err)
+
+		tests := []struct {
+			name        string
+			query       model.DelegationQuery
+			expectedIDs []int
+		}{
+			{name: "delegator across years", query: model.DelegationQuery{Delegator: "tz1a"}, expectedIDs: []int{4, 2, 1}},
+			{name: "delegator in a year", query: model.DelegationQuery{Year: 2023, Delegator: "tz1a"}, expectedIDs: []int{4, 2}},
+			{name: "baker", query: model.DelegationQuery{Baker: "tz1baker"}, expectedIDs: []int{4, 3, 1}},
+			{name: "hash", query: model.DelegationQuery{Hash: "oo3"}, expectedIDs: []int{3}},
+			{name: "level range", query: model.DelegationQuery{MinLevel: 100, MaxLevel: 110}, expectedIDs: []int{3, 2}},
+			{name: "timestamp range", query: model.DelegationQuery{From: "2023-01-01T00:00:00Z", To: "2023-02-01T00:00:00Z"}, expectedIDs: []int{3, 2}},
+			{name: "amount range", query: model.DelegationQuery{MinAmount: 200, MaxAmount: 300}, expectedIDs: []int{3, 2}},
+			{name: "combined", query: model.DelegationQuery{Delegator: "tz1a", Baker: "tz1baker", MinAmount: 150}, expectedIDs: []int{4}},
+			{name: "paginated", query: model.DelegationQuery{Baker: "tz1baker", After: model.Cursor{Timestamp: "2023-02-01T00:00:00Z", ID: 4}}, expectedIDs: []int{3, 1}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				delegations, err := testDB.GetDelegations(context.Background(), tt.query)
+				assert.NoError(t, err)
+
+				var ids []int
+				for _, d := range delegations {
+					ids = append(ids, d.ID)
+				}
+				assert.Equal(t, tt.expectedIDs, ids)
+			})
+		}
+	})
+}
+
+func TestDatabase_GetDelegations_Sort(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
+		ctx := context.Background()
+
+		// amounts and levels are shared, ties are broken by ID
+		err := testDB.SaveBatch(ctx, []model.Delegation{
+			{ID: 1, Timestamp: "2023-01-01T00:00:00Z", Year: 2023, Level: 100, Amount: 300},
+			{ID: 2, Timestamp: "2023-01-02T00:00:00Z", Year: 2023, Level: 101, Amount: 100},
+			{ID: 3, Timestamp: "2023-01-02T00:00:00Z", Year: 2023, Level: 101, Amount: 300},
+			{ID: 4, Timestamp: "2023-01-03T00:00:00Z", Year: 2023, Level: 102, Amount: 200},
+			{ID: 5, Timestamp: "2023-01-04T00:00:00Z", Year: 2023, Level: 103, Amount: 300},
+			{ID: 6, Timestamp: "2022-12-31T00:00:00Z", Year: 2022, Level: 99, Amount: 1000},
+		})
+		assert.NoError(t, err)
+
+		tests := []struct {
+			sort        model.SortField
+			ascending   bool
+			expectedIDs []int
+		}{
+			{sort: model.SortByTimestamp, expectedIDs: []int{5, 4, 3, 2, 1}},
+			{sort: model.SortByTimestamp, ascending: true, expectedIDs: []int{1, 2, 3, 4, 5}},
+			{sort: model.SortByAmount, expectedIDs: []int{5, 3, 1, 4, 2}},
+			{sort: model.SortByAmount, ascending: true, expectedIDs: []int{2, 4, 1, 3, 5}},
+			{sort: model.SortByLevel, expectedIDs: []int{5, 4, 3, 2, 1}},
+			{sort: model.SortByLevel, ascending: true, expectedIDs: []int{1, 2, 3, 4, 5}},
+		}
+
+		for _, tt := range tests {
+			t.Run(fmt.Sprintf("%s ascending %v", tt.sort, tt.ascending), func(t *testing.T) {
+				// paging through with the cursor yields the same order as a single page
+				query := model.DelegationQuery{Year: 2023, Limit: 10, Sort: tt.sort, Ascending: tt.ascending}
+				all, err := testDB.GetDelegations(ctx, query)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expectedIDs, delegationIDs(all))
+
+				var paged []int
+				query.Limit = 2
+				for page := 0; page < 5; page++ {
+					delegations, err := testDB.GetDelegations(ctx, query)
+					assert.NoError(t, err)
+					paged = append(paged, delegationIDs(delegations)...)
+					if len(delegations) < query.Limit {
+						break
+					}
+					query.After = model.CursorOf(delegations[len(delegations)-1])
+				}
+				assert.Equal(t, tt.expectedIDs, paged)
+			})
+		}
+	})
+}
+
+func TestDatabase_CountDelegations(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
+		ctx := context.Background()
+
+		err := testDB.SaveBatch(ctx, []model.Delegation{
+			{ID: 1, Timestamp: "2022-12-31T00:00:00Z", Year: 2022, Amount: 100},
+			{ID: 2, Timestamp: "2023-01-01T00:00:00Z", Year: 2023, Amount: 200},
+			{ID: 3, Timestamp: "2023-01-02T00:00:00Z", Year: 2023, Amount: 300},
+			{ID: 4, Timestamp: "2023-01-03T00:00:00Z", Year: 2023, Amount: 400},
+		})
+		assert.NoError(t, err)
+
+		// the count covers every page, whatever the limit, offset and cursor
+		count, err := testDB.CountDelegations(ctx, model.DelegationQuery{Year: 2023, Limit: 1, Offset: 1, After: model.Cursor{Timestamp: "2023-01-02T00:00:00Z", ID: 3}})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, count)
+
+		count, err = testDB.CountDelegations(ctx, model.DelegationQuery{MinAmount: 200, MaxAmount: 300})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, count)
+
+		count, err = testDB.CountDelegations(ctx, model.DelegationQuery{Year: 2021})
+		assert.NoError(t, err)
+		assert.Equal(t, 0, count)
+	})
+}
+
+func delegationIDs(delegations []model.Delegation) []int {
+	var ids []int
+	for _, d := range delegations {
+		ids = append(ids, d.ID)
+	}
+	return ids
+}
+
+func TestDatabase_GetDelegatorDelegations(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
+		ctx := context.Background()
+
+		err := testDB.SaveBatch(ctx, []model.Delegation{
+			{ID: 1, Timestamp: "2019-03-01T00:00:00Z", Year: 2019, Delegator: "tz1a"},
+			{ID: 2, Timestamp: "2021-06-01T00:00:00Z", Year: 2021, Delegator: "tz1b"},
+			{ID: 3, Timestamp: "2021-06-01T00:00:00Z", Year: 2021, Delegator: "tz1a"},
+			{ID: 4, Timestamp: "2021-06-01T00:00:00Z", Year: 2021, Delegator: "tz1a"},
+			{ID: 5, Timestamp: "2024-01-01T00:00:00Z", Year: 2024, Delegator: "tz1a"},
+		})
+		assert.NoError(t, err)
+
+		// every year is listed, even when the query sets one
+		var paged []int
+		query := model.DelegationQuery{Year: 2024, Limit: 2}
+		for page := 0; page < 5; page++ {
+			delegations, err := testDB.GetDelegatorDelegations(ctx, "tz1a", query)
+			assert.NoError(t, err)
+			paged = append(paged, delegationIDs(delegations)...)
+			if len(delegations) < query.Limit {
+				break
+			}
+			query.After = model.CursorOf(delegations[len(delegations)-1])
+		}
+		assert.Equal(t, []int{5, 4, 3, 1}, paged)
+
+		delegations, err := testDB.GetDelegatorDelegations(ctx, "tz1unknown", model.DelegationQuery{})
+		assert.NoError(t, err)
+		assert.Empty(t, delegations)
+	})
+}
+
+func TestDatabase_CurrentDelegation(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
+		ctx := context.Background()
+
+		current := func(delegator string) model.CurrentDelegation {
+			t.Helper()
+			c, err := testDB.GetCurrentDelegation(ctx, delegator)
+			require.NoError(t, err)
+			return c
+		}
+
+		// the last applied operation of a batch sets the state, failed ones do not
+		err := testDB.SaveBatch(ctx, []model.Delegation{
+			{ID: 1, Timestamp: "2023-01-01T00:00:00Z", Year: 2023, Level: 100, Delegator: "tz1a", Baker: "tz1baker", Amount: 100, Status: model.StatusApplied, Kind: model.KindDelegate},
+			{ID: 2, Timestamp: "2023-01-02T00:00:00Z", Year: 2023, Level: 110, Delegator: "tz1a", Baker: "tz1other", PrevBaker: "tz1baker", Amount: 200, Status: model.StatusApplied, Kind: model.KindRedelegate},
+			{ID: 3, Timestamp: "2023-01-03T00:00:00Z", Year: 2023, Level: 120, Delegator: "tz1a", Baker: "tz1third", PrevBaker: "tz1other", Amount: 300, Status: model.StatusFailed, Kind: model.KindRedelegate},
+			{ID: 4, Timestamp: "2023-01-03T00:00:00Z", Year: 2023, Level: 120, Delegator: "tz1b", Baker: "tz1baker", Amount: 50, Status: model.StatusApplied, Kind: model.KindDelegate},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, model.CurrentDelegation{Delegator: "tz1a", Baker: "tz1other", Level: 110, Timestamp: "2023-01-02T00:00:00Z", Amount: 200, DelegationID: 2}, current("tz1a"))
+		assert.Equal(t, "tz1baker", current("tz1b").Baker)
+
+		// an undelegation leaves the address without a baker
+		err = testDB.SaveBatchWithCheckpoint(ctx, []model.Delegation{
+			{ID: 5, Timestamp: "2023-02-01T00:00:00Z", Year: 2023, Level: 200, Delegator: "tz1a", PrevBaker: "tz1other", Amount: 250, Status: model.StatusApplied, Kind: model.KindUndelegate},
+		}, model.SyncCheckpoint{Name: model.HeadCheckpoint, LastID: 5, Level: 200})
+		require.NoError(t, err)
+		assert.Equal(t, model.CurrentDelegation{Delegator: "tz1a", Level: 200, Timestamp: "2023-02-01T00:00:00Z", Amount: 250, DelegationID: 5}, current("tz1a"))
+
+		// older operations stored later, as by a backfill, do not override it
+		err = testDB.SaveBatch(ctx, []model.Delegation{
+			{ID: 1, Timestamp: "2023-01-01T00:00:00Z", Year: 2023, Level: 100, Delegator: "tz1a", Baker: "tz1baker", Amount: 100, Status: model.StatusApplied},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 5, current("tz1a").DelegationID)
+
+		// rolling the undelegation back restores the previous state
+		require.NoError(t, testDB.RollbackFrom(ctx, 150, model.HeadCheckpoint))
+		assert.Equal(t, "tz1other", current("tz1a").Baker)
+		assert.Equal(t, 2, current("tz1a").DelegationID)
+		assert.Equal(t, 4, current("tz1b").DelegationID)
+
+		// an address whose every applied operation was rolled back has no state
+		require.NoError(t, testDB.RollbackFrom(ctx, 100, model.HeadCheckpoint))
+		_, err = testDB.GetCurrentDelegation(ctx, "tz1a")
+		assert.ErrorIs(t, err, ErrNotFound)
+		_, err = testDB.GetCurrentDelegation(ctx, "tz1unknown")
+		assert.ErrorIs(t, err, ErrNotFound)
+	})
 }
@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"tezos-delegation-service/internal/model"
+)
+
+// ErrCyclesNotDerived is returned for cycle buckets while no protocol is stored, so that no
+// delegation has a cycle yet.
+var ErrCyclesNotDerived = errors.New("cycles are not derived yet, the protocols of the chain are not stored")
+
+// GetDelegationStats aggregates the applied delegations between the bounds of the query by bucket,
+// in SQL: the median amount of a bucket averages its middle one or two amounts once ranked. Buckets
+// without any delegation are left out, as are delegations whose cycle is not derived yet from cycle
+// buckets; ErrCyclesNotDerived is returned for those before any protocol is stored.
+func (d *Database) GetDelegationStats(ctx context.Context, query model.StatsQuery) ([]model.DelegationStats, error) {
+	bucket, err := d.bucket(query.Interval)
+	if err != nil {
+		return nil, err
+	}
+
+	db := d.db.WithContext(ctx).Model(&model.Delegation{}).
+		Select(bucket+" AS bucket, amount, delegator, kind, "+
+			"ROW_NUMBER() OVER (PARTITION BY "+bucket+" ORDER BY amount) AS rn, "+
+			"COUNT(*) OVER (PARTITION BY "+bucket+") AS n").
+		Where("status = ?", model.StatusApplied)
+	if query.Interval == model.IntervalCycle {
+		// without protocols every bucket would be missing, rather than empty
+		var protocols int64
+		if err := d.db.WithContext(ctx).Model(&model.Protocol{}).Count(&protocols).Error; err != nil {
+			return nil, err
+		}
+		if protocols == 0 {
+			return nil, ErrCyclesNotDerived
+		}
+		// left out until the protocols are known
+		db = db.Where("cycle IS NOT NULL")
+	}
+	// the year bounds the scan of the (year, timestamp) index
+	if query.From != "" {
+		db = db.Where("year >= ? AND timestamp >= ?", yearOf(query.From), query.From)
+	}
+	if query.To != "" {
+		db = db.Where("year <= ? AND timestamp < ?", yearOf(query.To), query.To)
+	}
+
+	var stats []model.DelegationStats
+	err = d.db.WithContext(ctx).Table("(?) AS bucketed", db).
+		Select("bucket, " +
+			"COUNT(*) AS delegations, " +
+			"SUM(amount) AS total_amount, " +
+			"AVG(CASE WHEN rn IN ((n + 1) / 2, (n + 2) / 2) THEN amount END) AS median_amount, " +
+			"COUNT(DISTINCT delegator) AS delegators, " +
+			"SUM(CASE WHEN kind = '" + string(model.KindUndelegate) + "' THEN 1 ELSE 0 END) AS undelegations").
+		Group("bucket").
+		Order("bucket").
+		Scan(&stats).Error
+
+	return stats, err
+}
+
+// bucket returns the SQL expression of the date starting the bucket of a delegation, or of its
+// cycle. Timestamps are stored as RFC3339 UTC strings, starting with the date.
+func (d *Database) bucket(interval model.StatsInterval) (string, error) {
+	switch interval {
+	case model.IntervalCycle:
+		return "cycle", nil
+	case model.IntervalDay:
+		return "substr(timestamp, 1, 10)", nil
+	case model.IntervalMonth:
+		return "substr(timestamp, 1, 7) || '-01'", nil
+	case model.IntervalWeek:
+		if d.dialect == Postgres {
+			return "to_char(date_trunc('week', CAST(substr(timestamp, 1, 10) AS date)), 'YYYY-MM-DD')", nil
+		}
+		// the following Sunday, or the same day, then back to its Monday
+		return "date(substr(timestamp, 1, 10), 'weekday 0', '-6 days')", nil
+	}
+	return "", fmt.Errorf("unknown stats interval %q", interval)
+}
+
+// yearOf returns the year of an RFC3339 timestamp.
+func yearOf(timestamp string) int {
+	year, _ := strconv.Atoi(timestamp[:4])
+	return year
+}
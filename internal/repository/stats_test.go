@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"tezos-delegation-service/internal/model"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDatabase_GetDelegationStats(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
+		ctx := context.Background()
+
+		delegation := func(id int, timestamp string, delegator string, amount int, kind model.DelegationKind) model.Delegation {
+			return model.Delegation{ID: id, Level: id * 100, Timestamp: timestamp, Year: yearOf(timestamp), Delegator: delegator, Amount: amount, Kind: kind, Status: model.StatusApplied}
+		}
+		err := testDB.SaveBatch(ctx, []model.Delegation{
+			// Sunday 2023-12-31 belongs to the week of Monday 2023-12-25
+			delegation(1, "2023-12-31T23:00:00Z", "tz1a", 100, model.KindDelegate),
+			// Monday 2024-01-01
+			delegation(2, "2024-01-01T00:00:00Z", "tz1a", 400, model.KindRedelegate),
+			delegation(3, "2024-01-01T10:00:00Z", "tz1b", 100, model.KindDelegate),
+			delegation(4, "2024-01-01T12:00:00Z", "tz1c", 300, model.KindUndelegate),
+			delegation(5, "2024-01-01T13:00:00Z", "tz1d", 200, model.KindDelegate),
+			// Sunday 2024-01-07
+			delegation(6, "2024-01-07T00:00:00Z", "tz1a", 700, model.KindUndelegate),
+			delegation(7, "2024-02-01T00:00:00Z", "tz1e", 900, model.KindDelegate),
+			{ID: 8, Timestamp: "2024-01-01T14:00:00Z", Year: 2024, Delegator: "tz1f", Amount: 5000, Status: model.StatusFailed},
+		})
+		require.NoError(t, err)
+		// cycles of 300 levels from cycle 9 on
+		require.NoError(t, testDB.SaveProtocols(ctx, model.Protocols{{Code: 1, Hash: "PtOne", FirstLevel: 1, FirstCycle: 9, FirstCycleLevel: 1, BlocksPerCycle: 300}}))
+
+		tests := []struct {
+			name     string
+			query    model.StatsQuery
+			expected []model.DelegationStats
+		}{
+			{
+				name:  "day",
+				query: model.StatsQuery{Interval: model.IntervalDay, From: "2024-01-01T00:00:00Z", To: "2024-01-08T00:00:00Z"},
+				expected: []model.DelegationStats{
+					{Bucket: "2024-01-01", Delegations: 4, TotalAmount: 1000, MedianAmount: 250, Delegators: 4, Undelegations: 1},
+					{Bucket: "2024-01-07", Delegations: 1, TotalAmount: 700, MedianAmount: 700, Delegators: 1, Undelegations: 1},
+				},
+			},
+			{
+				name:  "week",
+				query: model.StatsQuery{Interval: model.IntervalWeek, From: "2023-12-01T00:00:00Z", To: "2024-01-31T00:00:00Z"},
+				expected: []model.DelegationStats{
+					{Bucket: "2023-12-25", Delegations: 1, TotalAmount: 100, MedianAmount: 100, Delegators: 1},
+					{Bucket: "2024-01-01", Delegations: 5, TotalAmount: 1700, MedianAmount: 300, Delegators: 4, Undelegations: 2},
+				},
+			},
+			{
+				name:  "month",
+				query: model.StatsQuery{Interval: model.IntervalMonth},
+				expected: []model.DelegationStats{
+					{Bucket: "2023-12-01", Delegations: 1, TotalAmount: 100, MedianAmount: 100, Delegators: 1},
+					{Bucket: "2024-01-01", Delegations: 5, TotalAmount: 1700, MedianAmount: 300, Delegators: 4, Undelegations: 2},
+					{Bucket: "2024-02-01", Delegations: 1, TotalAmount: 900, MedianAmount: 900, Delegators: 1},
+				},
+			},
+			{
+				name:  "cycle",
+				query: model.StatsQuery{Interval: model.IntervalCycle},
+				expected: []model.DelegationStats{
+					{Bucket: "9", Delegations: 3, TotalAmount: 600, MedianAmount: 100, Delegators: 2},
+					{Bucket: "10", Delegations: 3, TotalAmount: 1200, MedianAmount: 300, Delegators: 3, Undelegations: 2},
+					{Bucket: "11", Delegations: 1, TotalAmount: 900, MedianAmount: 900, Delegators: 1},
+				},
+			},
+			{
+				name:  "empty range",
+				query: model.StatsQuery{Interval: model.IntervalDay, From: "2022-01-01T00:00:00Z", To: "2022-02-01T00:00:00Z"},
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				stats, err := testDB.GetDelegationStats(ctx, tt.query)
+				require.NoError(t, err)
+				assert.Equal(t, tt.expected, stats)
+			})
+		}
+
+		_, err = testDB.GetDelegationStats(ctx, model.StatsQuery{Interval: "fortnight"})
+		assert.Error(t, err)
+	})
+}
+
+func TestDatabase_GetDelegationStats_CyclesNotDerived(t *testing.T) {
+	forEachBackend(t, func(t *testing.T, dialect Dialect) {
+		testDB := NewTestDatabase(t, dialect)
+		ctx := context.Background()
+
+		require.NoError(t, testDB.SaveBatch(ctx, []model.Delegation{
+			{ID: 1, Level: 100, Timestamp: "2024-01-01T00:00:00Z", Year: 2024, Delegator: "tz1a", Amount: 100, Status: model.StatusApplied},
+		}))
+
+		_, err := testDB.GetDelegationStats(ctx, model.StatsQuery{Interval: model.IntervalCycle})
+		assert.ErrorIs(t, err, ErrCyclesNotDerived)
+
+		// other buckets do not need the protocols
+		stats, err := testDB.GetDelegationStats(ctx, model.StatsQuery{Interval: model.IntervalDay})
+		require.NoError(t, err)
+		assert.Len(t, stats, 1)
+	})
+}
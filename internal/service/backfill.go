@@ -0,0 +1,187 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"tezos-delegation-service/internal/model"
+	"tezos-delegation-service/internal/repository"
+	"tezos-delegation-service/internal/transport"
+	"time"
+)
+
+// BackfillProgress describes how far a historical backfill has walked towards the head.
+type BackfillProgress struct {
+	StartLevel      int
+	CurrentLevel    int
+	HeadLevel       int
+	LevelsDone      int
+	LevelsRemaining int
+	Stored          int
+	Elapsed         time.Duration
+	ETA             time.Duration
+}
+
+// HistoricalBackfill walks TzKT from genesis (or a given timestamp or level) up to the head, storing
+// every delegation on the way. Progress is persisted in the backfill checkpoint, so an interrupted
+// run resumes where it stopped.
+type HistoricalBackfill struct {
+	ctx         context.Context
+	repo        repository.DelegationRepository
+	client      XtzService
+	logger      *slog.Logger
+	from        transport.Start
+	until       string
+	callTimeout time.Duration
+	OnProgress  func(BackfillProgress)
+}
+
+type BackfillOption func(*HistoricalBackfill)
+
+// WithFromLevel starts the backfill at the given level, or at the from timestamp when it is later.
+// The level is kept in the checkpoint, so that a resumed run starts from it as well.
+func WithFromLevel(level int) BackfillOption {
+	return func(b *HistoricalBackfill) {
+		b.from.Level = level
+	}
+}
+
+// WithUntil stops the backfill once it stored the delegations up to the given RFC3339 timestamp
+// instead of walking up to the head. The last page may hold a few later delegations, which are
+// stored as well.
+func WithUntil(until string) BackfillOption {
+	return func(b *HistoricalBackfill) {
+		b.until = until
+	}
+}
+
+// NewHistoricalBackfill creates a backfill starting after the given RFC3339 timestamp, or from
+// genesis when from is empty.
+func NewHistoricalBackfill(ctx context.Context, repo repository.DelegationRepository, fetcher XtzService, logger *slog.Logger, from string, opts ...BackfillOption) *HistoricalBackfill {
+	b := &HistoricalBackfill{
+		ctx:         ctx,
+		repo:        repo,
+		client:      fetcher,
+		logger:      logger,
+		from:        transport.Start{Timestamp: from},
+		callTimeout: defaultCallTimeout,
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+func (b *HistoricalBackfill) Run() error {
+	var until time.Time
+	if b.until != "" {
+		var err error
+		if until, err = time.Parse(time.RFC3339, b.until); err != nil {
+			return err
+		}
+	}
+
+	headLevel, err := b.client.GetHeadLevel(b.ctx)
+	if err != nil {
+		return err
+	}
+
+	afterID := 0
+	progress := BackfillProgress{HeadLevel: headLevel}
+
+	checkpoint, err := b.repo.GetCheckpoint(b.ctx, model.BackfillCheckpoint)
+	if err == nil && checkpoint.LastID > 0 {
+		afterID = checkpoint.LastID
+		if checkpoint.FromLevel != b.from.Level {
+			if b.from.Level != 0 {
+				b.logger.Warn("Resuming from the level the backfill was started from", "from_level", checkpoint.FromLevel, "ignored", b.from.Level)
+			}
+			b.from.Level = checkpoint.FromLevel
+		}
+		progress.StartLevel = checkpoint.Level
+		progress.CurrentLevel = checkpoint.Level
+		b.logger.Info("Resuming historical backfill", "level", checkpoint.Level, "timestamp", checkpoint.Timestamp)
+	} else {
+		b.logger.Info("Starting historical backfill", "from", b.from.Timestamp, "from_level", b.from.Level, "head", headLevel)
+	}
+
+	start := time.Now()
+	for {
+		select {
+		case <-b.ctx.Done():
+			b.logger.Info("Historical backfill interrupted", "level", progress.CurrentLevel)
+			return b.ctx.Err()
+		default:
+		}
+
+		results, checkpoint, err := b.storePage(afterID)
+		if err != nil {
+			return err
+		}
+		if checkpoint.LastID == 0 {
+			return b.complete(progress, start)
+		}
+
+		// the checkpoint may be past the last delegation when the source walked empty levels
+		afterID = checkpoint.LastID
+		if progress.StartLevel == 0 {
+			progress.StartLevel = checkpoint.Level
+			if len(results) > 0 {
+				progress.StartLevel = results[0].Level
+			}
+		}
+		progress.CurrentLevel = checkpoint.Level
+		progress.Stored += len(results)
+		progress.Elapsed = time.Since(start)
+		b.report(progress)
+
+		if !until.IsZero() && reached(checkpoint.Timestamp, until) {
+			return b.complete(progress, start)
+		}
+	}
+}
+
+// complete clears the checkpoint so that the next run starts over.
+func (b *HistoricalBackfill) complete(progress BackfillProgress, start time.Time) error {
+	b.logger.Info("Historical backfill completed", "stored", progress.Stored, "duration", time.Since(start).String())
+	return b.repo.DeleteCheckpoint(b.ctx, model.BackfillCheckpoint)
+}
+
+// reached reports whether the RFC3339 timestamp is at or after until. Unparsable timestamps were
+// already rejected when the page was stored.
+func reached(timestamp string, until time.Time) bool {
+	t, err := time.Parse(time.RFC3339, timestamp)
+	return err == nil && !t.Before(until)
+}
+
+func (b *HistoricalBackfill) storePage(afterID int) ([]model.Delegation, model.SyncCheckpoint, error) {
+	ctx, cancel := context.WithTimeout(b.ctx, b.callTimeout)
+	defer cancel()
+
+	return b.client.StoreDelegations(ctx, model.BackfillCheckpoint, afterID, b.from)
+}
+
+func (b *HistoricalBackfill) report(progress BackfillProgress) {
+	// the head keeps moving while we backfill
+	if progress.CurrentLevel > progress.HeadLevel {
+		progress.HeadLevel = progress.CurrentLevel
+	}
+	progress.LevelsDone = progress.CurrentLevel - progress.StartLevel
+	progress.LevelsRemaining = progress.HeadLevel - progress.CurrentLevel
+	if progress.LevelsDone > 0 {
+		perLevel := progress.Elapsed / time.Duration(progress.LevelsDone)
+		progress.ETA = perLevel * time.Duration(progress.LevelsRemaining)
+	}
+
+	b.logger.Info("Historical backfill progress",
+		"level", progress.CurrentLevel,
+		"head", progress.HeadLevel,
+		"levels_done", progress.LevelsDone,
+		"levels_remaining", progress.LevelsRemaining,
+		"stored", progress.Stored,
+		"eta", progress.ETA.Round(time.Second).String(),
+	)
+
+	if b.OnProgress != nil {
+		b.OnProgress(progress)
+	}
+}
@@ -0,0 +1,249 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"tezos-delegation-service/internal/model"
+	"tezos-delegation-service/internal/transport"
+)
+
+func TestHistoricalBackfill_Run(t *testing.T) {
+	repo := &MockPollerRepository{checkpointErr: errors.New("record not found")}
+	service := &MockPollerService{
+		storeResults: [][]model.Delegation{
+			{
+				{ID: 1, Timestamp: "2018-07-01T00:00:00Z", Level: 100},
+				{ID: 2, Timestamp: "2018-07-01T01:00:00Z", Level: 150},
+			},
+			{
+				{ID: 3, Timestamp: "2018-07-02T00:00:00Z", Level: 200},
+			},
+			{}, // reached the head
+		},
+		storeErrors: []error{nil, nil, nil},
+		headLevel:   300,
+	}
+
+	var progress []BackfillProgress
+	backfill := NewHistoricalBackfill(context.Background(), repo, service, slog.Default(), "")
+	backfill.OnProgress = func(p BackfillProgress) {
+		progress = append(progress, p)
+	}
+
+	if err := backfill.Run(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expectedAfterIDs := []int{0, 2, 3}
+	if len(service.afterIDs) != len(expectedAfterIDs) {
+		t.Fatalf("Expected %d calls to StoreDelegations, got %d", len(expectedAfterIDs), len(service.afterIDs))
+	}
+	for i, expected := range expectedAfterIDs {
+		if service.afterIDs[i] != expected {
+			t.Errorf("Expected call %d to page after ID %d, got %d", i, expected, service.afterIDs[i])
+		}
+	}
+
+	if len(progress) != 2 {
+		t.Fatalf("Expected 2 progress reports, got %d", len(progress))
+	}
+	last := progress[1]
+	if last.StartLevel != 100 || last.CurrentLevel != 200 || last.HeadLevel != 300 {
+		t.Errorf("Unexpected levels in progress %+v", last)
+	}
+	if last.LevelsDone != 100 || last.LevelsRemaining != 100 {
+		t.Errorf("Expected 100 levels done and 100 remaining, got %+v", last)
+	}
+	if last.Stored != 3 {
+		t.Errorf("Expected 3 stored delegations, got %d", last.Stored)
+	}
+
+	// a completed backfill clears its checkpoint so the next run starts over
+	if len(repo.deleted) != 1 || repo.deleted[0] != model.BackfillCheckpoint {
+		t.Errorf("Expected backfill checkpoint to be deleted, got %v", repo.deleted)
+	}
+}
+
+func TestHistoricalBackfill_RunPastEmptyLevels(t *testing.T) {
+	repo := &MockPollerRepository{checkpointErr: errors.New("record not found")}
+	service := &MockPollerService{
+		storeResults: [][]model.Delegation{
+			{}, // levels without delegations walked
+			{
+				{ID: 2100000, Timestamp: "2018-07-01T01:00:00Z", Level: 21},
+			},
+			{},
+		},
+		storeErrors: []error{nil, nil, nil},
+		scanned: map[int]model.SyncCheckpoint{
+			0: {LastID: 2099999, Level: 20, Timestamp: "2018-07-01T00:00:00Z"},
+		},
+		headLevel: 30,
+	}
+
+	backfill := NewHistoricalBackfill(context.Background(), repo, service, slog.Default(), "")
+	if err := backfill.Run(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expectedAfterIDs := []int{0, 2099999, 2100000}
+	if len(service.afterIDs) != len(expectedAfterIDs) {
+		t.Fatalf("Expected %d calls to StoreDelegations, got %v", len(expectedAfterIDs), service.afterIDs)
+	}
+	for i, expected := range expectedAfterIDs {
+		if service.afterIDs[i] != expected {
+			t.Errorf("Expected call %d to page after ID %d, got %d", i, expected, service.afterIDs[i])
+		}
+	}
+}
+
+func TestHistoricalBackfill_RunFrom(t *testing.T) {
+	repo := &MockPollerRepository{checkpointErr: errors.New("record not found")}
+	service := &MockPollerService{
+		storeResults: [][]model.Delegation{{}},
+		storeErrors:  []error{nil},
+	}
+
+	backfill := NewHistoricalBackfill(context.Background(), repo, service, slog.Default(), "2019-01-01T00:00:00Z")
+	if err := backfill.Run(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if service.froms[0] != (transport.Start{Timestamp: "2019-01-01T00:00:00Z"}) {
+		t.Errorf("Expected backfill to start from '2019-01-01T00:00:00Z', got %+v", service.froms[0])
+	}
+}
+
+func TestHistoricalBackfill_RunFromLevel(t *testing.T) {
+	repo := &MockPollerRepository{checkpointErr: errors.New("record not found")}
+	service := &MockPollerService{
+		storeResults: [][]model.Delegation{{}},
+		storeErrors:  []error{nil},
+	}
+
+	backfill := NewHistoricalBackfill(context.Background(), repo, service, slog.Default(), "", WithFromLevel(5000000))
+	if err := backfill.Run(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if service.froms[0] != (transport.Start{Level: 5000000}) {
+		t.Errorf("Expected backfill to start from level 5000000, got %+v", service.froms[0])
+	}
+}
+
+func TestHistoricalBackfill_RunUntil(t *testing.T) {
+	repo := &MockPollerRepository{checkpointErr: errors.New("record not found")}
+	service := &MockPollerService{
+		storeResults: [][]model.Delegation{
+			{{ID: 1, Timestamp: "2019-06-01T00:00:00Z", Level: 100}},
+			{{ID: 2, Timestamp: "2020-01-01T00:00:00Z", Level: 200}},
+			{{ID: 3, Timestamp: "2020-06-01T00:00:00Z", Level: 300}},
+		},
+		storeErrors: []error{nil, nil, nil},
+	}
+
+	backfill := NewHistoricalBackfill(context.Background(), repo, service, slog.Default(), "2019-01-01T00:00:00Z", WithUntil("2020-01-01T00:00:00Z"))
+	if err := backfill.Run(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if service.callCount != 2 {
+		t.Errorf("Expected the backfill to stop once it reached the end timestamp, got %d calls", service.callCount)
+	}
+	if len(repo.deleted) != 1 || repo.deleted[0] != model.BackfillCheckpoint {
+		t.Errorf("Expected backfill checkpoint to be deleted, got %v", repo.deleted)
+	}
+}
+
+func TestHistoricalBackfill_InvalidUntil(t *testing.T) {
+	service := &MockPollerService{}
+
+	backfill := NewHistoricalBackfill(context.Background(), &MockPollerRepository{}, service, slog.Default(), "", WithUntil("2020"))
+	if err := backfill.Run(); err == nil {
+		t.Fatal("Expected error, got nil")
+	}
+	if service.callCount != 0 {
+		t.Errorf("Expected no calls to StoreDelegations, got %d", service.callCount)
+	}
+}
+
+func TestHistoricalBackfill_Resume(t *testing.T) {
+	repo := &MockPollerRepository{
+		checkpoint: model.SyncCheckpoint{
+			Name:      model.BackfillCheckpoint,
+			LastID:    2,
+			Level:     150,
+			Timestamp: "2018-07-01T01:00:00Z",
+		},
+	}
+	service := &MockPollerService{
+		storeResults: [][]model.Delegation{{}},
+		storeErrors:  []error{nil},
+	}
+
+	backfill := NewHistoricalBackfill(context.Background(), repo, service, slog.Default(), "")
+	if err := backfill.Run(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if service.afterIDs[0] != 2 {
+		t.Errorf("Expected backfill to resume after ID 2, got %d", service.afterIDs[0])
+	}
+}
+
+func TestHistoricalBackfill_ResumeKeepsFromLevel(t *testing.T) {
+	repo := &MockPollerRepository{
+		checkpoint: model.SyncCheckpoint{Name: model.BackfillCheckpoint, LastID: 2, Level: 5000100, FromLevel: 5000000},
+	}
+	service := &MockPollerService{
+		storeResults: [][]model.Delegation{{}},
+		storeErrors:  []error{nil},
+	}
+
+	// rerun without the level it was started from
+	backfill := NewHistoricalBackfill(context.Background(), repo, service, slog.Default(), "")
+	if err := backfill.Run(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if service.afterIDs[0] != 2 || service.froms[0].Level != 5000000 {
+		t.Errorf("Expected backfill to resume after ID 2 from level 5000000, got %d and %+v", service.afterIDs[0], service.froms[0])
+	}
+}
+
+func TestHistoricalBackfill_ErrorKeepsCheckpoint(t *testing.T) {
+	repo := &MockPollerRepository{checkpointErr: errors.New("record not found")}
+	service := &MockPollerService{
+		storeResults: [][]model.Delegation{nil},
+		storeErrors:  []error{errors.New("API error")},
+	}
+
+	backfill := NewHistoricalBackfill(context.Background(), repo, service, slog.Default(), "")
+	if err := backfill.Run(); err == nil {
+		t.Fatal("Expected error, got nil")
+	}
+
+	if len(repo.deleted) != 0 {
+		t.Errorf("Expected checkpoint to be kept for resuming, got %v deleted", repo.deleted)
+	}
+}
+
+func TestHistoricalBackfill_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	repo := &MockPollerRepository{checkpointErr: errors.New("record not found")}
+	service := &MockPollerService{}
+
+	backfill := NewHistoricalBackfill(ctx, repo, service, slog.Default(), "")
+	if err := backfill.Run(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	if service.callCount != 0 {
+		t.Errorf("Expected no calls to StoreDelegations, got %d", service.callCount)
+	}
+}
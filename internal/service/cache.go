@@ -0,0 +1,98 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultCacheTTL is how long aggregates are cached when the service does not store delegations
+// itself, e.g. serving a database written by another process: as long as the Poller waits between
+// ticks.
+const DefaultCacheTTL = DefaultPollInterval
+
+// maxCacheEntries bounds the aggregates cached at once: every distinct query is a key, and clients
+// choose the queries.
+const maxCacheEntries = 1024
+
+// aggregateCache keeps the results of aggregate queries, baker rankings and statistics, which scan
+// many delegations, between Poller ticks. Entries expire after the TTL and are all dropped once the
+// service stores or rolls back delegations. Once full, the entry expiring first makes room.
+type aggregateCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[any]cacheEntry
+	now     func() time.Time
+	// generation counts invalidations, so that a value loaded before one is not cached after it
+	generation uint64
+}
+
+type cacheEntry struct {
+	value   any
+	expires time.Time
+}
+
+func newAggregateCache(ttl time.Duration) *aggregateCache {
+	return &aggregateCache{ttl: ttl, entries: make(map[any]cacheEntry), now: time.Now}
+}
+
+// cached returns the value cached under key, or loads and caches it. Errors are not cached.
+func cached[T any](c *aggregateCache, key any, load func() (T, error)) (T, error) {
+	if c.ttl <= 0 {
+		return load()
+	}
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	if ok && !c.now().Before(entry.expires) {
+		delete(c.entries, key)
+		ok = false
+	}
+	generation := c.generation
+	c.mu.Unlock()
+	if ok {
+		return entry.value.(T), nil
+	}
+
+	value, err := load()
+	if err != nil {
+		return value, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation == generation {
+		c.store(key, value)
+	}
+	return value, nil
+}
+
+// store caches the value under key, dropping the expired entries first when the cache is full,
+// then the entry expiring first. Callers hold mu.
+func (c *aggregateCache) store(key any, value any) {
+	now := c.now()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= maxCacheEntries {
+		for k, entry := range c.entries {
+			if !now.Before(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= maxCacheEntries {
+		var oldest any
+		var expires time.Time
+		for k, entry := range c.entries {
+			if oldest == nil || entry.expires.Before(expires) {
+				oldest, expires = k, entry.expires
+			}
+		}
+		delete(c.entries, oldest)
+	}
+	c.entries[key] = cacheEntry{value: value, expires: now.Add(c.ttl)}
+}
+
+func (c *aggregateCache) invalidate() {
+	c.mu.Lock()
+	clear(c.entries)
+	c.generation++
+	c.mu.Unlock()
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"tezos-delegation-service/internal/model"
+	"tezos-delegation-service/internal/transport"
+	"tezos-delegation-service/mocks"
+)
+
+func TestGetBakers_CachedBetweenTicks(t *testing.T) {
+	repo := &mocks.MockDelegationRepository{Bakers: []model.BakerStats{{Baker: "tz1baker", Delegators: 2, Amount: 300}}}
+	service := NewXtzFetcherService(repo, &mocks.MockTzktClient{}).(*XtzFetcherService)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	service.cache.now = func() time.Time { return now }
+	ctx := context.Background()
+	query := model.BakerQuery{Sort: model.RankByDelegators, Limit: 10}
+
+	for range 3 {
+		bakers, err := service.GetBakers(ctx, query)
+		if err != nil || len(bakers) != 1 {
+			t.Fatalf("Expected the ranking, got %v, %v", bakers, err)
+		}
+	}
+	if repo.BakerQueries != 1 {
+		t.Errorf("Expected the ranking to be aggregated once, got %d", repo.BakerQueries)
+	}
+
+	// other queries are cached apart
+	service.GetBakers(ctx, model.BakerQuery{Sort: model.RankByAmount, Limit: 10})
+	service.GetBaker(ctx, "tz1baker")
+	if repo.BakerQueries != 3 {
+		t.Errorf("Expected each query to be aggregated, got %d", repo.BakerQueries)
+	}
+
+	// storing delegations drops the cache, an empty page does not
+	service.StoreStreamed(ctx, model.HeadCheckpoint, nil)
+	service.GetBakers(ctx, query)
+	if repo.BakerQueries != 3 {
+		t.Errorf("Expected an empty page to keep the cache, got %d queries", repo.BakerQueries)
+	}
+	service.StoreStreamed(ctx, model.HeadCheckpoint, []transport.DelegationResponse{
+		{ID: 7, Timestamp: "2024-01-01T00:00:00Z", Level: 1001, Status: model.StatusApplied},
+	})
+	service.GetBakers(ctx, query)
+	if repo.BakerQueries != 4 {
+		t.Errorf("Expected stored delegations to drop the cache, got %d queries", repo.BakerQueries)
+	}
+
+	// so does the poll interval elapsing, for delegations stored by another process
+	now = now.Add(DefaultCacheTTL)
+	service.GetBakers(ctx, query)
+	if repo.BakerQueries != 5 {
+		t.Errorf("Expected the cache to expire, got %d queries", repo.BakerQueries)
+	}
+}
+
+func TestGetBakers_NotCached(t *testing.T) {
+	repo := &mocks.MockDelegationRepository{Err: errors.New("database is locked")}
+	service := NewXtzFetcherService(repo, &mocks.MockTzktClient{})
+	ctx := context.Background()
+
+	// errors are not cached
+	for range 2 {
+		if _, err := service.GetBakers(ctx, model.BakerQuery{}); err == nil {
+			t.Fatal("Expected the error")
+		}
+	}
+	if repo.BakerQueries != 2 {
+		t.Errorf("Expected every failed query to be retried, got %d", repo.BakerQueries)
+	}
+
+	// nor anything with a zero TTL
+	repo = &mocks.MockDelegationRepository{}
+	service = NewXtzFetcherService(repo, &mocks.MockTzktClient{}, WithCacheTTL(0))
+	service.GetBaker(ctx, "tz1baker")
+	service.GetBaker(ctx, "tz1baker")
+	if repo.BakerQueries != 2 {
+		t.Errorf("Expected the cache to be disabled, got %d queries", repo.BakerQueries)
+	}
+}
+
+func TestAggregateCache_Bounded(t *testing.T) {
+	cache := newAggregateCache(time.Minute)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+	load := func() (int, error) { return 1, nil }
+
+	for i := range maxCacheEntries {
+		cached(cache, i, load)
+		now = now.Add(time.Millisecond)
+	}
+	cached(cache, "new", load)
+	if len(cache.entries) != maxCacheEntries {
+		t.Errorf("Expected at most %d entries, got %d", maxCacheEntries, len(cache.entries))
+	}
+	if _, ok := cache.entries[0]; ok {
+		t.Error("Expected the first entry, expiring first, to make room")
+	}
+
+	// expired entries are dropped when looked up, and all of them once the cache is full
+	now = now.Add(time.Minute)
+	cached(cache, 1, load)
+	if _, ok := cache.entries[1]; !ok {
+		t.Error("Expected the entry to be loaded again")
+	}
+	cached(cache, "newer", load)
+	if len(cache.entries) != 2 {
+		t.Errorf("Expected the expired entries to be dropped, got %d entries", len(cache.entries))
+	}
+}
+
+func TestAggregateCache_InvalidatedWhileLoading(t *testing.T) {
+	cache := newAggregateCache(time.Minute)
+	loads := 0
+	load := func() (int, error) {
+		loads++
+		if loads == 1 {
+			// delegations are stored while the first aggregate is computed
+			cache.invalidate()
+		}
+		return loads, nil
+	}
+
+	cached(cache, "bakers", load)
+	if value, _ := cached(cache, "bakers", load); value != 2 {
+		t.Errorf("Expected the value loaded before the invalidation not to be cached, got %d", value)
+	}
+	if value, _ := cached(cache, "bakers", load); value != 2 {
+		t.Errorf("Expected the value loaded after the invalidation to be cached, got %d", value)
+	}
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"context"
+	"errors"
+
+	"tezos-delegation-service/internal/model"
+	"tezos-delegation-service/internal/repository"
+	"tezos-delegation-service/internal/transport"
+)
+
+// maxLevelDelegations bounds the delegations of a single level read to resolve a cursor, far above
+// what a block holds.
+const maxLevelDelegations = 10000
+
+// CheckpointCursors resolves the cursors the sync checkpoints were left at, so that a MultiSource
+// restarted after failing over to a source of another ID space resumes them from their level.
+func CheckpointCursors(repo repository.DelegationRepository) transport.CursorResolver {
+	return func(ctx context.Context, afterID int) (transport.Cursor, bool, error) {
+		for _, name := range []string{model.HeadCheckpoint, model.BackfillCheckpoint} {
+			checkpoint, err := repo.GetCheckpoint(ctx, name)
+			if errors.Is(err, repository.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return transport.Cursor{}, false, err
+			}
+			if checkpoint.LastID != afterID {
+				continue
+			}
+
+			stored, err := repo.GetDelegations(ctx, model.DelegationQuery{
+				MinLevel: checkpoint.Level,
+				MaxLevel: checkpoint.Level,
+				Limit:    maxLevelDelegations,
+			})
+			if err != nil {
+				return transport.Cursor{}, false, err
+			}
+			cursor := transport.Cursor{Level: checkpoint.Level, Read: make(map[transport.OperationKey]bool, len(stored))}
+			for _, d := range stored {
+				cursor.Read[transport.OperationKey{Hash: d.Hash, Sender: d.Delegator}] = true
+			}
+			return cursor, true, nil
+		}
+		return transport.Cursor{}, false, nil
+	}
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"tezos-delegation-service/internal/model"
+	"tezos-delegation-service/internal/transport"
+	"tezos-delegation-service/mocks"
+)
+
+func TestCheckpointCursors(t *testing.T) {
+	repo := &mocks.MockDelegationRepository{
+		Checkpoint: model.SyncCheckpoint{Name: model.HeadCheckpoint, LastID: 42, Level: 100},
+		Delegations: []model.Delegation{
+			{ID: 41, Level: 100, Hash: "ooA", Delegator: "tz1a"},
+			{ID: 42, Level: 100, Hash: "ooB", Delegator: "tz1b"},
+		},
+	}
+	resolve := CheckpointCursors(repo)
+
+	cursor, ok, err := resolve(context.Background(), 42)
+	if err != nil || !ok {
+		t.Fatalf("Expected the checkpoint cursor to be resolved, got %v, %v", ok, err)
+	}
+	expected := transport.Cursor{Level: 100, Read: map[transport.OperationKey]bool{
+		{Hash: "ooA", Sender: "tz1a"}: true,
+		{Hash: "ooB", Sender: "tz1b"}: true,
+	}}
+	if !reflect.DeepEqual(cursor, expected) {
+		t.Errorf("Expected cursor %+v, got %+v", expected, cursor)
+	}
+
+	if _, ok, err := resolve(context.Background(), 7); ok || err != nil {
+		t.Errorf("Expected a cursor no checkpoint is at to be unknown, got %v, %v", ok, err)
+	}
+}
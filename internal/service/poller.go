@@ -2,104 +2,344 @@ package service
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"log/slog"
+	"tezos-delegation-service/internal/model"
 	"tezos-delegation-service/internal/repository"
+	"tezos-delegation-service/internal/transport"
 	"time"
 )
 
+// defaultCallTimeout bounds a single fetch-and-store round trip, retries included.
+const defaultCallTimeout = 2 * time.Minute
+
+// DefaultPollInterval is how often the Poller asks for new delegations.
+const DefaultPollInterval = 1 * time.Minute
+
 type Poller struct {
-	ctx            context.Context
-	cancel         context.CancelFunc
-	repo           repository.DelegationRepository
-	client         XtzService
-	lastFetched    string
-	offset         int
-	started        bool
-	logger         *slog.Logger
-	tickerInterval time.Duration
-}
-
-func NewPoller(ctx context.Context, repo repository.DelegationRepository, fetcher XtzService, logger *slog.Logger) *Poller {
+	ctx               context.Context
+	cancel            context.CancelFunc
+	repo              repository.DelegationRepository
+	client            XtzService
+	lastID            int
+	lastFetched       string
+	started           bool
+	logger            *slog.Logger
+	tickerInterval    time.Duration
+	callTimeout       time.Duration
+	confirmationDepth int
+	stream            transport.DelegationStream
+	reconnectInterval time.Duration
+	err               error
+}
+
+type PollerOption func(*Poller)
+
+// WithConfirmationDepth sets how many levels below the head checkpoint are checked for chain
+// reorganisations before their delegations are considered final.
+func WithConfirmationDepth(depth int) PollerOption {
+	return func(p *Poller) {
+		p.confirmationDepth = depth
+	}
+}
+
+// WithInterval sets how often the Poller asks for new delegations.
+func WithInterval(interval time.Duration) PollerOption {
+	return func(p *Poller) {
+		p.tickerInterval = interval
+	}
+}
+
+// WithStream makes the Poller store delegations as TzKT streams them instead of polling every
+// minute. Polling takes over while the stream is down.
+func WithStream(stream transport.DelegationStream) PollerOption {
+	return func(p *Poller) {
+		p.stream = stream
+	}
+}
+
+func NewPoller(ctx context.Context, repo repository.DelegationRepository, fetcher XtzService, logger *slog.Logger, opts ...PollerOption) *Poller {
 	ctx, cancel := context.WithCancel(ctx)
-	return &Poller{
-		ctx:            ctx,
-		cancel:         cancel,
-		repo:           repo,
-		client:         fetcher,
-		lastFetched:    "",
-		offset:         0,
-		logger:         logger,
-		tickerInterval: 1 * time.Minute,
+	p := &Poller{
+		ctx:               ctx,
+		cancel:            cancel,
+		repo:              repo,
+		client:            fetcher,
+		lastID:            0,
+		lastFetched:       "",
+		logger:            logger,
+		tickerInterval:    DefaultPollInterval,
+		callTimeout:       defaultCallTimeout,
+		confirmationDepth: DefaultConfirmationDepth,
+		reconnectInterval: 1 * time.Minute,
+	}
+	for _, opt := range opts {
+		opt(p)
 	}
+	return p
 }
 
 func (p *Poller) Stop() {
 	p.cancel()
 }
 
-func (p *Poller) backfill() {
+// backfill catches up with the head before polling starts. It returns the error that stopped it,
+// or nil when the Poller was stopped meanwhile.
+func (p *Poller) backfill() error {
 	p.logger.Info("Starting backfill...")
 
-	// get the latest stored delegation
-	latest, err := p.repo.GetLatestDelegation(time.Now().Year())
-	fmt.Println("Latest delegation:", latest)
-	if err == nil && latest.Timestamp != "" {
-		p.lastFetched = latest.Timestamp
+	if err := p.SyncOnce(); err != nil {
+		if p.ctx.Err() != nil {
+			p.logger.Info("Backfill stopped")
+			return nil
+		}
+		// the transport already retried what it could, polling would only fail the same way
+		p.logger.Error("Failed to fetch delegations", "error", err)
+		return err
 	}
+	return nil
+}
+
+// SyncOnce stores every delegation published since the head checkpoint, up to the head, and
+// returns.
+func (p *Poller) SyncOnce() error {
+	p.restoreCheckpoint()
+	return p.catchUp()
+}
+
+// catchUp stores every page after the cursor, up to the head.
+func (p *Poller) catchUp() error {
+	p.reconcile()
 
 	for {
-		results, err := p.client.StoreDelegations(0, p.lastFetched)
+		results, reached, err := p.storePage()
 		if err != nil {
-			p.logger.Error("Failed to fetch delegations", "error", err)
-			return
+			return err
 		}
-		if len(results) == 0 {
+		if reached.LastID == 0 {
 			p.logger.Info("No more delegations to fetch, stopping backfill")
-			return
+			return nil
 		}
 
-		p.logger.Info("Fetched delegations", "count", len(results), "offset", p.offset)
-		p.offset += len(results)
-		p.lastFetched = (results)[len(results)-1].Timestamp
-		p.logger.Info("Updated last fetched level", "timestamp", p.lastFetched)
+		p.logger.Info("Fetched delegations", "count", len(results), "after_id", p.lastID, "level", reached.Level)
+		p.advance(reached.LastID, reached.Timestamp)
 	}
 }
 
+// restoreCheckpoint resumes from the persisted head checkpoint. Databases created before
+// checkpoints existed fall back to the stored delegation with the highest ID, whatever its year.
+func (p *Poller) restoreCheckpoint() {
+	checkpoint, err := p.repo.GetCheckpoint(p.ctx, model.HeadCheckpoint)
+	if err == nil && checkpoint.LastID > 0 {
+		p.lastID = checkpoint.LastID
+		p.lastFetched = checkpoint.Timestamp
+		p.logger.Info("Resuming from checkpoint", "id", checkpoint.LastID, "level", checkpoint.Level, "timestamp", checkpoint.Timestamp)
+		return
+	}
+
+	latest, err := p.repo.GetLastDelegation(p.ctx)
+	if err == nil && latest.ID > 0 {
+		p.lastID = latest.ID
+		p.lastFetched = latest.Timestamp
+		p.logger.Info("No checkpoint found, resuming from latest delegation", "id", latest.ID, "timestamp", latest.Timestamp)
+	}
+}
+
+// reconcile rolls back the delegations of blocks replaced by a chain reorganisation and rewinds
+// the cursor, so that the next page refetches from the fork.
+func (p *Poller) reconcile() {
+	ctx, cancel := context.WithTimeout(p.ctx, p.callTimeout)
+	defer cancel()
+
+	fork, err := p.client.Reconcile(ctx, model.HeadCheckpoint, p.confirmationDepth)
+	if err != nil {
+		p.logger.Warn("Failed to check for chain reorganisations", "error", err)
+		return
+	}
+	if fork == 0 {
+		return
+	}
+
+	p.logger.Warn("Chain reorganisation detected, refetching from fork", "level", fork)
+	p.lastID = 0
+	p.lastFetched = ""
+	p.restoreCheckpoint()
+}
+
+// storePage fetches and stores the page after the cursor, returning the checkpoint it reached.
+// Stopping the Poller aborts the call.
+func (p *Poller) storePage() ([]model.Delegation, model.SyncCheckpoint, error) {
+	ctx, cancel := context.WithTimeout(p.ctx, p.callTimeout)
+	defer cancel()
+
+	return p.client.StoreDelegations(ctx, model.HeadCheckpoint, p.lastID, transport.Start{})
+}
+
+// advance moves the cursor to lastID, the highest ID of the page stored.
+func (p *Poller) advance(lastID int, timestamp string) {
+	p.lastID = lastID
+	p.lastFetched = timestamp
+	p.logger.Info("Updated last fetched delegation", "id", p.lastID, "timestamp", p.lastFetched)
+}
+
 func (p *Poller) Start() {
 	if p.started {
 		return
 	}
 	p.started = true
-	go func() {
-		p.backfill()
-
-		timer := time.NewTicker(p.tickerInterval)
-		defer timer.Stop()
-
-		for {
-			select {
-			case <-p.ctx.Done():
-				p.logger.Info("Polling stopped")
-				return
-			case <-timer.C:
-				p.logger.Info("Polling for new delegations...")
-				results, err := p.client.StoreDelegations(p.offset, p.lastFetched)
-				if err != nil {
-					p.logger.Error("Failed to fetch delegations", "error", err)
-					p.Stop()
-					return
-				}
-				if len(results) == 0 {
-					p.logger.Info("No new delegations found, continuing to poll")
-					continue
-				}
-				p.logger.Info("Fetched new delegations", "count", len(results))
-				p.offset += len(results)
-				p.lastFetched = (results)[len(results)-1].Timestamp
-				p.logger.Info("Updated last fetched level", "timestamp", p.lastFetched)
+	go p.run()
+}
+
+// Run stores delegations like Start but blocks until the Poller stops. It returns the error that
+// stopped it, the initial backfill included, or nil when it was stopped through Stop or its
+// context.
+func (p *Poller) Run() error {
+	if p.started {
+		return errors.New("poller already started")
+	}
+	p.started = true
+	p.run()
+	return p.err
+}
+
+func (p *Poller) run() {
+	if err := p.backfill(); err != nil {
+		p.err = err
+		p.Stop()
+		return
+	}
+
+	if p.stream == nil {
+		p.poll(nil)
+		return
+	}
+	p.follow()
+}
+
+// poll stores new delegations on every tick until the Poller stops, or until the until channel
+// fires. It reports whether the Poller is still running.
+func (p *Poller) poll(until <-chan time.Time) bool {
+	timer := time.NewTicker(p.tickerInterval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-p.ctx.Done():
+			p.logger.Info("Polling stopped")
+			return false
+		case <-until:
+			return true
+		case <-timer.C:
+			if !p.tick() {
+				return false
 			}
 		}
+	}
+}
+
+// tick stores the delegations published since the last one. It returns false once the Poller
+// stopped.
+func (p *Poller) tick() bool {
+	p.logger.Info("Polling for new delegations...")
+	p.reconcile()
+	results, reached, err := p.storePage()
+	if err != nil {
+		if p.ctx.Err() != nil {
+			p.logger.Info("Polling stopped")
+			return false
+		}
+		// TzKT blips are retried by the transport; keep polling once they exhaust
+		if transport.IsRetryable(err) {
+			p.logger.Warn("Failed to fetch delegations, retrying on next tick", "error", err)
+			return true
+		}
+		p.logger.Error("Failed to fetch delegations", "error", err)
+		p.err = err
+		p.Stop()
+		return false
+	}
+	if reached.LastID == 0 {
+		p.logger.Info("No new delegations found, continuing to poll")
+		return true
+	}
+	p.logger.Info("Fetched new delegations", "count", len(results), "level", reached.Level)
+	p.advance(reached.LastID, reached.Timestamp)
+	return true
+}
+
+// follow stores delegations as TzKT streams them. While the stream is down the Poller falls back
+// to polling and tries to reconnect every reconnectInterval.
+func (p *Poller) follow() {
+	for {
+		err := p.subscribe()
+		if p.ctx.Err() != nil {
+			p.logger.Info("Streaming stopped")
+			return
+		}
+
+		p.logger.Warn("Delegation stream disconnected, falling back to polling", "error", err)
+		if !p.poll(time.After(p.reconnectInterval)) {
+			return
+		}
+		p.logger.Info("Reconnecting to the delegation stream")
+	}
+}
+
+// subscribe applies stream events until the stream drops or an event cannot be stored.
+func (p *Poller) subscribe() error {
+	ctx, cancel := context.WithCancel(p.ctx)
+	defer cancel()
+
+	events := make(chan transport.StreamEvent, 64)
+	done := make(chan error, 1)
+	go func() {
+		done <- p.stream.Subscribe(ctx, events)
 	}()
 
+	for {
+		select {
+		case err := <-done:
+			return err
+		case event := <-events:
+			if err := p.apply(event); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (p *Poller) apply(event transport.StreamEvent) error {
+	switch event.Type {
+	case transport.StreamState:
+		// the hub does not replay what was published before we subscribed, fetch that gap first
+		p.logger.Info("Subscribed to the delegation stream", "level", event.State)
+		return p.catchUp()
+	case transport.StreamReorg:
+		p.logger.Warn("Chain rolled back by TzKT", "level", event.State)
+		p.reconcile()
+		return nil
+	}
+
+	// the catch-up may already have stored the first events
+	var fresh []transport.DelegationResponse
+	for _, result := range event.Data {
+		if result.ID > p.lastID {
+			fresh = append(fresh, result)
+		}
+	}
+	if len(fresh) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(p.ctx, p.callTimeout)
+	defer cancel()
+
+	results, err := p.client.StoreStreamed(ctx, model.HeadCheckpoint, fresh)
+	if err != nil {
+		return err
+	}
+	p.logger.Info("Stored streamed delegations", "count", len(results), "level", event.State)
+	last := results[len(results)-1]
+	p.advance(last.ID, last.Timestamp)
+	return nil
 }
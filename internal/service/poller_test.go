@@ -4,69 +4,228 @@ import (
 	"context"
 	"errors"
 	"log/slog"
+	"reflect"
 	"sync"
 	"testing"
 	"time"
 
 	"tezos-delegation-service/internal/model"
 	"tezos-delegation-service/internal/repository"
+	"tezos-delegation-service/internal/transport"
+	"tezos-delegation-service/internal/transport/tzkttest"
 )
 
 type MockPollerRepository struct {
-	delegations []model.Delegation
-	latest      model.Delegation
-	err         error
-	saveErr     error
+	delegations   []model.Delegation
+	latest        model.Delegation
+	checkpoint    model.SyncCheckpoint
+	checkpointErr error
+	err           error
+	saveErr       error
+	deleted       []string
 }
 
-func (m *MockPollerRepository) GetDelegations(year int, offset int) ([]model.Delegation, error) {
+func (m *MockPollerRepository) GetDelegations(ctx context.Context, query model.DelegationQuery) ([]model.Delegation, error) {
 	if m.err != nil {
 		return nil, m.err
 	}
 	return m.delegations, nil
 }
 
-func (m *MockPollerRepository) GetLatestDelegation(year int) (model.Delegation, error) {
+func (m *MockPollerRepository) GetDelegatorDelegations(ctx context.Context, delegator string, query model.DelegationQuery) ([]model.Delegation, error) {
+	return m.delegations, m.err
+}
+
+func (m *MockPollerRepository) GetCurrentDelegation(ctx context.Context, delegator string) (model.CurrentDelegation, error) {
+	return model.CurrentDelegation{}, m.err
+}
+
+func (m *MockPollerRepository) GetBakers(ctx context.Context, query model.BakerQuery) ([]model.BakerStats, error) {
+	return nil, m.err
+}
+
+func (m *MockPollerRepository) GetBaker(ctx context.Context, baker string) (model.BakerStats, error) {
+	return model.BakerStats{}, m.err
+}
+
+func (m *MockPollerRepository) GetBakerDelegators(ctx context.Context, baker string, limit int, offset int) ([]model.CurrentDelegation, error) {
+	return nil, m.err
+}
+
+func (m *MockPollerRepository) GetDelegationStats(ctx context.Context, query model.StatsQuery) ([]model.DelegationStats, error) {
+	return nil, m.err
+}
+
+func (m *MockPollerRepository) GetProtocols(ctx context.Context) (model.Protocols, error) {
+	return nil, m.err
+}
+
+func (m *MockPollerRepository) SaveProtocols(ctx context.Context, protocols model.Protocols) error {
+	return m.saveErr
+}
+
+func (m *MockPollerRepository) CountDelegations(ctx context.Context, query model.DelegationQuery) (int, error) {
+	return len(m.delegations), m.err
+}
+
+func (m *MockPollerRepository) GetLatestDelegation(ctx context.Context, year int) (model.Delegation, error) {
 	if m.err != nil {
 		return model.Delegation{}, m.err
 	}
 	return m.latest, nil
 }
 
-func (m *MockPollerRepository) SaveBatch(delegations []model.Delegation) error {
+func (m *MockPollerRepository) GetLastDelegation(ctx context.Context) (model.Delegation, error) {
+	if m.err != nil {
+		return model.Delegation{}, m.err
+	}
+	return m.latest, nil
+}
+
+func (m *MockPollerRepository) SaveBatch(ctx context.Context, delegations []model.Delegation) error {
+	return m.saveErr
+}
+
+func (m *MockPollerRepository) SaveBatchWithCheckpoint(ctx context.Context, delegations []model.Delegation, checkpoint model.SyncCheckpoint) error {
+	return m.saveErr
+}
+
+func (m *MockPollerRepository) GetCheckpoint(ctx context.Context, name string) (model.SyncCheckpoint, error) {
+	return m.checkpoint, m.checkpointErr
+}
+
+func (m *MockPollerRepository) DeleteCheckpoint(ctx context.Context, name string) error {
+	m.deleted = append(m.deleted, name)
+	return nil
+}
+
+func (m *MockPollerRepository) GetBlockHashes(ctx context.Context, fromLevel, toLevel int) (map[int]string, error) {
+	return nil, m.err
+}
+
+func (m *MockPollerRepository) RollbackFrom(ctx context.Context, level int, checkpoint string) error {
 	return m.saveErr
 }
 
 type MockPollerService struct {
 	storeResults [][]model.Delegation
 	storeErrors  []error
-	callCount    int
-	mu           sync.Mutex
+	// scanned holds the checkpoints reached by pages without delegations, by call
+	scanned    map[int]model.SyncCheckpoint
+	afterIDs   []int
+	froms      []transport.Start
+	headLevel  int
+	forks      []int
+	reconciles int
+	streamed   [][]transport.DelegationResponse
+	callCount  int
+	mu         sync.Mutex
 }
 
-func (m *MockPollerService) GetDelegations(year int, offset int) ([]model.Delegation, error) {
+func (m *MockPollerService) GetDelegations(ctx context.Context, query model.DelegationQuery) ([]model.Delegation, error) {
 	return nil, nil
 }
 
-func (m *MockPollerService) StoreDelegations(offset int, startFrom string) ([]model.Delegation, error) {
+func (m *MockPollerService) GetDelegatorDelegations(ctx context.Context, delegator string, query model.DelegationQuery) ([]model.Delegation, error) {
+	return nil, nil
+}
+
+func (m *MockPollerService) GetCurrentDelegation(ctx context.Context, delegator string) (model.CurrentDelegation, error) {
+	return model.CurrentDelegation{}, nil
+}
+
+func (m *MockPollerService) GetBakers(ctx context.Context, query model.BakerQuery) ([]model.BakerStats, error) {
+	return nil, nil
+}
+
+func (m *MockPollerService) GetBaker(ctx context.Context, baker string) (model.BakerStats, error) {
+	return model.BakerStats{}, nil
+}
+
+func (m *MockPollerService) GetBakerDelegators(ctx context.Context, baker string, limit int, offset int) ([]model.CurrentDelegation, error) {
+	return nil, nil
+}
+
+func (m *MockPollerService) GetDelegationStats(ctx context.Context, query model.StatsQuery) ([]model.DelegationStats, error) {
+	return nil, nil
+}
+
+func (m *MockPollerService) CountDelegations(ctx context.Context, query model.DelegationQuery) (int, error) {
+	return 0, nil
+}
+
+func (m *MockPollerService) StoreDelegations(ctx context.Context, checkpoint string, afterID int, from transport.Start) ([]model.Delegation, model.SyncCheckpoint, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	m.afterIDs = append(m.afterIDs, afterID)
+	m.froms = append(m.froms, from)
+
 	if m.callCount >= len(m.storeResults) {
-		return []model.Delegation{}, nil
+		return []model.Delegation{}, model.SyncCheckpoint{}, nil
 	}
 
 	result := m.storeResults[m.callCount]
 	err := m.storeErrors[m.callCount]
+	reached := m.scanned[m.callCount]
 	m.callCount++
 
-	return result, err
+	if len(result) > 0 {
+		last := result[len(result)-1]
+		reached = model.SyncCheckpoint{LastID: last.ID, Level: last.Level, Timestamp: last.Timestamp}
+	}
+	return result, reached, err
+}
+
+func (m *MockPollerService) StoreStreamed(ctx context.Context, checkpoint string, results []transport.DelegationResponse) ([]model.Delegation, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.streamed = append(m.streamed, results)
+
+	var delegations []model.Delegation
+	for _, result := range results {
+		delegations = append(delegations, model.Delegation{ID: result.ID, Level: result.Level, Timestamp: result.Timestamp})
+	}
+	return delegations, nil
 }
 
-func (m *MockPollerService) GetLatestDelegation() (model.Delegation, error) {
+func (m *MockPollerService) GetLatestDelegation(ctx context.Context) (model.Delegation, error) {
 	return model.Delegation{}, nil
 }
 
+func (m *MockPollerService) GetHeadLevel(ctx context.Context) (int, error) {
+	return m.headLevel, nil
+}
+
+func (m *MockPollerService) Reconcile(ctx context.Context, checkpoint string, depth int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.reconciles >= len(m.forks) {
+		return 0, nil
+	}
+	fork := m.forks[m.reconciles]
+	m.reconciles++
+	return fork, nil
+}
+
+func (m *MockPollerService) FindFork(ctx context.Context, checkpoint string, depth int) (int, error) {
+	return 0, nil
+}
+
+// BlockingPollerService blocks every StoreDelegations call until its context is done.
+type BlockingPollerService struct {
+	MockPollerService
+	started chan struct{}
+}
+
+func (m *BlockingPollerService) StoreDelegations(ctx context.Context, checkpoint string, afterID int, from transport.Start) ([]model.Delegation, model.SyncCheckpoint, error) {
+	close(m.started)
+	<-ctx.Done()
+	return nil, model.SyncCheckpoint{}, ctx.Err()
+}
+
 func TestNewPoller(t *testing.T) {
 	ctx := context.Background()
 	repo := &MockPollerRepository{}
@@ -91,8 +250,8 @@ func TestNewPoller(t *testing.T) {
 		t.Error("Expected logger to be set correctly")
 	}
 
-	if poller.offset != 0 {
-		t.Error("Expected initial offset to be 0")
+	if poller.lastID != 0 {
+		t.Error("Expected initial lastID to be 0")
 	}
 
 	if poller.lastFetched != "" {
@@ -180,7 +339,7 @@ func TestPoller_Backfill(t *testing.T) {
 		name           string
 		storeResults   [][]model.Delegation
 		storeErrors    []error
-		expectedOffset int
+		expectedLastID int
 		expectedLast   string
 		shouldStop     bool
 	}{
@@ -197,7 +356,7 @@ func TestPoller_Backfill(t *testing.T) {
 				{}, // stop backfill
 			},
 			storeErrors:    []error{nil, nil, nil},
-			expectedOffset: 3,
+			expectedLastID: 3,
 			expectedLast:   "2023-01-01T02:00:00Z",
 			shouldStop:     true,
 		},
@@ -209,7 +368,7 @@ func TestPoller_Backfill(t *testing.T) {
 				},
 			},
 			storeErrors:    []error{errors.New("API error")},
-			expectedOffset: 0,
+			expectedLastID: 0,
 			expectedLast:   "",
 			shouldStop:     true,
 		},
@@ -222,7 +381,7 @@ func TestPoller_Backfill(t *testing.T) {
 				{}, // stop backfill
 			},
 			storeErrors:    []error{nil, nil},
-			expectedOffset: 1,
+			expectedLastID: 1,
 			expectedLast:   "2023-01-01T00:00:00Z",
 			shouldStop:     true,
 		},
@@ -244,8 +403,8 @@ func TestPoller_Backfill(t *testing.T) {
 			go poller.backfill()
 			time.Sleep(200 * time.Millisecond)
 
-			if poller.offset != tt.expectedOffset {
-				t.Errorf("Expected offset %d, got %d", tt.expectedOffset, poller.offset)
+			if poller.lastID != tt.expectedLastID {
+				t.Errorf("Expected lastID %d, got %d", tt.expectedLastID, poller.lastID)
 			}
 
 			if poller.lastFetched != tt.expectedLast {
@@ -326,6 +485,181 @@ func TestPoller_PollingWithError(t *testing.T) {
 	}
 }
 
+func TestPoller_RunReturnsStoppingError(t *testing.T) {
+	apiErr := errors.New("API error")
+	service := &MockPollerService{
+		storeResults: [][]model.Delegation{
+			{}, // backfill
+			{}, // first poll
+		},
+		storeErrors: []error{nil, apiErr},
+	}
+
+	poller := NewPoller(context.Background(), &MockPollerRepository{}, service, slog.Default())
+	poller.tickerInterval = 50 * time.Millisecond
+
+	if err := poller.Run(); !errors.Is(err, apiErr) {
+		t.Errorf("Expected Run to return %v, got %v", apiErr, err)
+	}
+	if err := poller.Run(); err == nil {
+		t.Error("Expected running a started poller to fail")
+	}
+}
+
+func TestPoller_RunReturnsBackfillError(t *testing.T) {
+	apiErr := errors.New("API error")
+	service := &MockPollerService{
+		storeResults: [][]model.Delegation{nil},
+		storeErrors:  []error{apiErr},
+	}
+
+	poller := NewPoller(context.Background(), &MockPollerRepository{}, service, slog.Default())
+	poller.tickerInterval = 50 * time.Millisecond
+
+	if err := poller.Run(); !errors.Is(err, apiErr) {
+		t.Errorf("Expected Run to return %v, got %v", apiErr, err)
+	}
+	if service.callCount != 1 {
+		t.Errorf("Expected polling not to start after the failed backfill, got %d calls", service.callCount)
+	}
+}
+
+func TestPoller_RunStoppedByContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	service := &MockPollerService{storeResults: [][]model.Delegation{{}}, storeErrors: []error{nil}}
+	poller := NewPoller(ctx, &MockPollerRepository{}, service, slog.Default())
+	poller.tickerInterval = time.Hour
+
+	if err := poller.Run(); err != nil {
+		t.Errorf("Expected no error once the context is done, got %v", err)
+	}
+}
+
+func TestPoller_SyncOnce(t *testing.T) {
+	repo := &MockPollerRepository{checkpoint: model.SyncCheckpoint{Name: model.HeadCheckpoint, LastID: 5}}
+	service := &MockPollerService{
+		storeResults: [][]model.Delegation{
+			{{ID: 6, Timestamp: "2024-01-01T00:00:00Z", Level: 100}},
+			{},
+		},
+		storeErrors: []error{nil, nil},
+	}
+
+	poller := NewPoller(context.Background(), repo, service, slog.Default())
+	if err := poller.SyncOnce(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if !reflect.DeepEqual(service.afterIDs, []int{5, 6}) {
+		t.Errorf("Expected pages after 5 then 6, got %v", service.afterIDs)
+	}
+	if poller.started {
+		t.Error("Expected SyncOnce not to start polling")
+	}
+}
+
+func TestPoller_SyncOncePastEmptyLevels(t *testing.T) {
+	repo := &MockPollerRepository{checkpoint: model.SyncCheckpoint{Name: model.HeadCheckpoint, LastID: 1000000}}
+	service := &MockPollerService{
+		storeResults: [][]model.Delegation{{}, {}},
+		storeErrors:  []error{nil, nil},
+		scanned: map[int]model.SyncCheckpoint{
+			0: {LastID: 10999999, Level: 109, Timestamp: "2024-01-01T00:00:00Z"},
+		},
+	}
+
+	poller := NewPoller(context.Background(), repo, service, slog.Default())
+	if err := poller.SyncOnce(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// a page without delegations is not the head when the source walked levels for it
+	if !reflect.DeepEqual(service.afterIDs, []int{1000000, 10999999}) {
+		t.Errorf("Expected pages after 1000000 then 10999999, got %v", service.afterIDs)
+	}
+	if poller.lastFetched != "2024-01-01T00:00:00Z" {
+		t.Errorf("Expected the cursor to move to the last level walked, got %q", poller.lastFetched)
+	}
+}
+
+func TestPoller_PollingWithTransientError(t *testing.T) {
+	ctx := context.Background()
+	repo := &MockPollerRepository{}
+	service := &MockPollerService{
+		storeResults: [][]model.Delegation{
+			{}, // backfill
+			{}, // first poll fails after the transport exhausted its retries
+			{
+				{ID: 1, Timestamp: "2023-01-01T00:00:00Z", Amount: 1000, Delegator: "addr1", Level: 100, Year: 2023},
+			},
+		},
+		storeErrors: []error{
+			nil,
+			&transport.RetryError{Attempts: 5, Class: transport.ClassServer, Err: &transport.StatusError{StatusCode: 503}},
+			nil,
+		},
+	}
+	logger := slog.Default()
+
+	poller := NewPoller(ctx, repo, service, logger)
+	poller.tickerInterval = 50 * time.Millisecond
+
+	poller.Start()
+	time.Sleep(500 * time.Millisecond)
+	poller.Stop()
+
+	// polling carries on after the transient error
+	if service.callCount != 3 {
+		t.Errorf("Expected 3 calls to StoreDelegations, got %d", service.callCount)
+	}
+
+	if poller.lastID != 1 {
+		t.Errorf("Expected lastID 1, got %d", poller.lastID)
+	}
+}
+
+func TestPoller_StopAbortsInFlightCall(t *testing.T) {
+	ctx := context.Background()
+	repo := &MockPollerRepository{}
+	service := &BlockingPollerService{started: make(chan struct{})}
+	logger := slog.Default()
+
+	poller := NewPoller(ctx, repo, service, logger)
+
+	done := make(chan struct{})
+	go func() {
+		poller.backfill()
+		close(done)
+	}()
+
+	<-service.started
+	poller.Stop()
+
+	select {
+	case <-done:
+		// the in-flight call returned once the poller was stopped
+	case <-time.After(time.Second):
+		t.Fatal("Expected Stop() to abort the in-flight call")
+	}
+}
+
+func TestPoller_CallTimeout(t *testing.T) {
+	ctx := context.Background()
+	repo := &MockPollerRepository{}
+	service := &BlockingPollerService{started: make(chan struct{})}
+	logger := slog.Default()
+
+	poller := NewPoller(ctx, repo, service, logger)
+	poller.callTimeout = 50 * time.Millisecond
+
+	_, _, err := poller.storePage()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
 func TestPoller_ConcurrentAccess(t *testing.T) {
 	ctx := context.Background()
 	repo := &MockPollerRepository{}
@@ -392,7 +726,7 @@ func TestPoller_ContextCancellation(t *testing.T) {
 	}
 }
 
-func TestPoller_OffsetTracking(t *testing.T) {
+func TestPoller_CursorTracking(t *testing.T) {
 	ctx := context.Background()
 	repo := &MockPollerRepository{}
 	service := &MockPollerService{
@@ -412,17 +746,24 @@ func TestPoller_OffsetTracking(t *testing.T) {
 
 	poller := NewPoller(ctx, repo, service, logger)
 
-	// initial offset should be 0
-	if poller.offset != 0 {
-		t.Errorf("Expected initial offset 0, got %d", poller.offset)
+	// initial cursor should be 0
+	if poller.lastID != 0 {
+		t.Errorf("Expected initial lastID 0, got %d", poller.lastID)
 	}
 
 	go poller.backfill()
 	time.Sleep(200 * time.Millisecond)
 
-	// offset should be updated to 3 (2 + 1)
-	if poller.offset != 3 {
-		t.Errorf("Expected offset 3, got %d", poller.offset)
+	// cursor should follow the last stored ID
+	if poller.lastID != 3 {
+		t.Errorf("Expected lastID 3, got %d", poller.lastID)
+	}
+
+	expectedAfterIDs := []int{0, 2, 3}
+	for i, expected := range expectedAfterIDs {
+		if service.afterIDs[i] != expected {
+			t.Errorf("Expected call %d to page after ID %d, got %d", i, expected, service.afterIDs[i])
+		}
 	}
 
 	// last fetched should be updated
@@ -460,20 +801,73 @@ func TestPoller_BackfillWithLatestDelegation(t *testing.T) {
 	go poller.backfill()
 	time.Sleep(200 * time.Millisecond)
 
-	// should have used the latest delegation timestamp as startFrom
+	// should have used the latest delegation ID as the cursor
 	if poller.lastFetched != "2023-01-01T01:00:00Z" {
 		t.Errorf("Expected lastFetched '2023-01-01T01:00:00Z', got %s", poller.lastFetched)
 	}
 
-	if poller.offset != 1 {
-		t.Errorf("Expected offset 1, got %d", poller.offset)
+	if service.afterIDs[0] != 1 {
+		t.Errorf("Expected backfill to page after ID 1, got %d", service.afterIDs[0])
+	}
+
+	if poller.lastID != 2 {
+		t.Errorf("Expected lastID 2, got %d", poller.lastID)
+	}
+}
+
+func TestPoller_BackfillResumesFromCheckpoint(t *testing.T) {
+	ctx := context.Background()
+	repo := &MockPollerRepository{
+		checkpoint: model.SyncCheckpoint{
+			Name:      model.HeadCheckpoint,
+			LastID:    10,
+			Level:     100,
+			Timestamp: "2022-12-31T23:00:00Z",
+		},
+		// the last stored delegation must not take precedence over the checkpoint
+		latest: model.Delegation{ID: 20, Timestamp: "2023-01-01T00:00:00Z"},
+	}
+	service := &MockPollerService{
+		storeResults: [][]model.Delegation{
+			{}, // nothing new since the checkpoint
+		},
+		storeErrors: []error{nil},
+	}
+	logger := slog.Default()
+
+	poller := NewPoller(ctx, repo, service, logger)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- poller.backfill()
+	}()
+	waitFor(t, "the backfill page", func() bool {
+		service.mu.Lock()
+		defer service.mu.Unlock()
+		return len(service.afterIDs) > 0
+	})
+	poller.Stop()
+	if err := <-done; err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	if service.afterIDs[0] != 10 {
+		t.Errorf("Expected backfill to page after ID 10, got %d", service.afterIDs[0])
+	}
+
+	if poller.lastID != 10 {
+		t.Errorf("Expected lastID 10, got %d", poller.lastID)
 	}
 }
 
 func TestPoller_BackfillWithRepositoryError(t *testing.T) {
 	ctx := context.Background()
 	repo := &MockPollerRepository{
-		err: errors.New("database error"),
+		err:           errors.New("database error"),
+		checkpointErr: errors.New("database error"),
 	}
 	service := &MockPollerService{
 		storeResults: [][]model.Delegation{
@@ -491,13 +885,161 @@ func TestPoller_BackfillWithRepositoryError(t *testing.T) {
 	go poller.backfill()
 	time.Sleep(200 * time.Millisecond)
 
-	// should start from empty string when repository error occurs
+	// should start from the beginning when repository error occurs
 	if poller.lastFetched != "2023-01-01T00:00:00Z" {
 		t.Errorf("Expected lastFetched '2023-01-01T00:00:00Z', got %s", poller.lastFetched)
 	}
 
-	if poller.offset != 1 {
-		t.Errorf("Expected offset 1, got %d", poller.offset)
+	if poller.lastID != 1 {
+		t.Errorf("Expected lastID 1, got %d", poller.lastID)
+	}
+}
+
+func TestPoller_RewindsOnReorg(t *testing.T) {
+	ctx := context.Background()
+	repo := &MockPollerRepository{
+		// the checkpoint as rewound by the rollback
+		checkpoint: model.SyncCheckpoint{Name: model.HeadCheckpoint, LastID: 5, Level: 99},
+	}
+	service := &MockPollerService{
+		storeResults: [][]model.Delegation{
+			{
+				{ID: 10, Timestamp: "2023-01-01T00:00:00Z", Level: 101},
+			},
+			{}, // empty result to stop backfill
+		},
+		storeErrors: []error{nil, nil},
+		forks:       []int{0, 101},
+	}
+	logger := slog.Default()
+
+	poller := NewPoller(ctx, repo, service, logger, WithConfirmationDepth(3))
+	poller.tickerInterval = 50 * time.Millisecond
+
+	poller.Start()
+	time.Sleep(120 * time.Millisecond)
+	poller.Stop()
+
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	if poller.confirmationDepth != 3 {
+		t.Errorf("Expected confirmation depth 3, got %d", poller.confirmationDepth)
+	}
+
+	// backfill pages after the checkpoint, then the first tick refetches from the rewound one
+	expectedAfterIDs := []int{5, 10, 5}
+	if len(service.afterIDs) < len(expectedAfterIDs) {
+		t.Fatalf("Expected at least %d calls, got %v", len(expectedAfterIDs), service.afterIDs)
+	}
+	for i, expected := range expectedAfterIDs {
+		if service.afterIDs[i] != expected {
+			t.Errorf("Expected call %d to page after ID %d, got %d", i, expected, service.afterIDs[i])
+		}
+	}
+}
+
+// waitFor polls cond until it holds or a second passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPoller_Streaming(t *testing.T) {
+	ctx := context.Background()
+	repo := &MockPollerRepository{}
+	service := &MockPollerService{}
+	logger := slog.Default()
+
+	hub := tzkttest.NewHub(100)
+	defer hub.Close()
+
+	poller := NewPoller(ctx, repo, service, logger, WithStream(transport.NewTzktStream(hub.URL())))
+	poller.tickerInterval = 20 * time.Millisecond
+	poller.reconnectInterval = 100 * time.Millisecond
+	poller.Start()
+	defer poller.Stop()
+
+	select {
+	case <-hub.Subscribed():
+	case <-time.After(time.Second):
+		t.Fatal("Expected the poller to subscribe")
+	}
+
+	hub.Publish(101, []transport.DelegationResponse{
+		{ID: 11, Level: 101, Timestamp: "2024-01-01T00:00:00Z"},
+		{ID: 12, Level: 101, Timestamp: "2024-01-01T00:00:00Z"},
+	})
+
+	waitFor(t, "streamed delegations", func() bool {
+		service.mu.Lock()
+		defer service.mu.Unlock()
+		return len(service.streamed) == 1
+	})
+
+	// polling takes over while the stream is down
+	service.mu.Lock()
+	calls := len(service.afterIDs)
+	service.mu.Unlock()
+
+	hub.Disconnect()
+
+	waitFor(t, "polling fallback", func() bool {
+		service.mu.Lock()
+		defer service.mu.Unlock()
+		return len(service.afterIDs) > calls+1
+	})
+
+	select {
+	case <-hub.Subscribed():
+	case <-time.After(time.Second):
+		t.Fatal("Expected the poller to reconnect")
+	}
+
+	service.mu.Lock()
+	defer service.mu.Unlock()
+
+	// every page fetched after the streamed events continues from them
+	for i := calls; i < len(service.afterIDs); i++ {
+		if service.afterIDs[i] != 12 {
+			t.Errorf("Expected call %d to page after ID 12, got %d", i, service.afterIDs[i])
+		}
+	}
+}
+
+func TestPoller_StreamingSkipsStoredEvents(t *testing.T) {
+	ctx := context.Background()
+	repo := &MockPollerRepository{}
+	service := &MockPollerService{}
+	logger := slog.Default()
+
+	poller := NewPoller(ctx, repo, service, logger)
+	poller.lastID = 12
+
+	err := poller.apply(transport.StreamEvent{
+		Type:  transport.StreamData,
+		State: 101,
+		Data: []transport.DelegationResponse{
+			{ID: 11, Level: 101},
+			{ID: 12, Level: 101},
+			{ID: 13, Level: 101},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(service.streamed) != 1 || len(service.streamed[0]) != 1 || service.streamed[0][0].ID != 13 {
+		t.Errorf("Expected only delegation 13 to be stored, got %+v", service.streamed)
+	}
+	if poller.lastID != 13 {
+		t.Errorf("Expected lastID 13, got %d", poller.lastID)
 	}
 }
 
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"tezos-delegation-service/internal/metrics"
+	"tezos-delegation-service/internal/model"
+	"tezos-delegation-service/internal/transport"
+)
+
+// protocolRetryDelay is how long a failed refresh of the protocol table is not attempted again, so
+// that a TzKT outage does not cost a request per stored page.
+const protocolRetryDelay = time.Minute
+
+// protocolTable holds the protocols the cycle and protocol of delegations are derived from. They
+// are only activated at the start of a cycle, so a table fetched for a level stays valid until the
+// end of its cycle. Protocols before the current one no longer change.
+type protocolTable struct {
+	mu        sync.Mutex
+	source    transport.ProtocolSource
+	protocols model.Protocols
+	loaded    bool
+	// until is the last level the current protocol is known to run.
+	until    int
+	failedAt time.Time
+	now      func() time.Time
+}
+
+// covers tells whether the table is known to be valid at the level.
+func (t *protocolTable) covers(level int) bool {
+	if len(t.protocols) == 0 {
+		return false
+	}
+	return level < t.protocols[len(t.protocols)-1].FirstLevel || level <= t.until
+}
+
+// deriveCycles sets the cycle and protocol of the delegations, refreshing the protocol table first
+// when it does not cover them. When it cannot be refreshed, those it does not cover are stored
+// without and derived once it is.
+func (s *XtzFetcherService) deriveCycles(ctx context.Context, delegations []model.Delegation) {
+	t := s.protocols
+	if t.source == nil || len(delegations) == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.loaded {
+		// the protocols stored before still cover the past ones if TzKT is unavailable
+		if stored, err := s.repo.GetProtocols(ctx); err == nil {
+			t.protocols, t.loaded = stored, true
+		}
+	}
+
+	top := 0
+	for _, d := range delegations {
+		top = max(top, d.Level)
+	}
+	if !t.covers(top) && t.now().Sub(t.failedAt) >= protocolRetryDelay {
+		if err := s.refreshProtocols(ctx, top); err != nil {
+			metrics.ProtocolRefreshFailures.Add(1)
+			t.failedAt = t.now()
+		}
+	}
+
+	for i := range delegations {
+		if t.covers(delegations[i].Level) {
+			t.protocols.Derive(&delegations[i])
+		}
+	}
+}
+
+// refreshProtocols fetches the protocol table for the level and stores it, which derives again the
+// cycles of the stored delegations it changed.
+func (s *XtzFetcherService) refreshProtocols(ctx context.Context, level int) error {
+	t := s.protocols
+	results, err := t.source.GetProtocols(ctx)
+	if err != nil {
+		return err
+	}
+
+	protocols := make(model.Protocols, 0, len(*results))
+	for _, r := range *results {
+		protocols = append(protocols, model.Protocol{
+			Code:            r.Code,
+			Hash:            r.Hash,
+			FirstLevel:      r.FirstLevel,
+			LastLevel:       r.LastLevel,
+			FirstCycle:      r.FirstCycle,
+			FirstCycleLevel: r.FirstCycleLevel,
+			BlocksPerCycle:  r.Constants.BlocksPerCycle,
+		})
+	}
+	if err := s.repo.SaveProtocols(ctx, protocols); err != nil {
+		return err
+	}
+
+	t.protocols = protocols
+	t.until, _ = protocols.LastLevelOfCycle(level)
+	s.cache.invalidate()
+	return nil
+}
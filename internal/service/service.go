@@ -1,6 +1,8 @@
 package service
 
 import (
+	"context"
+	"tezos-delegation-service/internal/metrics"
 	"tezos-delegation-service/internal/model"
 	"tezos-delegation-service/internal/repository"
 	"tezos-delegation-service/internal/transport"
@@ -8,44 +10,176 @@ import (
 )
 
 type XtzService interface {
-	GetDelegations(year int, offset int) ([]model.Delegation, error)
-	StoreDelegations(offset int, startFrom string) ([]model.Delegation, error)
-	GetLatestDelegation() (model.Delegation, error)
+	GetDelegations(ctx context.Context, query model.DelegationQuery) ([]model.Delegation, error)
+	GetDelegatorDelegations(ctx context.Context, delegator string, query model.DelegationQuery) ([]model.Delegation, error)
+	CountDelegations(ctx context.Context, query model.DelegationQuery) (int, error)
+	GetCurrentDelegation(ctx context.Context, delegator string) (model.CurrentDelegation, error)
+	GetBakers(ctx context.Context, query model.BakerQuery) ([]model.BakerStats, error)
+	GetBaker(ctx context.Context, baker string) (model.BakerStats, error)
+	GetBakerDelegators(ctx context.Context, baker string, limit int, offset int) ([]model.CurrentDelegation, error)
+	GetDelegationStats(ctx context.Context, query model.StatsQuery) ([]model.DelegationStats, error)
+	StoreDelegations(ctx context.Context, checkpoint string, afterID int, from transport.Start) ([]model.Delegation, model.SyncCheckpoint, error)
+	StoreStreamed(ctx context.Context, checkpoint string, results []transport.DelegationResponse) ([]model.Delegation, error)
+	GetLatestDelegation(ctx context.Context) (model.Delegation, error)
+	GetHeadLevel(ctx context.Context) (int, error)
+	Reconcile(ctx context.Context, checkpoint string, depth int) (int, error)
+	FindFork(ctx context.Context, checkpoint string, depth int) (int, error)
+}
+
+// DefaultConfirmationDepth is how many levels below the checkpoint are checked for
+// reorganisations. Tenderbake makes a block final once two blocks were built on top of it.
+const DefaultConfirmationDepth = 2
+
+// IngestionPolicy decides which fetched delegations are stored.
+type IngestionPolicy string
+
+const (
+	// IngestAll stores every operation whatever its status, so the API can still show failed ones.
+	IngestAll IngestionPolicy = "all"
+	// IngestAppliedOnly drops failed, backtracked and skipped operations.
+	IngestAppliedOnly IngestionPolicy = "applied"
+)
+
+func (p IngestionPolicy) Valid() bool {
+	return p == IngestAll || p == IngestAppliedOnly
 }
 
 type XtzFetcherService struct {
 	repo       repository.DelegationRepository
 	tzklClient transport.TzktClientInterface
+	policy     IngestionPolicy
+	cache      *aggregateCache
+	protocols  *protocolTable
+}
+
+type Option func(*XtzFetcherService)
+
+func WithIngestionPolicy(policy IngestionPolicy) Option {
+	return func(s *XtzFetcherService) {
+		s.policy = policy
+	}
 }
 
-func NewXtzFetcherService(repo repository.DelegationRepository, client transport.TzktClientInterface) XtzService {
-	return &XtzFetcherService{
+// WithCacheTTL sets how long baker aggregates and statistics are cached, usually the poll interval.
+// Zero disables the cache.
+func WithCacheTTL(ttl time.Duration) Option {
+	return func(s *XtzFetcherService) {
+		s.cache.ttl = ttl
+	}
+}
+
+// WithProtocolSource derives the cycle and protocol of the delegations stored from the protocols
+// listed by the source. Without it they are left unknown.
+func WithProtocolSource(source transport.ProtocolSource) Option {
+	return func(s *XtzFetcherService) {
+		s.protocols.source = source
+	}
+}
+
+func NewXtzFetcherService(repo repository.DelegationRepository, client transport.TzktClientInterface, opts ...Option) XtzService {
+	s := &XtzFetcherService{
 		repo:       repo,
 		tzklClient: client,
+		policy:     IngestAll,
+		cache:      newAggregateCache(DefaultCacheTTL),
+		protocols:  &protocolTable{now: time.Now},
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
-func (s *XtzFetcherService) GetDelegations(year int, offset int) ([]model.Delegation, error) {
-	return s.repo.GetDelegations(year, offset)
+func (s *XtzFetcherService) GetDelegations(ctx context.Context, query model.DelegationQuery) ([]model.Delegation, error) {
+	return s.repo.GetDelegations(ctx, query)
 }
 
-func (s *XtzFetcherService) GetLatestDelegation() (model.Delegation, error) {
-	return s.repo.GetLatestDelegation(time.Now().Year())
+func (s *XtzFetcherService) GetDelegatorDelegations(ctx context.Context, delegator string, query model.DelegationQuery) ([]model.Delegation, error) {
+	return s.repo.GetDelegatorDelegations(ctx, delegator, query)
 }
 
-func (s *XtzFetcherService) StoreDelegations(offset int, startFrom string) ([]model.Delegation, error) {
-	results, err := s.tzklClient.GetDelegations(offset, startFrom)
+func (s *XtzFetcherService) CountDelegations(ctx context.Context, query model.DelegationQuery) (int, error) {
+	return s.repo.CountDelegations(ctx, query)
+}
+
+func (s *XtzFetcherService) GetCurrentDelegation(ctx context.Context, delegator string) (model.CurrentDelegation, error) {
+	return s.repo.GetCurrentDelegation(ctx, delegator)
+}
+
+func (s *XtzFetcherService) GetBakers(ctx context.Context, query model.BakerQuery) ([]model.BakerStats, error) {
+	return cached(s.cache, query, func() ([]model.BakerStats, error) {
+		return s.repo.GetBakers(ctx, query)
+	})
+}
+
+// bakerKey caches the stats of a baker apart from the rankings.
+type bakerKey string
+
+func (s *XtzFetcherService) GetBaker(ctx context.Context, baker string) (model.BakerStats, error) {
+	return cached(s.cache, bakerKey(baker), func() (model.BakerStats, error) {
+		return s.repo.GetBaker(ctx, baker)
+	})
+}
+
+func (s *XtzFetcherService) GetBakerDelegators(ctx context.Context, baker string, limit int, offset int) ([]model.CurrentDelegation, error) {
+	return s.repo.GetBakerDelegators(ctx, baker, limit, offset)
+}
+
+func (s *XtzFetcherService) GetDelegationStats(ctx context.Context, query model.StatsQuery) ([]model.DelegationStats, error) {
+	return cached(s.cache, query, func() ([]model.DelegationStats, error) {
+		return s.repo.GetDelegationStats(ctx, query)
+	})
+}
+
+func (s *XtzFetcherService) GetLatestDelegation(ctx context.Context) (model.Delegation, error) {
+	return s.repo.GetLatestDelegation(ctx, time.Now().Year())
+}
+
+func (s *XtzFetcherService) GetHeadLevel(ctx context.Context) (int, error) {
+	head, err := s.tzklClient.GetHead(ctx)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
+	return head.Level, nil
+}
 
+// StoreDelegations fetches the page of delegations following the afterID cursor and stores it,
+// advancing the named checkpoint, which records the level the walk started from. Pages are stored
+// idempotently, so re-fetching one is harmless.
+//
+// The checkpoint reached is returned along with the page, zero once there is nothing left to
+// fetch. It may be past the last delegation when the source walked levels without any.
+func (s *XtzFetcherService) StoreDelegations(ctx context.Context, checkpoint string, afterID int, from transport.Start) ([]model.Delegation, model.SyncCheckpoint, error) {
+	results, err := s.tzklClient.GetDelegations(ctx, afterID, from)
+	if err != nil {
+		return nil, model.SyncCheckpoint{}, err
+	}
+
+	reached := s.checkpoint(checkpoint, from.Level, *results)
+	delegations, err := s.store(ctx, reached, *results)
+	return delegations, reached, err
+}
+
+// StoreStreamed stores delegations pushed by TzKT rather than fetched, advancing the named
+// checkpoint like StoreDelegations. They must be ordered by ID.
+func (s *XtzFetcherService) StoreStreamed(ctx context.Context, checkpoint string, results []transport.DelegationResponse) ([]model.Delegation, error) {
+	return s.store(ctx, s.checkpoint(checkpoint, 0, results), results)
+}
+
+func (s *XtzFetcherService) store(ctx context.Context, checkpoint model.SyncCheckpoint, results []transport.DelegationResponse) ([]model.Delegation, error) {
 	var delegations []model.Delegation
-	for _, result := range *results {
+	for _, result := range results {
+		if result.Scanned {
+			continue
+		}
 		parsedTimestamp, err := time.Parse(time.RFC3339, result.Timestamp)
 		if err != nil {
 			return nil, err
 		}
 
+		baker := accountAddress(result.NewDelegate)
+		prevBaker := accountAddress(result.PrevDelegate)
+
 		delegations = append(delegations, model.Delegation{
 			ID:        result.ID,
 			Timestamp: result.Timestamp,
@@ -53,8 +187,119 @@ func (s *XtzFetcherService) StoreDelegations(offset int, startFrom string) ([]mo
 			Delegator: result.Sender.Address,
 			Level:     result.Level,
 			Year:      parsedTimestamp.Year(),
+			Baker:     baker,
+			PrevBaker: prevBaker,
+			Hash:      result.Hash,
+			Block:     result.Block,
+			Counter:   result.Counter,
+			Status:    result.Status,
+			BakerFee:  result.BakerFee,
+			GasUsed:   result.GasUsed,
+			Kind:      model.KindOf(prevBaker, baker),
 		})
 	}
 
-	return delegations, s.repo.SaveBatch(delegations)
+	s.deriveCycles(ctx, delegations)
+
+	// the checkpoint covers the whole page, including operations the policy does not store
+	stored := s.ingested(delegations)
+	if err := s.repo.SaveBatchWithCheckpoint(ctx, stored, checkpoint); err != nil {
+		return delegations, err
+	}
+	if len(stored) > 0 {
+		s.cache.invalidate()
+	}
+	return delegations, nil
+}
+
+func (s *XtzFetcherService) ingested(delegations []model.Delegation) []model.Delegation {
+	if s.policy != IngestAppliedOnly {
+		return delegations
+	}
+
+	applied := make([]model.Delegation, 0, len(delegations))
+	for _, d := range delegations {
+		if d.Status == model.StatusApplied {
+			applied = append(applied, d)
+		}
+	}
+	return applied
+}
+
+// Reconcile compares the blocks of the delegations stored within depth levels of the named
+// checkpoint with the blocks TzKT currently has. When one of them was replaced by a chain
+// reorganisation, the delegations from the fork level on are rolled back along with the checkpoint
+// and the fork level is returned; zero means the stored blocks are still on the main chain.
+func (s *XtzFetcherService) Reconcile(ctx context.Context, checkpoint string, depth int) (int, error) {
+	fork, err := s.FindFork(ctx, checkpoint, depth)
+	if err != nil || fork == 0 {
+		return 0, err
+	}
+
+	metrics.ChainReorgs.Add(1)
+	defer s.cache.invalidate()
+	return fork, s.repo.RollbackFrom(ctx, fork, checkpoint)
+}
+
+// FindFork returns the lowest level within depth levels of the named checkpoint whose stored block
+// is no longer on the main chain, or zero when there is none. Unlike Reconcile it changes nothing.
+func (s *XtzFetcherService) FindFork(ctx context.Context, checkpoint string, depth int) (int, error) {
+	synced, err := s.repo.GetCheckpoint(ctx, checkpoint)
+	if err != nil || synced.Level == 0 {
+		// nothing synced yet
+		return 0, nil
+	}
+
+	fromLevel := synced.Level - depth
+	stored, err := s.repo.GetBlockHashes(ctx, fromLevel, synced.Level)
+	if err != nil {
+		return 0, err
+	}
+	if len(stored) == 0 {
+		return 0, nil
+	}
+
+	blocks, err := s.tzklClient.GetBlocks(ctx, fromLevel, synced.Level)
+	if err != nil {
+		return 0, err
+	}
+
+	current := make(map[int]string, len(*blocks))
+	for _, block := range *blocks {
+		current[block.Level] = block.Hash
+	}
+
+	// a level TzKT no longer has was orphaned as well
+	fork := 0
+	for level, hash := range stored {
+		if current[level] != hash && (fork == 0 || level < fork) {
+			fork = level
+		}
+	}
+	return fork, nil
+}
+
+// accountAddress returns the address of an optional TzKT account, e.g. the missing new delegate of
+// an undelegation.
+func accountAddress(account *transport.Account) string {
+	if account == nil {
+		return ""
+	}
+	return account.Address
+}
+
+// checkpoint builds the named checkpoint reached once the page is stored.
+func (s *XtzFetcherService) checkpoint(name string, fromLevel int, results []transport.DelegationResponse) model.SyncCheckpoint {
+	checkpoint := model.SyncCheckpoint{
+		Name:      name,
+		Source:    s.tzklClient.Source(),
+		FromLevel: fromLevel,
+	}
+	if len(results) > 0 {
+		last := results[len(results)-1]
+		checkpoint.LastID = last.ID
+		checkpoint.Level = last.Level
+		checkpoint.Timestamp = last.Timestamp
+	}
+	return checkpoint
 }
@@ -1,7 +1,9 @@
 package service
 
 import (
+	"context"
 	"errors"
+	"reflect"
 	"testing"
 
 	"tezos-delegation-service/internal/model"
@@ -71,7 +73,7 @@ func TestGetDelegations(t *testing.T) {
 
 			service := NewXtzFetcherService(repo, client)
 
-			result, err := service.GetDelegations(tt.year, tt.offset)
+			result, err := service.GetDelegations(context.Background(), model.DelegationQuery{Year: tt.year, Offset: tt.offset})
 
 			if tt.expectedErr != nil {
 				if err == nil {
@@ -150,7 +152,7 @@ func TestGetLatestDelegation(t *testing.T) {
 
 			service := NewXtzFetcherService(repo, client)
 
-			result, err := service.GetLatestDelegation()
+			result, err := service.GetLatestDelegation(context.Background())
 
 			if tt.expectedErr != nil {
 				if err == nil {
@@ -203,7 +205,8 @@ func TestStoreDelegations(t *testing.T) {
 					Sender: struct {
 						Address string `json:"address"`
 					}{Address: "addr2"},
-					Level: 1001,
+					Level:       1001,
+					NewDelegate: &transport.Account{Address: "tz1baker"},
 				},
 				{
 					ID:        3,
@@ -212,7 +215,8 @@ func TestStoreDelegations(t *testing.T) {
 					Sender: struct {
 						Address string `json:"address"`
 					}{Address: "addr3"},
-					Level: 1002,
+					Level:       1002,
+					NewDelegate: &transport.Account{Address: "tz1baker"},
 				},
 			},
 			mockClientErr: nil,
@@ -225,6 +229,8 @@ func TestStoreDelegations(t *testing.T) {
 					Delegator: "addr2",
 					Level:     1001,
 					Year:      2024,
+					Baker:     "tz1baker",
+					Kind:      model.KindDelegate,
 				},
 				{
 					ID:        3,
@@ -233,6 +239,8 @@ func TestStoreDelegations(t *testing.T) {
 					Delegator: "addr3",
 					Level:     1002,
 					Year:      2024,
+					Baker:     "tz1baker",
+					Kind:      model.KindDelegate,
 				},
 			},
 			expectedErr: nil,
@@ -250,7 +258,8 @@ func TestStoreDelegations(t *testing.T) {
 					Sender: struct {
 						Address string `json:"address"`
 					}{Address: "addr1"},
-					Level: 1000,
+					Level:       1000,
+					NewDelegate: &transport.Account{Address: "tz1baker"},
 				},
 			},
 			mockClientErr: nil,
@@ -263,6 +272,8 @@ func TestStoreDelegations(t *testing.T) {
 					Delegator: "addr1",
 					Level:     1000,
 					Year:      2024,
+					Baker:     "tz1baker",
+					Kind:      model.KindDelegate,
 				},
 			},
 			expectedErr: nil,
@@ -298,7 +309,8 @@ func TestStoreDelegations(t *testing.T) {
 					Sender: struct {
 						Address string `json:"address"`
 					}{Address: "addr2"},
-					Level: 1001,
+					Level:       1001,
+					NewDelegate: &transport.Account{Address: "tz1baker"},
 				},
 			},
 			mockClientErr: nil,
@@ -311,6 +323,8 @@ func TestStoreDelegations(t *testing.T) {
 					Delegator: "addr2",
 					Level:     1001,
 					Year:      2024,
+					Baker:     "tz1baker",
+					Kind:      model.KindDelegate,
 				},
 			},
 			expectedErr: errors.New("save error"),
@@ -331,7 +345,7 @@ func TestStoreDelegations(t *testing.T) {
 
 			service := NewXtzFetcherService(repo, client)
 
-			result, err := service.StoreDelegations(tt.offset, "")
+			result, _, err := service.StoreDelegations(context.Background(), model.HeadCheckpoint, tt.offset, transport.Start{})
 
 			if tt.expectedErr != nil {
 				if err == nil {
@@ -384,7 +398,7 @@ func TestStoreDelegations_StartFromLogic(t *testing.T) {
 
 	service := NewXtzFetcherService(repo, client)
 
-	_, err := service.StoreDelegations(10, "2023-12-31T23:59:59Z")
+	_, _, err := service.StoreDelegations(context.Background(), model.HeadCheckpoint, 10, transport.Start{Timestamp: "2023-12-31T23:59:59Z"})
 	if err != nil {
 		t.Errorf("Expected no error, got %v", err)
 	}
@@ -404,7 +418,7 @@ func TestStoreDelegations_EmptyResults(t *testing.T) {
 
 	service := NewXtzFetcherService(repo, client)
 
-	result, err := service.StoreDelegations(10, "")
+	result, _, err := service.StoreDelegations(context.Background(), model.HeadCheckpoint, 10, transport.Start{})
 	if err != nil {
 		t.Errorf("Expected no error, got %v", err)
 	}
@@ -413,3 +427,434 @@ func TestStoreDelegations_EmptyResults(t *testing.T) {
 		t.Errorf("Expected empty result, got %d delegations", len(result))
 	}
 }
+
+func TestStoreDelegations_AdvancesCheckpoint(t *testing.T) {
+	repo := &mocks.MockDelegationRepository{}
+
+	client := &mocks.MockTzktClient{
+		Delegations: &[]transport.DelegationResponse{
+			{ID: 5, Timestamp: "2024-01-01T00:00:00Z", Amount: 1000, Level: 1001},
+			{ID: 7, Timestamp: "2024-01-01T01:00:00Z", Amount: 2000, Level: 1002},
+		},
+		URL: "https://api.tzkt.io/v1/operations/delegations",
+	}
+
+	service := NewXtzFetcherService(repo, client)
+
+	_, _, err := service.StoreDelegations(context.Background(), model.HeadCheckpoint, 0, transport.Start{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := model.SyncCheckpoint{
+		Name:      model.HeadCheckpoint,
+		LastID:    7,
+		Level:     1002,
+		Timestamp: "2024-01-01T01:00:00Z",
+		Source:    "https://api.tzkt.io/v1/operations/delegations",
+	}
+	if repo.Checkpoint != expected {
+		t.Errorf("Expected checkpoint %+v, got %+v", expected, repo.Checkpoint)
+	}
+
+	if len(repo.Saved) != 2 {
+		t.Errorf("Expected 2 saved delegations, got %d", len(repo.Saved))
+	}
+}
+
+func TestStoreDelegations_Scanned(t *testing.T) {
+	repo := &mocks.MockDelegationRepository{}
+	client := &mocks.MockTzktClient{
+		Delegations: &[]transport.DelegationResponse{
+			{ID: 1199999, Timestamp: "2024-06-01T12:00:00Z", Level: 11, Scanned: true},
+		},
+		URL: "http://localhost:8732",
+	}
+
+	service := NewXtzFetcherService(repo, client)
+
+	results, reached, err := service.StoreDelegations(context.Background(), model.HeadCheckpoint, 0, transport.Start{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(results) != 0 || len(repo.Saved) != 0 {
+		t.Errorf("Expected nothing stored, got %+v", results)
+	}
+
+	// the cursor moves past the levels walked all the same
+	expected := model.SyncCheckpoint{
+		Name:      model.HeadCheckpoint,
+		LastID:    1199999,
+		Level:     11,
+		Timestamp: "2024-06-01T12:00:00Z",
+		Source:    "http://localhost:8732",
+	}
+	if reached != expected || repo.Checkpoint != expected {
+		t.Errorf("Expected checkpoint %+v, got %+v returned and %+v saved", expected, reached, repo.Checkpoint)
+	}
+}
+
+func TestStoreDelegations_NamedCheckpoint(t *testing.T) {
+	repo := &mocks.MockDelegationRepository{}
+	client := &mocks.MockTzktClient{
+		Delegations: &[]transport.DelegationResponse{
+			{ID: 5, Timestamp: "2019-01-01T00:00:00Z", Amount: 1000, Level: 300000},
+		},
+	}
+
+	service := NewXtzFetcherService(repo, client)
+
+	_, _, err := service.StoreDelegations(context.Background(), model.BackfillCheckpoint, 0, transport.Start{Level: 290000})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if repo.Checkpoint.Name != model.BackfillCheckpoint {
+		t.Errorf("Expected checkpoint %s, got %s", model.BackfillCheckpoint, repo.Checkpoint.Name)
+	}
+	if repo.Checkpoint.FromLevel != 290000 {
+		t.Errorf("Expected the checkpoint to record the start level 290000, got %d", repo.Checkpoint.FromLevel)
+	}
+}
+
+func TestGetHeadLevel(t *testing.T) {
+	tests := []struct {
+		name        string
+		head        *transport.HeadResponse
+		mockErr     error
+		expected    int
+		expectedErr error
+	}{
+		{
+			name:     "successful retrieval",
+			head:     &transport.HeadResponse{Level: 5000000, Timestamp: "2024-01-01T00:00:00Z"},
+			expected: 5000000,
+		},
+		{
+			name:        "client error",
+			mockErr:     errors.New("API error"),
+			expectedErr: errors.New("API error"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &mocks.MockTzktClient{Head: tt.head, Err: tt.mockErr}
+			service := NewXtzFetcherService(&mocks.MockDelegationRepository{}, client)
+
+			level, err := service.GetHeadLevel(context.Background())
+
+			if tt.expectedErr != nil {
+				if err == nil || err.Error() != tt.expectedErr.Error() {
+					t.Errorf("Expected error %v, got %v", tt.expectedErr, err)
+				}
+			} else if err != nil {
+				t.Errorf("Expected no error, got %v", err)
+			}
+
+			if level != tt.expected {
+				t.Errorf("Expected level %d, got %d", tt.expected, level)
+			}
+		})
+	}
+}
+
+func TestStoreDelegations_MapsOperationDetails(t *testing.T) {
+	repo := &mocks.MockDelegationRepository{}
+	client := &mocks.MockTzktClient{
+		Delegations: &[]transport.DelegationResponse{
+			{
+				ID:           1,
+				Timestamp:    "2024-01-01T00:00:00Z",
+				Amount:       1000,
+				Level:        1001,
+				Block:        "BLblock",
+				Hash:         "oohash",
+				Counter:      42,
+				PrevDelegate: &transport.Account{Address: "tz1old"},
+				NewDelegate:  &transport.Account{Alias: "Baker", Address: "tz1new"},
+				Status:       "applied",
+				BakerFee:     397,
+				GasUsed:      1000,
+			},
+			{
+				// undelegation: no new delegate
+				ID:           2,
+				Timestamp:    "2024-01-01T01:00:00Z",
+				Amount:       2000,
+				Level:        1002,
+				PrevDelegate: &transport.Account{Address: "tz1new"},
+				Status:       "applied",
+			},
+		},
+	}
+
+	service := NewXtzFetcherService(repo, client)
+
+	result, _, err := service.StoreDelegations(context.Background(), model.HeadCheckpoint, 0, transport.Start{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := model.Delegation{
+		ID:        1,
+		Timestamp: "2024-01-01T00:00:00Z",
+		Amount:    1000,
+		Level:     1001,
+		Year:      2024,
+		Baker:     "tz1new",
+		PrevBaker: "tz1old",
+		Hash:      "oohash",
+		Block:     "BLblock",
+		Counter:   42,
+		Status:    "applied",
+		BakerFee:  397,
+		GasUsed:   1000,
+		Kind:      model.KindRedelegate,
+	}
+	if result[0] != expected {
+		t.Errorf("Expected delegation %+v, got %+v", expected, result[0])
+	}
+
+	if result[1].Baker != "" || result[1].PrevBaker != "tz1new" || result[1].Kind != model.KindUndelegate {
+		t.Errorf("Expected undelegation from tz1new, got %+v", result[1])
+	}
+}
+
+func TestStoreDelegations_IngestionPolicy(t *testing.T) {
+	responses := []transport.DelegationResponse{
+		{ID: 5, Timestamp: "2024-01-01T00:00:00Z", Level: 1001, Status: model.StatusApplied},
+		{ID: 6, Timestamp: "2024-01-01T00:30:00Z", Level: 1001, Status: model.StatusFailed},
+		{ID: 7, Timestamp: "2024-01-01T01:00:00Z", Level: 1002, Status: model.StatusBacktracked},
+	}
+
+	tests := []struct {
+		name        string
+		policy      IngestionPolicy
+		expectedIDs []int
+	}{
+		{name: "all", policy: IngestAll, expectedIDs: []int{5, 6, 7}},
+		{name: "applied only", policy: IngestAppliedOnly, expectedIDs: []int{5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &mocks.MockDelegationRepository{}
+			client := &mocks.MockTzktClient{Delegations: &responses}
+
+			service := NewXtzFetcherService(repo, client, WithIngestionPolicy(tt.policy))
+
+			results, _, err := service.StoreDelegations(context.Background(), model.HeadCheckpoint, 0, transport.Start{})
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			var ids []int
+			for _, d := range repo.Saved {
+				ids = append(ids, d.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.expectedIDs) {
+				t.Errorf("Expected saved IDs %v, got %v", tt.expectedIDs, ids)
+			}
+
+			// the caller and the checkpoint still see the whole page
+			if len(results) != len(responses) {
+				t.Errorf("Expected %d results, got %d", len(responses), len(results))
+			}
+			if repo.Checkpoint.LastID != 7 {
+				t.Errorf("Expected checkpoint after 7, got %d", repo.Checkpoint.LastID)
+			}
+		})
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	checkpoint := model.SyncCheckpoint{Name: model.HeadCheckpoint, LastID: 9, Level: 1003}
+
+	tests := []struct {
+		name               string
+		stored             map[int]string
+		current            []transport.BlockResponse
+		expectedFork       int
+		expectedRolledBack []int
+	}{
+		{
+			name:   "same chain",
+			stored: map[int]string{1001: "BLa", 1003: "BLc"},
+			current: []transport.BlockResponse{
+				{Level: 1001, Hash: "BLa"}, {Level: 1002, Hash: "BLb"}, {Level: 1003, Hash: "BLc"},
+			},
+		},
+		{
+			name:   "replaced block",
+			stored: map[int]string{1001: "BLa", 1002: "BLb", 1003: "BLc"},
+			current: []transport.BlockResponse{
+				{Level: 1001, Hash: "BLa"}, {Level: 1002, Hash: "BLx"}, {Level: 1003, Hash: "BLy"},
+			},
+			expectedFork:       1002,
+			expectedRolledBack: []int{1002},
+		},
+		{
+			name:   "orphaned level",
+			stored: map[int]string{1002: "BLb", 1003: "BLc"},
+			current: []transport.BlockResponse{
+				{Level: 1001, Hash: "BLa"}, {Level: 1002, Hash: "BLb"},
+			},
+			expectedFork:       1003,
+			expectedRolledBack: []int{1003},
+		},
+		{
+			name:   "final levels are not checked",
+			stored: map[int]string{900: "BLold"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &mocks.MockDelegationRepository{Checkpoint: checkpoint, Blocks: tt.stored}
+			client := &mocks.MockTzktClient{Blocks: &tt.current}
+
+			service := NewXtzFetcherService(repo, client)
+
+			fork, err := service.Reconcile(context.Background(), model.HeadCheckpoint, 2)
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+			if fork != tt.expectedFork {
+				t.Errorf("Expected fork %d, got %d", tt.expectedFork, fork)
+			}
+			if !reflect.DeepEqual(repo.RolledBack, tt.expectedRolledBack) {
+				t.Errorf("Expected rollbacks %v, got %v", tt.expectedRolledBack, repo.RolledBack)
+			}
+		})
+	}
+}
+
+func TestReconcile_NothingSynced(t *testing.T) {
+	repo := &mocks.MockDelegationRepository{}
+	client := &mocks.MockTzktClient{Err: errors.New("must not be called")}
+
+	service := NewXtzFetcherService(repo, client)
+
+	fork, err := service.Reconcile(context.Background(), model.HeadCheckpoint, 2)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if fork != 0 {
+		t.Errorf("Expected no fork, got %d", fork)
+	}
+}
+
+func TestFindFork_DoesNotRollBack(t *testing.T) {
+	repo := &mocks.MockDelegationRepository{
+		Checkpoint: model.SyncCheckpoint{Name: model.HeadCheckpoint, LastID: 9, Level: 1003},
+		Blocks:     map[int]string{1002: "BLb", 1003: "BLc"},
+	}
+	client := &mocks.MockTzktClient{Blocks: &[]transport.BlockResponse{{Level: 1002, Hash: "BLb"}, {Level: 1003, Hash: "BLx"}}}
+
+	service := NewXtzFetcherService(repo, client)
+
+	fork, err := service.FindFork(context.Background(), model.HeadCheckpoint, 2)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if fork != 1003 {
+		t.Errorf("Expected fork 1003, got %d", fork)
+	}
+	if len(repo.RolledBack) != 0 {
+		t.Errorf("Expected nothing to be rolled back, got %v", repo.RolledBack)
+	}
+}
+
+func TestStoreStreamed(t *testing.T) {
+	repo := &mocks.MockDelegationRepository{}
+	client := &mocks.MockTzktClient{Err: errors.New("must not be called")}
+
+	service := NewXtzFetcherService(repo, client, WithIngestionPolicy(IngestAppliedOnly))
+
+	results, err := service.StoreStreamed(context.Background(), model.HeadCheckpoint, []transport.DelegationResponse{
+		{ID: 5, Timestamp: "2024-01-01T00:00:00Z", Level: 1001, Status: model.StatusApplied, NewDelegate: &transport.Account{Address: "tz1baker"}},
+		{ID: 6, Timestamp: "2024-01-01T00:00:00Z", Level: 1001, Status: model.StatusFailed},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(results) != 2 {
+		t.Errorf("Expected 2 results, got %d", len(results))
+	}
+	if len(repo.Saved) != 1 || repo.Saved[0].ID != 5 || repo.Saved[0].Kind != model.KindDelegate {
+		t.Errorf("Expected the applied delegation to be saved, got %+v", repo.Saved)
+	}
+	if repo.Checkpoint.LastID != 6 || repo.Checkpoint.Level != 1001 {
+		t.Errorf("Expected checkpoint after 6 at level 1001, got %+v", repo.Checkpoint)
+	}
+}
+
+func TestStoreStreamed_DerivesCycles(t *testing.T) {
+	protocols := &[]transport.ProtocolResponse{{Code: 1, Hash: "PtOne", FirstLevel: 1, FirstCycleLevel: 1}}
+	(*protocols)[0].Constants.BlocksPerCycle = 128
+
+	repo := &mocks.MockDelegationRepository{}
+	client := &mocks.MockTzktClient{Protocols: protocols}
+	service := NewXtzFetcherService(repo, client, WithProtocolSource(client))
+
+	store := func(id, level int) model.Delegation {
+		t.Helper()
+		results, err := service.StoreStreamed(context.Background(), model.HeadCheckpoint, []transport.DelegationResponse{
+			{ID: id, Timestamp: "2024-01-01T00:00:00Z", Level: level, Status: model.StatusApplied},
+		})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		return results[0]
+	}
+
+	// levels 257 to 384 are cycle 2
+	if d := store(1, 300); d.Cycle == nil || *d.Cycle != 2 || d.Protocol != "PtOne" {
+		t.Errorf("Expected cycle 2 of PtOne, got %+v", d)
+	}
+	if len(repo.Protocols) != 1 || client.ProtocolRequests != 1 {
+		t.Errorf("Expected the protocols to be fetched and stored once, got %d requests and %+v", client.ProtocolRequests, repo.Protocols)
+	}
+	store(2, 384)
+	if client.ProtocolRequests != 1 {
+		t.Errorf("Expected the table to cover the end of the cycle, got %d requests", client.ProtocolRequests)
+	}
+	// a protocol may be activated with the next cycle
+	if d := store(3, 385); d.Cycle == nil || *d.Cycle != 3 || client.ProtocolRequests != 2 {
+		t.Errorf("Expected the table to be refreshed for cycle 3, got %+v after %d requests", d, client.ProtocolRequests)
+	}
+}
+
+func TestStoreStreamed_ProtocolsUnavailable(t *testing.T) {
+	repo := &mocks.MockDelegationRepository{Protocols: model.Protocols{
+		{Code: 1, Hash: "PtOne", FirstLevel: 1, LastLevel: 199, FirstCycleLevel: 1, BlocksPerCycle: 128},
+		{Code: 2, Hash: "PtTwo", FirstLevel: 200, FirstCycle: 1, FirstCycleLevel: 129, BlocksPerCycle: 128},
+	}}
+	client := &mocks.MockTzktClient{Err: errors.New("TzKT is down")}
+	service := NewXtzFetcherService(repo, client, WithProtocolSource(client))
+
+	results, err := service.StoreStreamed(context.Background(), model.HeadCheckpoint, []transport.DelegationResponse{
+		{ID: 1, Timestamp: "2024-01-01T00:00:00Z", Level: 150, Status: model.StatusApplied},
+		{ID: 2, Timestamp: "2024-01-01T00:00:00Z", Level: 300, Status: model.StatusApplied},
+	})
+	if err != nil {
+		t.Fatalf("Expected the delegations to be stored anyway, got %v", err)
+	}
+
+	// the stored protocols still cover the past ones, not the current one
+	if results[0].Cycle == nil || *results[0].Cycle != 1 || results[0].Protocol != "PtOne" {
+		t.Errorf("Expected cycle 1 of PtOne, got %+v", results[0])
+	}
+	if results[1].Cycle != nil || results[1].Protocol != "" {
+		t.Errorf("Expected no cycle while the protocols cannot be refreshed, got %+v", results[1])
+	}
+
+	service.StoreStreamed(context.Background(), model.HeadCheckpoint, []transport.DelegationResponse{
+		{ID: 3, Timestamp: "2024-01-01T00:00:00Z", Level: 301, Status: model.StatusApplied},
+	})
+	if client.ProtocolRequests != 1 {
+		t.Errorf("Expected the refresh not to be retried right away, got %d requests", client.ProtocolRequests)
+	}
+}
@@ -0,0 +1,111 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"time"
+)
+
+// jsonClient sends the GET requests of a source: rate limited, bounded per attempt and retried.
+type jsonClient struct {
+	httpClient     *http.Client
+	retry          RetryPolicy
+	requestTimeout time.Duration
+	limiter        *RateLimiter
+	requests       *expvar.Int
+}
+
+// Option configures how a client talks to its source.
+type Option func(*jsonClient)
+
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *jsonClient) {
+		c.httpClient = httpClient
+	}
+}
+
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *jsonClient) {
+		c.retry = policy
+	}
+}
+
+// WithRateLimiter makes the client draw from a limiter that may be shared with other clients.
+func WithRateLimiter(limiter *RateLimiter) Option {
+	return func(c *jsonClient) {
+		c.limiter = limiter
+	}
+}
+
+// WithRequestTimeout bounds every single attempt; a timed out attempt is retried.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(c *jsonClient) {
+		c.requestTimeout = timeout
+	}
+}
+
+// getJSON fetches rawURL into v, retrying transient failures with capped exponential backoff.
+// A *RetryError is returned once they persisted through every attempt; cancelling ctx aborts both
+// the in-flight request and the wait between attempts.
+func (c *jsonClient) getJSON(ctx context.Context, rawURL string, v any) error {
+	for attempt := 1; ; attempt++ {
+		err := c.fetchJSON(ctx, rawURL, v)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		class := Classify(err)
+		if class == ClassPermanent {
+			return err
+		}
+		if attempt >= c.retry.MaxAttempts {
+			return &RetryError{Attempts: attempt, Class: class, Err: err}
+		}
+
+		timer := time.NewTimer(c.retry.delay(attempt, err))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *jsonClient) fetchJSON(ctx context.Context, rawURL string, v any) error {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return err
+	}
+	c.requests.Add(1)
+
+	if c.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.requestTimeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return &StatusError{
+			StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,432 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"tezos-delegation-service/internal/metrics"
+	"time"
+)
+
+const (
+	// DefaultMaxLag is how many levels a source may trail the most advanced one before it is
+	// considered stale.
+	DefaultMaxLag = 10
+
+	defaultHeadCheckInterval = time.Minute
+)
+
+// LevelSource lists the delegations of a single level, which is what cross-checks compare.
+type LevelSource interface {
+	GetDelegationsAt(ctx context.Context, level int) (*[]DelegationResponse, error)
+	Source() string
+}
+
+// OperationKey identifies a delegation whatever the ID space of its source: TzKT IDs and the
+// synthetic IDs of a node differ, operation hashes and senders do not.
+type OperationKey struct {
+	Hash   string
+	Sender string
+}
+
+func keyOf(d DelegationResponse) OperationKey {
+	return OperationKey{Hash: d.Hash, Sender: d.Sender.Address}
+}
+
+// Cursor locates the last delegation read independently of IDs: the level of its block and the
+// delegations of that level already read.
+type Cursor struct {
+	Level int
+	Read  map[OperationKey]bool
+}
+
+// CursorResolver locates a cursor the MultiSource did not hand out itself, such as the one a
+// checkpoint was left at by a previous run. It reports false when it does not know the cursor.
+type CursorResolver func(ctx context.Context, afterID int) (Cursor, bool, error)
+
+// MultiSource reads delegations from the first healthy of several sources, failing over to the
+// next one when a call fails or when the source lags behind the others.
+//
+// Sources may hand out different IDs for the same delegation: TzKT ones, or the synthetic ones of
+// a node. Failing over between them resumes from the level of the cursor rather than from its ID,
+// skipping the delegations of that level already read.
+type MultiSource struct {
+	sources           []TzktClientInterface
+	logger            *slog.Logger
+	maxLag            int
+	headCheckInterval time.Duration
+	verifier          LevelSource
+	crossCheckEvery   int
+	resolve           CursorResolver
+
+	mu        sync.Mutex
+	active    int
+	stale     []bool
+	checkedAt time.Time
+	pages     int
+	// cursor is the last delegation handed out, or the last one resolved
+	cursor multiCursor
+}
+
+// multiCursor is a cursor along with its ID and the ID space it belongs to, empty when unknown.
+type multiCursor struct {
+	Cursor
+	id    int
+	space string
+}
+
+type MultiOption func(*MultiSource)
+
+// WithMaxLag sets how many levels a source may trail the most advanced one.
+func WithMaxLag(levels int) MultiOption {
+	return func(m *MultiSource) {
+		m.maxLag = levels
+	}
+}
+
+// WithCrossCheck compares one level of every everyPages page with the verifier. Delegations are
+// matched by operation hash and delegator, so the verifier may use other IDs.
+func WithCrossCheck(verifier LevelSource, everyPages int) MultiOption {
+	return func(m *MultiSource) {
+		m.verifier = verifier
+		m.crossCheckEvery = everyPages
+	}
+}
+
+// WithCursorResolver locates the cursors the MultiSource is given without having handed them out,
+// so that they can be resumed from a source of another ID space. Other cursors are passed through.
+func WithCursorResolver(resolve CursorResolver) MultiOption {
+	return func(m *MultiSource) {
+		m.resolve = resolve
+	}
+}
+
+// NewMultiSource fails over between the sources in the given order of preference.
+func NewMultiSource(logger *slog.Logger, sources []TzktClientInterface, opts ...MultiOption) *MultiSource {
+	m := &MultiSource{
+		sources:           sources,
+		logger:            logger,
+		maxLag:            DefaultMaxLag,
+		headCheckInterval: defaultHeadCheckInterval,
+		stale:             make([]bool, len(sources)),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Source returns the URL of the source currently in use.
+func (m *MultiSource) Source() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.sources[m.active].Source()
+}
+
+func (m *MultiSource) GetDelegations(ctx context.Context, afterID int, from Start) (*[]DelegationResponse, error) {
+	cursor, err := m.locate(ctx, afterID)
+	if err != nil {
+		return nil, err
+	}
+
+	var page *[]DelegationResponse
+	var space string
+	err = m.try(ctx, func(source TzktClientInterface) error {
+		var err error
+		space = idSpace(source)
+		page, err = m.page(ctx, source, afterID, from, cursor)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	m.advance(*page, space)
+	m.pages++
+	check := m.verifier != nil && m.crossCheckEvery > 0 && m.pages%m.crossCheckEvery == 0
+	m.mu.Unlock()
+
+	if check && len(*page) > 0 {
+		m.crossCheck(ctx, *page)
+	}
+	return page, nil
+}
+
+// locate returns the cursor afterID stands for, or nil when afterID is to be passed through as is:
+// at the start of a walk, or for a cursor that neither was handed out nor could be resolved.
+func (m *MultiSource) locate(ctx context.Context, afterID int) (*multiCursor, error) {
+	if afterID == 0 {
+		return nil, nil
+	}
+
+	m.mu.Lock()
+	cursor := m.cursor
+	m.mu.Unlock()
+	if cursor.id == afterID {
+		return &cursor, nil
+	}
+	if m.resolve == nil {
+		return nil, nil
+	}
+
+	resolved, ok, err := m.resolve(ctx, afterID)
+	if err != nil || !ok {
+		return nil, err
+	}
+	// whichever source handed it out, resume it from its level
+	cursor = multiCursor{Cursor: resolved, id: afterID}
+	if cursor.Read == nil {
+		cursor.Read = map[OperationKey]bool{}
+	}
+
+	m.mu.Lock()
+	m.cursor = cursor
+	m.mu.Unlock()
+	return &cursor, nil
+}
+
+// page reads the page after the cursor from source. A cursor of another ID space is resumed from
+// its level, without the delegations of that level already read.
+func (m *MultiSource) page(ctx context.Context, source TzktClientInterface, afterID int, from Start, cursor *multiCursor) (*[]DelegationResponse, error) {
+	if cursor == nil || cursor.space == idSpace(source) {
+		return source.GetDelegations(ctx, afterID, from)
+	}
+
+	from.Level = max(from.Level, cursor.Level)
+	after := 0
+	for {
+		page, err := source.GetDelegations(ctx, after, from)
+		if err != nil || len(*page) == 0 {
+			return page, err
+		}
+
+		fresh := []DelegationResponse{}
+		for _, d := range *page {
+			if d.Level != cursor.Level || !cursor.Read[keyOf(d)] {
+				fresh = append(fresh, d)
+			}
+		}
+		if len(fresh) > 0 {
+			return &fresh, nil
+		}
+		// every delegation of the page was already read, go on in the IDs of this source
+		after = (*page)[len(*page)-1].ID
+	}
+}
+
+// advance moves the cursor to the end of a page read from a source of the given ID space. Callers
+// hold mu.
+func (m *MultiSource) advance(page []DelegationResponse, space string) {
+	if len(page) == 0 {
+		return
+	}
+
+	last := page[len(page)-1]
+	if m.cursor.Level != last.Level || m.cursor.Read == nil {
+		m.cursor.Read = map[OperationKey]bool{}
+	}
+	m.cursor.id, m.cursor.Level, m.cursor.space = last.ID, last.Level, space
+	for _, d := range page {
+		if d.Level == last.Level && !d.Scanned {
+			m.cursor.Read[keyOf(d)] = true
+		}
+	}
+}
+
+// idSpace tells which IDs a source hands out: the synthetic ones of a node, or TzKT ones.
+func idSpace(source TzktClientInterface) string {
+	if spaced, ok := source.(interface{ IDSpace() string }); ok {
+		return spaced.IDSpace()
+	}
+	return "tzkt"
+}
+
+func (m *MultiSource) GetHead(ctx context.Context) (*HeadResponse, error) {
+	var head *HeadResponse
+	err := m.try(ctx, func(source TzktClientInterface) error {
+		var err error
+		head, err = source.GetHead(ctx)
+		return err
+	})
+	return head, err
+}
+
+func (m *MultiSource) GetBlocks(ctx context.Context, fromLevel, toLevel int) (*[]BlockResponse, error) {
+	var blocks *[]BlockResponse
+	err := m.try(ctx, func(source TzktClientInterface) error {
+		var err error
+		blocks, err = source.GetBlocks(ctx, fromLevel, toLevel)
+		return err
+	})
+	return blocks, err
+}
+
+// try calls fn with each source in order of preference until one succeeds. The errors of every
+// source are returned when none did.
+func (m *MultiSource) try(ctx context.Context, fn func(TzktClientInterface) error) error {
+	m.checkHeads(ctx)
+
+	var errs []error
+	for _, i := range m.order() {
+		err := fn(m.sources[i])
+		if err == nil {
+			m.use(i)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		m.logger.Warn("Delegation source failed", "source", m.sources[i].Source(), "error", err)
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// order returns the active source first unless it is stale, then the other fresh ones, then the
+// stale ones as a last resort.
+func (m *MultiSource) order() []int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var fresh, stale []int
+	for i := range m.sources {
+		if i == m.active {
+			continue
+		}
+		if m.stale[i] {
+			stale = append(stale, i)
+		} else {
+			fresh = append(fresh, i)
+		}
+	}
+
+	if m.stale[m.active] {
+		return append(append(fresh, m.active), stale...)
+	}
+	return append(append([]int{m.active}, fresh...), stale...)
+}
+
+func (m *MultiSource) use(i int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if i == m.active {
+		return
+	}
+	m.logger.Warn("Switched delegation source", "from", m.sources[m.active].Source(), "to", m.sources[i].Source())
+	metrics.SourceFailovers.Add(1)
+	m.active = i
+}
+
+// checkHeads marks the sources trailing the most advanced one by more than maxLag levels, or
+// failing to report their head, as stale. Heads are checked at most every headCheckInterval.
+func (m *MultiSource) checkHeads(ctx context.Context) {
+	if len(m.sources) < 2 {
+		return
+	}
+
+	m.mu.Lock()
+	due := time.Since(m.checkedAt) >= m.headCheckInterval
+	if due {
+		m.checkedAt = time.Now()
+	}
+	m.mu.Unlock()
+	if !due {
+		return
+	}
+
+	levels := make([]int, len(m.sources))
+	best := 0
+	for i, source := range m.sources {
+		head, err := source.GetHead(ctx)
+		if err != nil {
+			levels[i] = -1
+			continue
+		}
+		levels[i] = head.Level
+		best = max(best, head.Level)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, level := range levels {
+		stale := level < 0 || best-level > m.maxLag
+		if stale && !m.stale[i] {
+			m.logger.Warn("Delegation source is stale", "source", m.sources[i].Source(), "level", level, "best", best)
+		}
+		m.stale[i] = stale
+	}
+}
+
+// crossCheck compares the delegations of the last level of page with the verifier, logging and
+// counting those it disagrees on. The verifier is asked for that level only, so a delegation it
+// holds at another level shows as missing. A failing verifier does not fail the page.
+func (m *MultiSource) crossCheck(ctx context.Context, page []DelegationResponse) {
+	level := page[len(page)-1].Level
+
+	verified, err := m.verifier.GetDelegationsAt(ctx, level)
+	if err != nil {
+		m.logger.Warn("Failed to cross-check delegations", "source", m.verifier.Source(), "level", level, "error", err)
+		return
+	}
+	if len(*verified) == 0 {
+		// the verifier may not have indexed the level yet
+		return
+	}
+
+	byHash := make(map[string][]DelegationResponse, len(*verified))
+	for _, d := range *verified {
+		byHash[d.Hash] = append(byHash[d.Hash], d)
+	}
+
+	for _, d := range page {
+		if d.Level != level || d.Scanned {
+			continue
+		}
+
+		other, found := matchDelegation(byHash[d.Hash], d.Sender.Address)
+		var field string
+		switch {
+		case !found:
+			field = "missing"
+		case other.Sender.Address != d.Sender.Address:
+			field = "sender"
+		case other.Amount != d.Amount:
+			field = "amount"
+		default:
+			continue
+		}
+
+		metrics.SourceDisagreements.Add(1)
+		m.logger.Warn("Delegation sources disagree",
+			"field", field,
+			"hash", d.Hash,
+			"sender", d.Sender.Address,
+			"level", d.Level,
+			"amount", d.Amount,
+			"verifier", m.verifier.Source(),
+			"verifier_sender", other.Sender.Address,
+			"verifier_amount", other.Amount,
+		)
+	}
+}
+
+// matchDelegation picks the delegation of sender among those of an operation. An operation
+// holding a single delegation is returned whatever its sender, so that a differing sender shows.
+func matchDelegation(candidates []DelegationResponse, sender string) (DelegationResponse, bool) {
+	for _, candidate := range candidates {
+		if candidate.Sender.Address == sender {
+			return candidate, true
+		}
+	}
+	if len(candidates) == 1 {
+		return candidates[0], true
+	}
+	return DelegationResponse{}, false
+}
+
+var _ TzktClientInterface = (*MultiSource)(nil)
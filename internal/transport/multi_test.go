@@ -0,0 +1,329 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"tezos-delegation-service/internal/metrics"
+)
+
+type fakeSource struct {
+	url   string
+	space string
+	head  int
+	page  []DelegationResponse
+	// pages are served in turn before page
+	pages    [][]DelegationResponse
+	byLevel  []DelegationResponse
+	err      error
+	calls    int
+	afterIDs []int
+	froms    []Start
+	levelErr error
+}
+
+func (f *fakeSource) GetDelegations(ctx context.Context, afterID int, from Start) (*[]DelegationResponse, error) {
+	f.calls++
+	f.afterIDs = append(f.afterIDs, afterID)
+	f.froms = append(f.froms, from)
+	if f.err != nil {
+		return nil, f.err
+	}
+	if len(f.pages) > 0 {
+		page := f.pages[0]
+		f.pages = f.pages[1:]
+		return &page, nil
+	}
+	return &f.page, nil
+}
+
+func (f *fakeSource) IDSpace() string {
+	if f.space == "" {
+		return "tzkt"
+	}
+	return f.space
+}
+
+func (f *fakeSource) GetHead(ctx context.Context) (*HeadResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &HeadResponse{Level: f.head}, nil
+}
+
+func (f *fakeSource) GetBlocks(ctx context.Context, fromLevel, toLevel int) (*[]BlockResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &[]BlockResponse{}, nil
+}
+
+func (f *fakeSource) GetDelegationsAt(ctx context.Context, level int) (*[]DelegationResponse, error) {
+	if f.levelErr != nil {
+		return nil, f.levelErr
+	}
+	return &f.byLevel, nil
+}
+
+func (f *fakeSource) Source() string {
+	return f.url
+}
+
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func delegation(id, level int, hash, sender string) DelegationResponse {
+	d := DelegationResponse{ID: id, Level: level, Hash: hash}
+	d.Sender.Address = sender
+	return d
+}
+
+func ids(page []DelegationResponse) []int {
+	var ids []int
+	for _, d := range page {
+		ids = append(ids, d.ID)
+	}
+	return ids
+}
+
+func TestMultiSource_FailsOverOnError(t *testing.T) {
+	primary := &fakeSource{url: "primary", head: 100, err: &StatusError{StatusCode: http.StatusBadGateway}}
+	mirror := &fakeSource{url: "mirror", head: 100, page: []DelegationResponse{{ID: 1, Level: 100}}}
+
+	multi := NewMultiSource(discardLogger, []TzktClientInterface{primary, mirror})
+	failovers := metrics.SourceFailovers.Value()
+
+	page, err := multi.GetDelegations(context.Background(), 0, Start{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(*page) != 1 {
+		t.Errorf("Expected the mirror's page, got %+v", *page)
+	}
+	if multi.Source() != "mirror" {
+		t.Errorf("Expected source 'mirror', got '%s'", multi.Source())
+	}
+	if metrics.SourceFailovers.Value() != failovers+1 {
+		t.Errorf("Expected one failover to be counted")
+	}
+
+	// the mirror stays in use once the primary recovers
+	primary.err = nil
+	if _, err := multi.GetDelegations(context.Background(), 1, Start{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if mirror.calls != 2 {
+		t.Errorf("Expected the mirror to serve both pages, got %d calls", mirror.calls)
+	}
+}
+
+func TestMultiSource_SkipsStaleSource(t *testing.T) {
+	primary := &fakeSource{url: "primary", head: 100}
+	mirror := &fakeSource{url: "mirror", head: 200}
+
+	multi := NewMultiSource(discardLogger, []TzktClientInterface{primary, mirror}, WithMaxLag(10))
+
+	if _, err := multi.GetDelegations(context.Background(), 0, Start{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if primary.calls != 0 || mirror.calls != 1 {
+		t.Errorf("Expected the stale primary to be skipped, got %d and %d calls", primary.calls, mirror.calls)
+	}
+}
+
+func TestMultiSource_StaleSourceAsLastResort(t *testing.T) {
+	primary := &fakeSource{url: "primary", head: 100}
+	mirror := &fakeSource{url: "mirror", head: 200, err: errors.New("connection refused")}
+
+	multi := NewMultiSource(discardLogger, []TzktClientInterface{primary, mirror})
+
+	if _, err := multi.GetDelegations(context.Background(), 0, Start{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if primary.calls != 1 {
+		t.Errorf("Expected the primary to be used when the mirror fails, got %d calls", primary.calls)
+	}
+}
+
+func TestMultiSource_AllFail(t *testing.T) {
+	retryErr := &RetryError{Attempts: 5, Class: ClassServer, Err: &StatusError{StatusCode: http.StatusBadGateway}}
+	primary := &fakeSource{url: "primary", err: retryErr}
+	mirror := &fakeSource{url: "mirror", err: &StatusError{StatusCode: http.StatusNotFound}}
+
+	multi := NewMultiSource(discardLogger, []TzktClientInterface{primary, mirror})
+
+	_, err := multi.GetDelegations(context.Background(), 0, Start{})
+	if err == nil {
+		t.Fatal("Expected an error when every source fails")
+	}
+	if !errors.Is(err, retryErr) {
+		t.Errorf("Expected the primary's error to be kept, got %v", err)
+	}
+	if !IsRetryable(err) {
+		t.Error("Expected the error to be retryable as one source failed transiently")
+	}
+}
+
+func TestMultiSource_CrossCheck(t *testing.T) {
+	page := []DelegationResponse{
+		{ID: 1, Level: 99, Hash: "ooOld", Amount: 10},
+		{ID: 2, Level: 100, Hash: "ooAgree", Amount: 1000},
+		{ID: 3, Level: 100, Hash: "ooAmount", Amount: 2000},
+		{ID: 4, Level: 100, Hash: "ooSender", Amount: 3000},
+		{ID: 5, Level: 100, Hash: "ooMissing", Amount: 4000},
+	}
+	page[1].Sender.Address = "tz1a"
+	page[2].Sender.Address = "tz1b"
+	page[3].Sender.Address = "tz1c"
+	page[4].Sender.Address = "tz1d"
+
+	verified := []DelegationResponse{
+		{ID: 200000, Level: 100, Hash: "ooAgree", Amount: 1000},
+		{ID: 200001, Level: 100, Hash: "ooAmount", Amount: 2500},
+		{ID: 200002, Level: 100, Hash: "ooSender", Amount: 3000},
+	}
+	verified[0].Sender.Address = "tz1a"
+	verified[1].Sender.Address = "tz1b"
+	verified[2].Sender.Address = "tz1x"
+
+	primary := &fakeSource{url: "primary", head: 100, page: page}
+	node := &fakeSource{url: "node", byLevel: verified}
+
+	multi := NewMultiSource(discardLogger, []TzktClientInterface{primary}, WithCrossCheck(node, 2))
+	disagreements := metrics.SourceDisagreements.Value()
+
+	// only every second page is checked
+	if _, err := multi.GetDelegations(context.Background(), 0, Start{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if metrics.SourceDisagreements.Value() != disagreements {
+		t.Errorf("Expected the first page not to be checked")
+	}
+
+	if _, err := multi.GetDelegations(context.Background(), 0, Start{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := metrics.SourceDisagreements.Value() - disagreements; got != 3 {
+		t.Errorf("Expected 3 disagreements (amount, sender, missing), got %d", got)
+	}
+}
+
+func TestMultiSource_CrossCheckFailureKeepsPage(t *testing.T) {
+	primary := &fakeSource{url: "primary", head: 100, page: []DelegationResponse{{ID: 1, Level: 100}}}
+	node := &fakeSource{url: "node", levelErr: errors.New("connection refused")}
+
+	multi := NewMultiSource(discardLogger, []TzktClientInterface{primary}, WithCrossCheck(node, 1))
+
+	page, err := multi.GetDelegations(context.Background(), 0, Start{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(*page) != 1 {
+		t.Errorf("Expected the page to be returned, got %+v", *page)
+	}
+}
+
+func TestMultiSource_FailsOverToOtherIDSpace(t *testing.T) {
+	primary := &fakeSource{url: "tzkt", head: 101, page: []DelegationResponse{
+		delegation(1000, 100, "ooA", "tz1a"),
+		delegation(1001, 100, "ooB", "tz1b"),
+	}}
+	node := &fakeSource{url: "node", space: "node", head: 101, page: []DelegationResponse{
+		delegation(100*NodeIDStride, 100, "ooA", "tz1a"),
+		delegation(100*NodeIDStride+1, 100, "ooB", "tz1b"),
+		delegation(100*NodeIDStride+2, 100, "ooC", "tz1c"),
+		delegation(101*NodeIDStride, 101, "ooD", "tz1d"),
+	}}
+
+	multi := NewMultiSource(discardLogger, []TzktClientInterface{primary, node})
+	if _, err := multi.GetDelegations(context.Background(), 0, Start{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// TzKT goes down with the level 100 only partly read
+	primary.err = &StatusError{StatusCode: http.StatusBadGateway}
+	page, err := multi.GetDelegations(context.Background(), 1001, Start{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if node.afterIDs[0] != 0 || node.froms[0].Level != 100 {
+		t.Errorf("Expected the node to be walked from level 100, got after ID %d from %+v", node.afterIDs[0], node.froms[0])
+	}
+	if got := ids(*page); !reflect.DeepEqual(got, []int{100*NodeIDStride + 2, 101 * NodeIDStride}) {
+		t.Errorf("Expected the delegations not read from TzKT, got %v", got)
+	}
+
+	// the node cursor is passed through to the node
+	if _, err := multi.GetDelegations(context.Background(), 101*NodeIDStride, Start{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if node.afterIDs[1] != 101*NodeIDStride {
+		t.Errorf("Expected the node cursor to be passed through, got %d", node.afterIDs[1])
+	}
+}
+
+func TestMultiSource_SkipsPagesAlreadyRead(t *testing.T) {
+	node := &fakeSource{url: "node", space: "node", head: 101, page: []DelegationResponse{
+		delegation(100*NodeIDStride, 100, "ooA", "tz1a"),
+	}}
+	primary := &fakeSource{url: "tzkt", head: 101, pages: [][]DelegationResponse{
+		{delegation(1000, 100, "ooA", "tz1a")},
+		{delegation(1001, 101, "ooB", "tz1b")},
+	}}
+
+	multi := NewMultiSource(discardLogger, []TzktClientInterface{node, primary})
+	if _, err := multi.GetDelegations(context.Background(), 0, Start{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	node.err = errors.New("connection refused")
+	page, err := multi.GetDelegations(context.Background(), 100*NodeIDStride, Start{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if got := ids(*page); !reflect.DeepEqual(got, []int{1001}) {
+		t.Errorf("Expected the page after the delegations already read, got %v", got)
+	}
+	if !reflect.DeepEqual(primary.afterIDs, []int{0, 1000}) {
+		t.Errorf("Expected TzKT to be paged from level 100 then after 1000, got %v", primary.afterIDs)
+	}
+}
+
+func TestMultiSource_ResolvesUnknownCursor(t *testing.T) {
+	primary := &fakeSource{url: "tzkt", head: 101, page: []DelegationResponse{
+		delegation(1000, 100, "ooA", "tz1a"),
+		delegation(1001, 100, "ooB", "tz1b"),
+	}}
+	resolve := func(ctx context.Context, afterID int) (Cursor, bool, error) {
+		if afterID != 100*NodeIDStride {
+			return Cursor{}, false, nil
+		}
+		return Cursor{Level: 100, Read: map[OperationKey]bool{{Hash: "ooA", Sender: "tz1a"}: true}}, true, nil
+	}
+
+	multi := NewMultiSource(discardLogger, []TzktClientInterface{primary}, WithCursorResolver(resolve))
+
+	// a checkpoint left by a node before a restart
+	page, err := multi.GetDelegations(context.Background(), 100*NodeIDStride, Start{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if primary.afterIDs[0] != 0 || primary.froms[0].Level != 100 {
+		t.Errorf("Expected TzKT to be read from level 100, got after ID %d from %+v", primary.afterIDs[0], primary.froms[0])
+	}
+	if got := ids(*page); !reflect.DeepEqual(got, []int{1001}) {
+		t.Errorf("Expected the delegation not stored yet, got %v", got)
+	}
+
+	// cursors the resolver does not know are passed through
+	if _, err := multi.GetDelegations(context.Background(), 7, Start{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if primary.afterIDs[1] != 7 {
+		t.Errorf("Expected an unknown cursor to be passed through, got %d", primary.afterIDs[1])
+	}
+}
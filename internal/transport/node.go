@@ -0,0 +1,343 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"tezos-delegation-service/internal/metrics"
+	"time"
+)
+
+// NodeIDStride spaces the synthetic IDs of the delegations read from a node: a delegation gets
+// level*NodeIDStride plus its position in the block, so IDs grow with the chain like TzKT ones.
+// They are not TzKT IDs: a MultiSource failing over between both resumes from levels instead.
+const NodeIDStride = 100_000
+
+const (
+	// managerPass is the validation pass of manager operations, delegations included.
+	managerPass = 3
+
+	defaultNodePageSize   = 1000
+	defaultNodePageLevels = 100
+)
+
+type nodeHeader struct {
+	Hash      string `json:"hash"`
+	Level     int    `json:"level"`
+	Timestamp string `json:"timestamp"`
+}
+
+type nodeResult struct {
+	Status           string `json:"status"`
+	ConsumedMilligas string `json:"consumed_milligas"`
+}
+
+type nodeContent struct {
+	Kind     string `json:"kind"`
+	Source   string `json:"source"`
+	Fee      string `json:"fee"`
+	Counter  string `json:"counter"`
+	Delegate string `json:"delegate"`
+	Metadata struct {
+		OperationResult          nodeResult `json:"operation_result"`
+		InternalOperationResults []struct {
+			Kind     string     `json:"kind"`
+			Source   string     `json:"source"`
+			Delegate string     `json:"delegate"`
+			Result   nodeResult `json:"result"`
+		} `json:"internal_operation_results"`
+	} `json:"metadata"`
+}
+
+type nodeOperation struct {
+	Hash     string        `json:"hash"`
+	Contents []nodeContent `json:"contents"`
+}
+
+// NodeClient reads delegations straight from the RPC of an Octez node, for when TzKT is down or
+// lagging. The node does not index operations, so pages are built by walking blocks level by level,
+// and the amount and previous baker of every delegation cost a request each.
+type NodeClient struct {
+	jsonClient
+	rpcURL     string
+	pageSize   int
+	pageLevels int
+}
+
+func NewNodeClient(rpcURL string, opts ...Option) *NodeClient {
+	c := &NodeClient{
+		jsonClient: jsonClient{
+			httpClient:     http.DefaultClient,
+			retry:          DefaultRetryPolicy,
+			requestTimeout: 30 * time.Second,
+			// our own node, no published limits to stay within
+			limiter:  NewRateLimiter(0, 1),
+			requests: metrics.NodeRequests,
+		},
+		rpcURL:     strings.TrimSuffix(rpcURL, "/"),
+		pageSize:   defaultNodePageSize,
+		pageLevels: defaultNodePageLevels,
+	}
+	for _, opt := range opts {
+		opt(&c.jsonClient)
+	}
+	return c
+}
+
+// Source returns the URL of the node RPC.
+func (c *NodeClient) Source() string {
+	return c.rpcURL
+}
+
+// IDSpace tells a MultiSource that the IDs of a node are its own, not TzKT ones.
+func (c *NodeClient) IDSpace() string {
+	return "node"
+}
+
+// GetDelegations walks the blocks from the one holding the afterID cursor until it found a page of
+// delegations, walked pageLevels levels or reached the head. Without a cursor the walk starts where
+// from says, or at genesis. A walk cut before the head without finding any delegation returns a
+// Scanned entry holding the cursor past the last level read.
+func (c *NodeClient) GetDelegations(ctx context.Context, afterID int, from Start) (*[]DelegationResponse, error) {
+	head, err := c.GetHead(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	level, walked := 1, 0
+	switch {
+	case afterID == 0:
+		if from.Timestamp != "" {
+			if level, err = c.levelAt(ctx, from.Timestamp, head.Level); err != nil {
+				return nil, err
+			}
+		}
+		level = max(level, from.Level)
+	case (afterID+1)%NodeIDStride == 0:
+		// the cursor of a Scanned entry ends its level
+		level = (afterID + 1) / NodeIDStride
+	default:
+		// the level of the cursor is read again for what follows it, which is not a level walked
+		level, walked = afterID/NodeIDStride, -1
+	}
+
+	page := []DelegationResponse{}
+	for ; level <= head.Level; level, walked = level+1, walked+1 {
+		// a page ends on a block boundary
+		if len(page) >= c.pageSize || walked >= c.pageLevels {
+			break
+		}
+
+		delegations, err := c.blockDelegations(ctx, level)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range delegations {
+			if d.ID > afterID {
+				page = append(page, d)
+			}
+		}
+	}
+
+	if len(page) == 0 && level <= head.Level {
+		scanned, err := c.scanned(ctx, level-1)
+		if err != nil {
+			return nil, err
+		}
+		page = append(page, scanned)
+	}
+	return &page, nil
+}
+
+// scanned returns the Scanned entry ending a walk at level.
+func (c *NodeClient) scanned(ctx context.Context, level int) (DelegationResponse, error) {
+	header, err := c.header(ctx, strconv.Itoa(level))
+	if err != nil {
+		return DelegationResponse{}, err
+	}
+	return DelegationResponse{
+		ID:        level*NodeIDStride + NodeIDStride - 1,
+		Timestamp: header.Timestamp,
+		Level:     level,
+		Block:     header.Hash,
+		Scanned:   true,
+	}, nil
+}
+
+// GetDelegationsAt returns every delegation of the block at level.
+func (c *NodeClient) GetDelegationsAt(ctx context.Context, level int) (*[]DelegationResponse, error) {
+	delegations, err := c.blockDelegations(ctx, level)
+	if err != nil {
+		return nil, err
+	}
+	return &delegations, nil
+}
+
+// GetHead returns the head of the main chain as seen by the node.
+func (c *NodeClient) GetHead(ctx context.Context) (*HeadResponse, error) {
+	header, err := c.header(ctx, "head")
+	if err != nil {
+		return nil, err
+	}
+	return &HeadResponse{Level: header.Level, Timestamp: header.Timestamp}, nil
+}
+
+// GetBlocks returns the hash of every block between fromLevel and toLevel inclusive.
+func (c *NodeClient) GetBlocks(ctx context.Context, fromLevel, toLevel int) (*[]BlockResponse, error) {
+	blocks := []BlockResponse{}
+	for level := fromLevel; level <= toLevel; level++ {
+		header, err := c.header(ctx, strconv.Itoa(level))
+		if err != nil {
+			var statusErr *StatusError
+			if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+				// above the head
+				break
+			}
+			return nil, err
+		}
+		blocks = append(blocks, BlockResponse{Level: header.Level, Hash: header.Hash})
+	}
+	return &blocks, nil
+}
+
+// blockDelegations returns the delegations of the block at level, in the order of the block.
+func (c *NodeClient) blockDelegations(ctx context.Context, level int) ([]DelegationResponse, error) {
+	header, err := c.header(ctx, strconv.Itoa(level))
+	if err != nil {
+		return nil, err
+	}
+
+	var passes [][]nodeOperation
+	if err := c.getJSON(ctx, c.blockURL(level)+"/operations", &passes); err != nil {
+		return nil, err
+	}
+	if len(passes) <= managerPass {
+		return nil, nil
+	}
+
+	var delegations []DelegationResponse
+	add := func(op nodeOperation, content nodeContent, source, delegate string, result nodeResult) error {
+		d := DelegationResponse{
+			ID:        level*NodeIDStride + len(delegations),
+			Timestamp: header.Timestamp,
+			Level:     level,
+			Block:     header.Hash,
+			Hash:      op.Hash,
+			Counter:   atoi(content.Counter),
+			Status:    result.Status,
+			BakerFee:  atoi(content.Fee),
+			// gas is reported in milligas, TzKT rounds it up
+			GasUsed: (atoi(result.ConsumedMilligas) + 999) / 1000,
+		}
+		d.Sender.Address = source
+		if delegate != "" {
+			d.NewDelegate = &Account{Address: delegate}
+		}
+
+		if err := c.enrich(ctx, &d, source); err != nil {
+			return err
+		}
+		delegations = append(delegations, d)
+		return nil
+	}
+
+	for _, op := range passes[managerPass] {
+		for _, content := range op.Contents {
+			if content.Kind == "delegation" {
+				if err := add(op, content, content.Source, content.Delegate, content.Metadata.OperationResult); err != nil {
+					return nil, err
+				}
+			}
+			// delegations emitted by contracts share the counter of the operation but pay no fee
+			for _, internal := range content.Metadata.InternalOperationResults {
+				if internal.Kind != "delegation" {
+					continue
+				}
+				internalContent := nodeContent{Counter: content.Counter}
+				if err := add(op, internalContent, internal.Source, internal.Delegate, internal.Result); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return delegations, nil
+}
+
+// enrich fills in what the operation itself does not tell: the delegated balance once the block
+// is applied, and the baker the delegator had before it.
+func (c *NodeClient) enrich(ctx context.Context, d *DelegationResponse, source string) error {
+	var balance string
+	if err := c.getJSON(ctx, c.contractURL(d.Level, source)+"/balance", &balance); err != nil {
+		return err
+	}
+	d.Amount = atoi(balance)
+
+	var prevDelegate string
+	err := c.getJSON(ctx, c.contractURL(d.Level-1, source)+"/delegate", &prevDelegate)
+	var statusErr *StatusError
+	switch {
+	case errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound:
+		// not delegated before
+	case err != nil:
+		return err
+	case prevDelegate != "":
+		d.PrevDelegate = &Account{Address: prevDelegate}
+	}
+	return nil
+}
+
+// levelAt finds the first level baked at or after the RFC3339 timestamp by bisecting block
+// headers.
+func (c *NodeClient) levelAt(ctx context.Context, timestamp string, headLevel int) (int, error) {
+	target, err := time.Parse(time.RFC3339, timestamp)
+	if err != nil {
+		return 0, err
+	}
+
+	low, high := 1, headLevel+1
+	for low < high {
+		mid := (low + high) / 2
+		header, err := c.header(ctx, strconv.Itoa(mid))
+		if err != nil {
+			return 0, err
+		}
+		baked, err := time.Parse(time.RFC3339, header.Timestamp)
+		if err != nil {
+			return 0, err
+		}
+		if baked.Before(target) {
+			low = mid + 1
+		} else {
+			high = mid
+		}
+	}
+	return low, nil
+}
+
+func (c *NodeClient) header(ctx context.Context, block string) (*nodeHeader, error) {
+	var header nodeHeader
+	if err := c.getJSON(ctx, c.rpcURL+"/chains/main/blocks/"+block+"/header", &header); err != nil {
+		return nil, err
+	}
+	return &header, nil
+}
+
+func (c *NodeClient) blockURL(level int) string {
+	return fmt.Sprintf("%s/chains/main/blocks/%d", c.rpcURL, level)
+}
+
+func (c *NodeClient) contractURL(level int, address string) string {
+	return fmt.Sprintf("%s/context/contracts/%s", c.blockURL(level), address)
+}
+
+// atoi reads the decimal strings the RPC uses for amounts and counters.
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+var _ TzktClientInterface = (*NodeClient)(nil)
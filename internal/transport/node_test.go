@@ -0,0 +1,177 @@
+package transport
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newNodeStandIn serves the RPC responses recorded under testdata/node, e.g.
+// /chains/main/blocks/2/header from testdata/node/chains/main/blocks/2/header.json. Anything not
+// recorded is a 404, as for a block above the head or a contract without a delegate.
+func newNodeStandIn(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := os.ReadFile(filepath.Join("testdata", "node", filepath.FromSlash(r.URL.Path)+".json"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestNodeClient_GetDelegations(t *testing.T) {
+	server := newNodeStandIn(t)
+	client := NewNodeClient(server.URL + "/")
+
+	delegations, err := client.GetDelegations(context.Background(), 0, Start{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(*delegations) != 3 {
+		t.Fatalf("Expected 3 delegations, got %d", len(*delegations))
+	}
+
+	first := (*delegations)[0]
+	if first.ID != 2*NodeIDStride || first.Level != 2 || first.Timestamp != "2024-06-01T12:00:15Z" {
+		t.Errorf("Unexpected position %+v", first)
+	}
+	if first.Sender.Address != "tz1VSUr8wwNhLAzempoch5d6hLRiTh8Cjcjb" || first.Amount != 1500000000 {
+		t.Errorf("Unexpected delegator or amount %+v", first)
+	}
+	if first.NewDelegate == nil || first.NewDelegate.Address != "tz1KqTpEZ7Yob7QbPE4Hy4Wo8fHG8LhKxZSx" {
+		t.Errorf("Expected new delegate tz1KqTpEZ7Yob7QbPE4Hy4Wo8fHG8LhKxZSx, got %+v", first.NewDelegate)
+	}
+	if first.PrevDelegate != nil {
+		t.Errorf("Expected no previous delegate, got %+v", first.PrevDelegate)
+	}
+	if first.Hash != "opGDtpWrN9b3NKHizKS7wXtMrbAobZXNEa9xJS6pr8dY2HsVy2p" || first.Block != "BLhDGqTHWU3Cx9eqzACMDRdkvgL9GXZvtM1bEQfUXhKVa1aVPnv" {
+		t.Errorf("Unexpected hashes %+v", first)
+	}
+	if first.Counter != 7 || first.BakerFee != 397 || first.GasUsed != 1000 || first.Status != "applied" {
+		t.Errorf("Unexpected operation details %+v", first)
+	}
+
+	failed := (*delegations)[1]
+	if failed.ID != 3*NodeIDStride || failed.Status != "failed" {
+		t.Errorf("Expected the failed redelegation, got %+v", failed)
+	}
+	if failed.PrevDelegate == nil || failed.PrevDelegate.Address != "tz1KqTpEZ7Yob7QbPE4Hy4Wo8fHG8LhKxZSx" {
+		t.Errorf("Expected previous delegate tz1KqTpEZ7Yob7QbPE4Hy4Wo8fHG8LhKxZSx, got %+v", failed.PrevDelegate)
+	}
+
+	internal := (*delegations)[2]
+	if internal.ID != 3*NodeIDStride+1 || internal.Sender.Address != "KT1BEqzn5Wx8uJrZNvuS9DVHmLvG9td3fDLi" {
+		t.Errorf("Expected the internal undelegation, got %+v", internal)
+	}
+	if internal.NewDelegate != nil || internal.PrevDelegate == nil || internal.BakerFee != 0 || internal.Counter != 8 {
+		t.Errorf("Unexpected internal undelegation %+v", internal)
+	}
+}
+
+func TestNodeClient_GetDelegations_Pages(t *testing.T) {
+	server := newNodeStandIn(t)
+	client := NewNodeClient(server.URL)
+	client.pageLevels = 1
+
+	scanned, err := client.GetDelegations(context.Background(), 0, Start{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	// the empty first block ends the walk, the cursor moves past it all the same
+	if len(*scanned) != 1 || !(*scanned)[0].Scanned || (*scanned)[0].Level != 1 || (*scanned)[0].Timestamp != "2024-06-01T12:00:00Z" {
+		t.Fatalf("Expected level 1 scanned, got %+v", *scanned)
+	}
+
+	first, err := client.GetDelegations(context.Background(), (*scanned)[0].ID, Start{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(*first) != 1 || (*first)[0].Level != 2 || (*first)[0].Scanned {
+		t.Fatalf("Expected the delegation of level 2, got %+v", *first)
+	}
+
+	second, err := client.GetDelegations(context.Background(), (*first)[0].ID, Start{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(*second) != 2 || (*second)[0].Level != 3 {
+		t.Fatalf("Expected the delegations of level 3, got %+v", *second)
+	}
+
+	last, err := client.GetDelegations(context.Background(), (*second)[1].ID, Start{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(*last) != 0 {
+		t.Errorf("Expected an empty page at the head, got %+v", *last)
+	}
+}
+
+func TestNodeClient_GetDelegations_FromTimestamp(t *testing.T) {
+	server := newNodeStandIn(t)
+	client := NewNodeClient(server.URL)
+
+	delegations, err := client.GetDelegations(context.Background(), 0, Start{Timestamp: "2024-06-01T12:00:20Z"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(*delegations) != 2 || (*delegations)[0].Level != 3 {
+		t.Errorf("Expected the delegations of level 3, got %+v", *delegations)
+	}
+}
+
+func TestNodeClient_GetDelegations_FromLevel(t *testing.T) {
+	server := newNodeStandIn(t)
+	client := NewNodeClient(server.URL)
+
+	delegations, err := client.GetDelegations(context.Background(), 0, Start{Level: 3})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(*delegations) != 2 || (*delegations)[0].Level != 3 {
+		t.Errorf("Expected the delegations of level 3, got %+v", *delegations)
+	}
+}
+
+func TestNodeClient_GetHead(t *testing.T) {
+	server := newNodeStandIn(t)
+	client := NewNodeClient(server.URL)
+
+	head, err := client.GetHead(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if head.Level != 3 || head.Timestamp != "2024-06-01T12:00:30Z" {
+		t.Errorf("Unexpected head %+v", head)
+	}
+}
+
+func TestNodeClient_GetBlocks(t *testing.T) {
+	server := newNodeStandIn(t)
+	client := NewNodeClient(server.URL)
+
+	blocks, err := client.GetBlocks(context.Background(), 2, 5)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := []BlockResponse{
+		{Level: 2, Hash: "BLhDGqTHWU3Cx9eqzACMDRdkvgL9GXZvtM1bEQfUXhKVa1aVPnv"},
+		{Level: 3, Hash: "BMQvr8PGw8YPctDQNdbk3oVtBKzdU5UbhNbdPHyFB6L3rqGS1AV"},
+	}
+	if len(*blocks) != len(expected) || (*blocks)[0] != expected[0] || (*blocks)[1] != expected[1] {
+		t.Errorf("Expected blocks %+v, got %+v", expected, *blocks)
+	}
+}
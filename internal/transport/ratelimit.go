@@ -0,0 +1,108 @@
+package transport
+
+import (
+	"context"
+	"sync"
+	"tezos-delegation-service/internal/metrics"
+	"time"
+)
+
+// DefaultRequestsPerSecond and DefaultBurst stay within the published limits of the public TzKT API.
+const (
+	DefaultRequestsPerSecond = 10
+	DefaultBurst             = 10
+)
+
+// RateLimiterStats reports how much the limiter throttled its callers.
+type RateLimiterStats struct {
+	Requests  int64
+	Throttled int64
+	TotalWait time.Duration
+}
+
+// RateLimiter is a token bucket. Share one instance between clients to bound their combined rate.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	stats  RateLimiterStats
+}
+
+func NewRateLimiter(requestsPerSecond float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:   requestsPerSecond,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until a request may be sent or ctx is done.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	if l.rate <= 0 {
+		return nil
+	}
+
+	wait := l.reserve()
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		l.cancel()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// reserve takes a token, possibly borrowing it from the future, and returns how long the caller
+// must wait for it.
+func (l *RateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	l.tokens--
+	l.stats.Requests++
+	if l.tokens >= 0 {
+		return 0
+	}
+
+	wait := time.Duration(-l.tokens / l.rate * float64(time.Second))
+	l.stats.Throttled++
+	l.stats.TotalWait += wait
+	metrics.TzktRateLimitWaits.Add(1)
+	metrics.TzktRateLimitWaitSeconds.Add(wait.Seconds())
+	return wait
+}
+
+// cancel gives back a token reserved by a caller that stopped waiting.
+func (l *RateLimiter) cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.tokens++
+}
+
+func (l *RateLimiter) Stats() RateLimiterStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.stats
+}
@@ -0,0 +1,112 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Burst(t *testing.T) {
+	limiter := NewRateLimiter(1, 3)
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("Expected the burst to go through immediately, took %s", elapsed)
+	}
+
+	stats := limiter.Stats()
+	if stats.Requests != 3 || stats.Throttled != 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestRateLimiter_Throttles(t *testing.T) {
+	limiter := NewRateLimiter(20, 1)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	// one token up front, then one every 50ms
+	if elapsed := time.Since(start); elapsed < 190*time.Millisecond {
+		t.Errorf("Expected requests to be spread over ~200ms, took %s", elapsed)
+	}
+
+	stats := limiter.Stats()
+	if stats.Requests != 5 || stats.Throttled != 4 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if stats.TotalWait <= 0 {
+		t.Errorf("Expected time spent waiting to be recorded, got %s", stats.TotalWait)
+	}
+}
+
+func TestRateLimiter_Cancelled(t *testing.T) {
+	limiter := NewRateLimiter(0.1, 1)
+	limiter.Wait(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := limiter.Wait(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestRateLimiter_Disabled(t *testing.T) {
+	limiter := NewRateLimiter(0, 0)
+
+	for i := 0; i < 100; i++ {
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	if stats := limiter.Stats(); stats.Throttled != 0 {
+		t.Errorf("Expected no throttling, got %+v", stats)
+	}
+}
+
+func TestTzktClient_SharedRateLimiter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode([]DelegationResponse{})
+	}))
+	defer server.Close()
+
+	limiter := NewRateLimiter(20, 1)
+	first := NewTzktClient(server.URL, WithRateLimiter(limiter))
+	second := NewTzktClient(server.URL, WithRateLimiter(limiter))
+
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		if _, err := first.GetDelegations(context.Background(), 0, Start{}); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if _, err := second.GetDelegations(context.Background(), 0, Start{}); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+	}
+
+	if elapsed := time.Since(start); elapsed < 140*time.Millisecond {
+		t.Errorf("Expected both clients to share the 20 rps budget, took %s", elapsed)
+	}
+
+	if stats := limiter.Stats(); stats.Requests != 4 {
+		t.Errorf("Expected 4 requests through the shared limiter, got %d", stats.Requests)
+	}
+}
@@ -0,0 +1,157 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// ErrorClass tells whether a failed TzKT request is worth retrying.
+type ErrorClass int
+
+const (
+	// ClassPermanent errors (4xx, malformed responses) fail the same way when retried.
+	ClassPermanent ErrorClass = iota
+	// ClassNetwork errors happen before a response is received (DNS, refused, reset, timeout).
+	ClassNetwork
+	// ClassRateLimited is a 429 from TzKT.
+	ClassRateLimited
+	// ClassServer is a 5xx from TzKT.
+	ClassServer
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case ClassNetwork:
+		return "network"
+	case ClassRateLimited:
+		return "rate_limited"
+	case ClassServer:
+		return "server"
+	default:
+		return "permanent"
+	}
+}
+
+// RetryPolicy configures the capped exponential backoff between attempts.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+}
+
+// StatusError is returned when TzKT answers with a non-200 status.
+type StatusError struct {
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.StatusCode)
+}
+
+// RetryError is returned once a transient error persisted through every attempt.
+type RetryError struct {
+	Attempts int
+	Class    ErrorClass
+	Err      error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("giving up after %d attempts (%s): %v", e.Attempts, e.Class, e.Err)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// IsRetryable reports whether err is transient, i.e. the same request may succeed later.
+func IsRetryable(err error) bool {
+	var retryErr *RetryError
+	if errors.As(err, &retryErr) {
+		return true
+	}
+	return Classify(err) != ClassPermanent
+}
+
+// Classify tells which ErrorClass a TzKT request error belongs to.
+func Classify(err error) ErrorClass {
+	if errors.Is(err, context.Canceled) {
+		return ClassPermanent
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		switch {
+		case statusErr.StatusCode == http.StatusTooManyRequests:
+			return ClassRateLimited
+		case statusErr.StatusCode >= 500:
+			return ClassServer
+		default:
+			return ClassPermanent
+		}
+	}
+
+	var urlErr *url.Error
+	var netErr net.Error
+	if errors.As(err, &urlErr) || errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ClassNetwork
+	}
+
+	return ClassPermanent
+}
+
+// backoff returns the delay before the given retry (1-based), using full jitter.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	ceiling := p.MaxDelay
+	if shift := attempt - 1; shift < 32 && p.BaseDelay<<shift < p.MaxDelay {
+		ceiling = p.BaseDelay << shift
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(int64(ceiling) + 1))
+}
+
+// delay picks the wait before the next attempt, honouring a Retry-After sent by TzKT.
+func (p RetryPolicy) delay(attempt int, err error) time.Duration {
+	wait := p.backoff(attempt)
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > wait {
+		wait = statusErr.RetryAfter
+	}
+	if wait > p.MaxDelay {
+		wait = p.MaxDelay
+	}
+	return wait
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an HTTP date.
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
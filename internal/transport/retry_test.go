@@ -0,0 +1,171 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var fastRetry = RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 5 * time.Millisecond}
+
+func TestTzktClient_RetriesTransientErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+	}{
+		{name: "server error", status: http.StatusServiceUnavailable},
+		{name: "rate limited", status: http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests atomic.Int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if requests.Add(1) < 3 {
+					w.WriteHeader(tt.status)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				json.NewEncoder(w).Encode([]DelegationResponse{{ID: 1}})
+			}))
+			defer server.Close()
+
+			client := NewTzktClient(server.URL, WithRetryPolicy(fastRetry))
+
+			results, err := client.GetDelegations(context.Background(), 0, Start{})
+			if err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+
+			if len(*results) != 1 {
+				t.Errorf("Expected 1 result, got %d", len(*results))
+			}
+
+			if requests.Load() != 3 {
+				t.Errorf("Expected 3 requests, got %d", requests.Load())
+			}
+		})
+	}
+}
+
+func TestTzktClient_DoesNotRetryClientErrors(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	client := NewTzktClient(server.URL, WithRetryPolicy(fastRetry))
+
+	_, err := client.GetDelegations(context.Background(), 0, Start{})
+
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected a 400 StatusError, got %v", err)
+	}
+
+	var retryErr *RetryError
+	if errors.As(err, &retryErr) {
+		t.Error("Expected client errors not to be retried")
+	}
+
+	if requests.Load() != 1 {
+		t.Errorf("Expected 1 request, got %d", requests.Load())
+	}
+}
+
+func TestTzktClient_RetryExhausted(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	client := NewTzktClient(server.URL, WithRetryPolicy(fastRetry))
+
+	_, err := client.GetDelegations(context.Background(), 0, Start{})
+
+	var retryErr *RetryError
+	if !errors.As(err, &retryErr) {
+		t.Fatalf("Expected a RetryError, got %v", err)
+	}
+	if retryErr.Attempts != 3 || retryErr.Class != ClassServer {
+		t.Errorf("Unexpected RetryError %+v", retryErr)
+	}
+	if !IsRetryable(err) {
+		t.Error("Expected exhausted transient errors to be retryable later")
+	}
+
+	if requests.Load() != 3 {
+		t.Errorf("Expected 3 requests, got %d", requests.Load())
+	}
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected ErrorClass
+	}{
+		{name: "rate limited", err: &StatusError{StatusCode: 429}, expected: ClassRateLimited},
+		{name: "server error", err: &StatusError{StatusCode: 502}, expected: ClassServer},
+		{name: "client error", err: &StatusError{StatusCode: 404}, expected: ClassPermanent},
+		{name: "network error", err: &url.Error{Op: "Get", URL: "http://tzkt", Err: errors.New("connection refused")}, expected: ClassNetwork},
+		{name: "wrapped status error", err: fmt.Errorf("fetch: %w", &StatusError{StatusCode: 500}), expected: ClassServer},
+		{name: "decode error", err: &json.SyntaxError{}, expected: ClassPermanent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if class := Classify(tt.err); class != tt.expected {
+				t.Errorf("Expected class %s, got %s", tt.expected, class)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 5, BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+
+	for attempt := 1; attempt <= 10; attempt++ {
+		ceiling := policy.BaseDelay << (attempt - 1)
+		if ceiling > policy.MaxDelay {
+			ceiling = policy.MaxDelay
+		}
+		if delay := policy.delay(attempt, errors.New("boom")); delay < 0 || delay > ceiling {
+			t.Errorf("Attempt %d: expected delay within [0, %s], got %s", attempt, ceiling, delay)
+		}
+	}
+
+	// Retry-After takes precedence over a shorter backoff, within MaxDelay
+	if delay := policy.delay(1, &StatusError{StatusCode: 429, RetryAfter: 500 * time.Millisecond}); delay != 500*time.Millisecond {
+		t.Errorf("Expected Retry-After delay of 500ms, got %s", delay)
+	}
+	if delay := policy.delay(1, &StatusError{StatusCode: 429, RetryAfter: time.Minute}); delay != time.Second {
+		t.Errorf("Expected Retry-After capped at 1s, got %s", delay)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if wait := parseRetryAfter("3"); wait != 3*time.Second {
+		t.Errorf("Expected 3s, got %s", wait)
+	}
+
+	date := time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat)
+	if wait := parseRetryAfter(date); wait <= 0 || wait > 10*time.Second {
+		t.Errorf("Expected up to 10s, got %s", wait)
+	}
+
+	if wait := parseRetryAfter("soon"); wait != 0 {
+		t.Errorf("Expected 0 for invalid header, got %s", wait)
+	}
+}
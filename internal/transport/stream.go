@@ -0,0 +1,233 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// TzKT pushes events through a SignalR hub at /v1/ws. Messages use the SignalR JSON protocol:
+// JSON records terminated by a record separator, tagged with a message type.
+const (
+	recordSeparator = 0x1e
+
+	invocationMessage = 1
+	completionMessage = 3
+	pingMessage       = 6
+	closeMessage      = 7
+)
+
+// StreamEventType tells what a TzKT operations event carries.
+type StreamEventType int
+
+const (
+	// StreamState confirms the subscription and tells the level the hub is at.
+	StreamState StreamEventType = iota
+	// StreamData carries the delegations of a new block.
+	StreamData
+	// StreamReorg tells that the chain was rolled back to State.
+	StreamReorg
+)
+
+// StreamEvent is an event of the TzKT operations channel.
+type StreamEvent struct {
+	Type  StreamEventType      `json:"type"`
+	State int                  `json:"state"`
+	Data  []DelegationResponse `json:"data"`
+}
+
+type hubMessage struct {
+	Type         int               `json:"type"`
+	Target       string            `json:"target,omitempty"`
+	Arguments    []json.RawMessage `json:"arguments,omitempty"`
+	InvocationID string            `json:"invocationId,omitempty"`
+	Error        string            `json:"error,omitempty"`
+}
+
+// DelegationStream delivers delegations as TzKT indexes them.
+type DelegationStream interface {
+	Subscribe(ctx context.Context, events chan<- StreamEvent) error
+}
+
+type TzktStream struct {
+	wsURL        string
+	dialer       *websocket.Dialer
+	pingInterval time.Duration
+	readTimeout  time.Duration
+}
+
+type StreamOption func(*TzktStream)
+
+// WithPingInterval sets how often the stream tells the hub it is alive. The hub drops clients
+// silent for 30 seconds.
+func WithPingInterval(interval time.Duration) StreamOption {
+	return func(s *TzktStream) {
+		s.pingInterval = interval
+	}
+}
+
+// WithReadTimeout sets how long the stream waits for a message, pings included, before
+// considering the connection dead.
+func WithReadTimeout(timeout time.Duration) StreamOption {
+	return func(s *TzktStream) {
+		s.readTimeout = timeout
+	}
+}
+
+func NewTzktStream(wsURL string, opts ...StreamOption) *TzktStream {
+	s := &TzktStream{
+		wsURL:        wsURL,
+		dialer:       websocket.DefaultDialer,
+		pingInterval: 15 * time.Second,
+		readTimeout:  time.Minute,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Subscribe connects to the TzKT hub, subscribes to delegations and sends every event to events
+// until the connection drops or ctx is done. It always returns a non-nil error.
+func (s *TzktStream) Subscribe(ctx context.Context, events chan<- StreamEvent) error {
+	conn, _, err := s.dialer.DialContext(ctx, s.wsURL, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// closing the connection unblocks the read loop once ctx is done
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	var writeMu sync.Mutex
+	write := func(msg any) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return writeRecord(conn, msg)
+	}
+
+	if err := s.handshake(conn); err != nil {
+		return streamError(ctx, err)
+	}
+
+	args, err := json.Marshal(map[string]string{"types": "delegation"})
+	if err != nil {
+		return err
+	}
+	subscribe := hubMessage{
+		Type:         invocationMessage,
+		Target:       "SubscribeToOperations",
+		Arguments:    []json.RawMessage{args},
+		InvocationID: "1",
+	}
+	if err := write(subscribe); err != nil {
+		return streamError(ctx, err)
+	}
+
+	go func() {
+		ticker := time.NewTicker(s.pingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := write(hubMessage{Type: pingMessage}); err != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(s.readTimeout))
+		_, payload, err := conn.ReadMessage()
+		if err != nil {
+			return streamError(ctx, err)
+		}
+
+		for _, record := range bytes.Split(payload, []byte{recordSeparator}) {
+			if len(record) == 0 {
+				continue
+			}
+			var msg hubMessage
+			if err := json.Unmarshal(record, &msg); err != nil {
+				return err
+			}
+
+			switch msg.Type {
+			case completionMessage:
+				if msg.Error != "" {
+					return fmt.Errorf("subscription failed: %s", msg.Error)
+				}
+			case closeMessage:
+				return fmt.Errorf("hub closed the connection: %s", msg.Error)
+			case invocationMessage:
+				if msg.Target != "operations" {
+					continue
+				}
+				for _, arg := range msg.Arguments {
+					var event StreamEvent
+					if err := json.Unmarshal(arg, &event); err != nil {
+						return err
+					}
+					select {
+					case events <- event:
+					case <-ctx.Done():
+						return ctx.Err()
+					}
+				}
+			}
+		}
+	}
+}
+
+// handshake agrees on the JSON protocol with the hub.
+func (s *TzktStream) handshake(conn *websocket.Conn) error {
+	if err := writeRecord(conn, map[string]any{"protocol": "json", "version": 1}); err != nil {
+		return err
+	}
+
+	conn.SetReadDeadline(time.Now().Add(s.readTimeout))
+	_, payload, err := conn.ReadMessage()
+	if err != nil {
+		return err
+	}
+
+	var response struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(bytes.TrimSuffix(payload, []byte{recordSeparator}), &response); err != nil {
+		return err
+	}
+	if response.Error != "" {
+		return errors.New("handshake failed: " + response.Error)
+	}
+	return nil
+}
+
+// streamError reports ctx.Err() rather than the read error caused by closing the connection on
+// cancellation.
+func streamError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func writeRecord(conn *websocket.Conn, msg any) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(websocket.TextMessage, append(payload, recordSeparator))
+}
+
+var _ DelegationStream = (*TzktStream)(nil)
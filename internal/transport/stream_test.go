@@ -0,0 +1,99 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"tezos-delegation-service/internal/transport/tzkttest"
+)
+
+func receive(t *testing.T, events <-chan StreamEvent) StreamEvent {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected a stream event")
+		return StreamEvent{}
+	}
+}
+
+func TestTzktStream_Subscribe(t *testing.T) {
+	hub := tzkttest.NewHub(100)
+	defer hub.Close()
+
+	stream := NewTzktStream(hub.URL())
+	events := make(chan StreamEvent, 10)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- stream.Subscribe(ctx, events)
+	}()
+
+	state := receive(t, events)
+	if state.Type != StreamState || state.State != 100 {
+		t.Errorf("Expected state event at level 100, got %+v", state)
+	}
+
+	hub.Publish(101, []DelegationResponse{{ID: 7, Level: 101, Amount: 1000, Status: "applied"}})
+	data := receive(t, events)
+	if data.Type != StreamData || data.State != 101 {
+		t.Errorf("Expected data event at level 101, got %+v", data)
+	}
+	if len(data.Data) != 1 || data.Data[0].ID != 7 || data.Data[0].Amount != 1000 {
+		t.Errorf("Unexpected delegations %+v", data.Data)
+	}
+
+	hub.Reorg(100)
+	reorg := receive(t, events)
+	if reorg.Type != StreamReorg || reorg.State != 100 {
+		t.Errorf("Expected reorg event to level 100, got %+v", reorg)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected Subscribe to return once cancelled")
+	}
+}
+
+func TestTzktStream_Disconnect(t *testing.T) {
+	hub := tzkttest.NewHub(100)
+	defer hub.Close()
+
+	stream := NewTzktStream(hub.URL())
+	events := make(chan StreamEvent, 10)
+	done := make(chan error, 1)
+	go func() {
+		done <- stream.Subscribe(context.Background(), events)
+	}()
+
+	receive(t, events)
+	hub.Disconnect()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Expected an error once disconnected")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected Subscribe to return once disconnected")
+	}
+}
+
+func TestTzktStream_DialError(t *testing.T) {
+	hub := tzkttest.NewHub(100)
+	url := hub.URL()
+	hub.Close()
+
+	stream := NewTzktStream(url)
+	if err := stream.Subscribe(context.Background(), make(chan StreamEvent)); err == nil {
+		t.Error("Expected an error when the hub is unreachable")
+	}
+}
@@ -4,12 +4,20 @@ package transport
 // It handles the communication with the Tezos API to fetch delegation data.
 
 import (
-	"encoding/json"
-	"fmt"
+	"context"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"tezos-delegation-service/internal/metrics"
+	"time"
 )
 
+type Account struct {
+	Alias   string `json:"alias"`
+	Address string `json:"address"`
+}
+
 type DelegationResponse struct {
 	ID        int    `json:"id"`
 	Timestamp string `json:"timestamp"`
@@ -17,57 +25,217 @@ type DelegationResponse struct {
 	Sender    struct {
 		Address string `json:"address"`
 	} `json:"sender"`
-	Level int `json:"level"`
+	Level        int      `json:"level"`
+	Block        string   `json:"block"`
+	Hash         string   `json:"hash"`
+	Counter      int      `json:"counter"`
+	PrevDelegate *Account `json:"prevDelegate"`
+	NewDelegate  *Account `json:"newDelegate"`
+	Status       string   `json:"status"`
+	BakerFee     int      `json:"bakerFee"`
+	GasUsed      int      `json:"gasUsed"`
+	// Scanned marks an entry that is not a delegation. A source giving up its walk before the
+	// head without finding any ends the page with one, so that the cursor still moves past the
+	// levels it read.
+	Scanned bool `json:"-"`
+}
+
+// Start bounds where a walk over delegations begins when there is no cursor yet: at the first
+// level baked at or after Timestamp, at Level, or at the later of both. The zero value starts at
+// genesis.
+type Start struct {
+	Timestamp string
+	Level     int
+}
+
+type HeadResponse struct {
+	Level     int    `json:"level"`
+	Timestamp string `json:"timestamp"`
+}
+
+type BlockResponse struct {
+	Level int    `json:"level"`
+	Hash  string `json:"hash"`
+}
+
+// ProtocolResponse is a protocol as TzKT publishes it, with the constants cycles derive from.
+type ProtocolResponse struct {
+	Code            int    `json:"code"`
+	Hash            string `json:"hash"`
+	FirstLevel      int    `json:"firstLevel"`
+	LastLevel       int    `json:"lastLevel"`
+	FirstCycle      int    `json:"firstCycle"`
+	FirstCycleLevel int    `json:"firstCycleLevel"`
+	Constants       struct {
+		BlocksPerCycle int `json:"blocksPerCycle"`
+	} `json:"constants"`
 }
 
 type TzktClient struct {
+	jsonClient
 	apiURL string
 }
 
 type TzktClientInterface interface {
-	GetDelegations(offset int, fromTimestamp string) (*[]DelegationResponse, error)
+	GetDelegations(ctx context.Context, afterID int, from Start) (*[]DelegationResponse, error)
+	GetHead(ctx context.Context) (*HeadResponse, error)
+	GetBlocks(ctx context.Context, fromLevel, toLevel int) (*[]BlockResponse, error)
+	Source() string
 }
 
-func NewTzktClient(apiURL string) *TzktClient {
-	return &TzktClient{
+func NewTzktClient(apiURL string, opts ...Option) *TzktClient {
+	c := &TzktClient{
+		jsonClient: jsonClient{
+			httpClient:     http.DefaultClient,
+			retry:          DefaultRetryPolicy,
+			requestTimeout: 30 * time.Second,
+			limiter:        NewRateLimiter(DefaultRequestsPerSecond, DefaultBurst),
+			requests:       metrics.TzktRequests,
+		},
 		apiURL: apiURL,
 	}
+	for _, opt := range opts {
+		opt(&c.jsonClient)
+	}
+	return c
+}
+
+// Source returns the URL delegations are fetched from.
+func (c *TzktClient) Source() string {
+	return c.apiURL
 }
 
-func (c *TzktClient) GetDelegations(offset int, fromTimestamp string) (*[]DelegationResponse, error) {
+// GetDelegations returns the next page of delegations ordered by ID, starting after the afterID
+// cursor. Paging on the operation ID rather than timestamps or offsets is gap-free: many
+// delegations share a block timestamp and new operations shift offsets. from optionally bounds
+// where the walk starts.
+func (c *TzktClient) GetDelegations(ctx context.Context, afterID int, from Start) (*[]DelegationResponse, error) {
 	u, err := url.Parse(c.apiURL)
 	if err != nil {
 		return nil, err
 	}
 
 	query := u.Query()
-	if fromTimestamp != "" {
-		query.Add("timestamp.gt", fromTimestamp)
+	query.Set("sort.asc", "id")
+	if afterID > 0 {
+		query.Add("id.gt", strconv.Itoa(afterID))
+	}
+	if from.Timestamp != "" {
+		query.Add("timestamp.ge", from.Timestamp)
 	}
-	if offset > 0 {
-		query.Add("offset", fmt.Sprintf("%d", offset))
+	if from.Level > 0 {
+		query.Add("level.ge", strconv.Itoa(from.Level))
 	}
 
 	u.RawQuery = query.Encode()
 
-	baseUrl := u.String()
+	var entry []DelegationResponse
+	if err := c.getJSON(ctx, u.String(), &entry); err != nil {
+		return nil, err
+	}
+
+	return &entry, nil
+}
 
-	resp, err := http.Get(baseUrl)
+// GetDelegationsAt returns every delegation of the block at level.
+func (c *TzktClient) GetDelegationsAt(ctx context.Context, level int) (*[]DelegationResponse, error) {
+	u, err := url.Parse(c.apiURL)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
-	}
+	query := u.Query()
+	query.Set("level", strconv.Itoa(level))
+	query.Set("sort.asc", "id")
+	u.RawQuery = query.Encode()
 
 	var entry []DelegationResponse
-	if err := json.NewDecoder(resp.Body).Decode(&entry); err != nil {
+	if err := c.getJSON(ctx, u.String(), &entry); err != nil {
 		return nil, err
 	}
 
 	return &entry, nil
 }
 
-var _ TzktClientInterface = (*TzktClient)(nil)
+// GetHead returns the current head of the chain as indexed by TzKT.
+func (c *TzktClient) GetHead(ctx context.Context) (*HeadResponse, error) {
+	u, err := c.endpoint("/head")
+	if err != nil {
+		return nil, err
+	}
+
+	var head HeadResponse
+	if err := c.getJSON(ctx, u.String(), &head); err != nil {
+		return nil, err
+	}
+
+	return &head, nil
+}
+
+// GetBlocks returns the level and hash of the blocks TzKT currently has between fromLevel and
+// toLevel inclusive, ordered by level.
+func (c *TzktClient) GetBlocks(ctx context.Context, fromLevel, toLevel int) (*[]BlockResponse, error) {
+	u, err := c.endpoint("/blocks")
+	if err != nil {
+		return nil, err
+	}
+
+	query := u.Query()
+	query.Set("level.ge", strconv.Itoa(fromLevel))
+	query.Set("level.le", strconv.Itoa(toLevel))
+	query.Set("sort.asc", "level")
+	query.Set("select", "level,hash")
+	query.Set("limit", strconv.Itoa(toLevel-fromLevel+1))
+	u.RawQuery = query.Encode()
+
+	var blocks []BlockResponse
+	if err := c.getJSON(ctx, u.String(), &blocks); err != nil {
+		return nil, err
+	}
+
+	return &blocks, nil
+}
+
+// GetProtocols returns every protocol the chain ran, ordered by first level.
+func (c *TzktClient) GetProtocols(ctx context.Context) (*[]ProtocolResponse, error) {
+	u, err := c.endpoint("/protocols")
+	if err != nil {
+		return nil, err
+	}
+
+	query := u.Query()
+	query.Set("sort.asc", "firstLevel")
+	query.Set("limit", "1000")
+	u.RawQuery = query.Encode()
+
+	var protocols []ProtocolResponse
+	if err := c.getJSON(ctx, u.String(), &protocols); err != nil {
+		return nil, err
+	}
+
+	return &protocols, nil
+}
+
+// endpoint builds the URL of another TzKT endpoint, which lives next to the operations one,
+// e.g. /v1/operations/delegations -> /v1/head.
+func (c *TzktClient) endpoint(path string) (*url.URL, error) {
+	u, err := url.Parse(c.apiURL)
+	if err != nil {
+		return nil, err
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/operations/delegations") + path
+	u.RawQuery = ""
+	return u, nil
+}
+
+// ProtocolSource lists the protocols of the chain, which only TzKT indexes: a node only knows the
+// constants of the protocols it runs.
+type ProtocolSource interface {
+	GetProtocols(ctx context.Context) (*[]ProtocolResponse, error)
+}
+
+var (
+	_ TzktClientInterface = (*TzktClient)(nil)
+	_ ProtocolSource      = (*TzktClient)(nil)
+)
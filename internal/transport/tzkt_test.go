@@ -1,10 +1,14 @@
 package transport
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestNewTzktClient(t *testing.T) {
@@ -51,7 +55,7 @@ func TestTzktClient_GetDelegations_Success(t *testing.T) {
 
 	client := NewTzktClient(server.URL)
 
-	results, err := client.GetDelegations(10, "2023-01-01T00:00:00Z")
+	results, err := client.GetDelegations(context.Background(), 10, Start{Timestamp: "2023-01-01T00:00:00Z"})
 
 	if err != nil {
 		t.Errorf("Expected no error, got %v", err)
@@ -106,15 +110,15 @@ func TestTzktClient_GetDelegations_HTTPError(t *testing.T) {
 	}))
 	defer server.Close()
 
-	client := NewTzktClient(server.URL)
+	client := NewTzktClient(server.URL, WithRetryPolicy(RetryPolicy{MaxAttempts: 2}))
 
-	results, err := client.GetDelegations(0, "")
+	results, err := client.GetDelegations(context.Background(), 0, Start{})
 
 	if err == nil {
-		t.Error("Expected error, got nil")
+		t.Fatal("Expected error, got nil")
 	}
 
-	expectedError := "unexpected status code: 500"
+	expectedError := "giving up after 2 attempts (server): unexpected status code: 500"
 	if err.Error() != expectedError {
 		t.Errorf("Expected error '%s', got '%s'", expectedError, err.Error())
 	}
@@ -125,9 +129,9 @@ func TestTzktClient_GetDelegations_HTTPError(t *testing.T) {
 }
 
 func TestTzktClient_GetDelegations_NetworkError(t *testing.T) {
-	client := NewTzktClient("http://invalid-url-that-does-not-exist.com")
+	client := NewTzktClient("http://invalid-url-that-does-not-exist.com", WithRetryPolicy(RetryPolicy{MaxAttempts: 2}))
 
-	results, err := client.GetDelegations(0, "")
+	results, err := client.GetDelegations(context.Background(), 0, Start{})
 
 	if err == nil {
 		t.Error("Expected error, got nil")
@@ -148,7 +152,7 @@ func TestTzktClient_GetDelegations_InvalidJSON(t *testing.T) {
 
 	client := NewTzktClient(server.URL)
 
-	results, err := client.GetDelegations(0, "")
+	results, err := client.GetDelegations(context.Background(), 0, Start{})
 
 	if err == nil {
 		t.Error("Expected error, got nil")
@@ -162,33 +166,36 @@ func TestTzktClient_GetDelegations_InvalidJSON(t *testing.T) {
 func TestTzktClient_URLConstruction(t *testing.T) {
 	tests := []struct {
 		name          string
-		offset        int
-		timestamp     string
+		afterID       int
+		from          Start
 		expectedQuery string
 	}{
 		{
 			name:          "no parameters",
-			offset:        0,
-			timestamp:     "",
-			expectedQuery: "/v1/operations/delegations",
+			afterID:       0,
+			expectedQuery: "/v1/operations/delegations?sort.asc=id",
 		},
 		{
-			name:          "only offset",
-			offset:        10,
-			timestamp:     "",
-			expectedQuery: "/v1/operations/delegations?offset=10",
+			name:          "only cursor",
+			afterID:       10,
+			expectedQuery: "/v1/operations/delegations?id.gt=10&sort.asc=id",
 		},
 		{
 			name:          "only timestamp",
-			offset:        0,
-			timestamp:     "2023-01-01T00:00:00Z",
-			expectedQuery: "/v1/operations/delegations?timestamp.gt=2023-01-01T00%3A00%3A00Z",
+			afterID:       0,
+			from:          Start{Timestamp: "2023-01-01T00:00:00Z"},
+			expectedQuery: "/v1/operations/delegations?sort.asc=id&timestamp.ge=2023-01-01T00%3A00%3A00Z",
+		},
+		{
+			name:          "only level",
+			from:          Start{Level: 5000000},
+			expectedQuery: "/v1/operations/delegations?level.ge=5000000&sort.asc=id",
 		},
 		{
 			name:          "both parameters",
-			offset:        5,
-			timestamp:     "2023-01-01T00:00:00Z",
-			expectedQuery: "/v1/operations/delegations?offset=5&timestamp.gt=2023-01-01T00%3A00%3A00Z",
+			afterID:       5,
+			from:          Start{Timestamp: "2023-01-01T00:00:00Z"},
+			expectedQuery: "/v1/operations/delegations?id.gt=5&sort.asc=id&timestamp.ge=2023-01-01T00%3A00%3A00Z",
 		},
 	}
 
@@ -205,7 +212,7 @@ func TestTzktClient_URLConstruction(t *testing.T) {
 
 			testClient := NewTzktClient(server.URL + "/v1/operations/delegations")
 
-			_, err := testClient.GetDelegations(tt.offset, tt.timestamp)
+			_, err := testClient.GetDelegations(context.Background(), tt.afterID, tt.from)
 			if err != nil {
 				t.Errorf("Expected no error, got %v", err)
 			}
@@ -216,3 +223,299 @@ func TestTzktClient_URLConstruction(t *testing.T) {
 		})
 	}
 }
+
+func TestTzktClient_GetHead(t *testing.T) {
+	var capturedPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		capturedPath = r.URL.String()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(HeadResponse{Level: 5000000, Timestamp: "2024-01-01T00:00:00Z"})
+	}))
+	defer server.Close()
+
+	client := NewTzktClient(server.URL + "/v1/operations/delegations?limit=1000")
+
+	head, err := client.GetHead(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if capturedPath != "/v1/head" {
+		t.Errorf("Expected path '/v1/head', got '%s'", capturedPath)
+	}
+
+	if head.Level != 5000000 {
+		t.Errorf("Expected level 5000000, got %d", head.Level)
+	}
+}
+
+func TestTzktClient_GetHead_HTTPError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := NewTzktClient(server.URL + "/v1/operations/delegations")
+
+	head, err := client.GetHead(context.Background())
+	if err == nil {
+		t.Error("Expected error, got nil")
+	}
+
+	if head != nil {
+		t.Errorf("Expected nil head, got %v", head)
+	}
+}
+
+func TestTzktClient_GetBlocks(t *testing.T) {
+	var capturedPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		capturedPath = r.URL.String()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode([]BlockResponse{{Level: 100, Hash: "BLa"}, {Level: 101, Hash: "BLb"}})
+	}))
+	defer server.Close()
+
+	client := NewTzktClient(server.URL + "/v1/operations/delegations?limit=1000")
+
+	blocks, err := client.GetBlocks(context.Background(), 100, 102)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := "/v1/blocks?level.ge=100&level.le=102&limit=3&select=level%2Chash&sort.asc=level"
+	if capturedPath != expected {
+		t.Errorf("Expected path '%s', got '%s'", expected, capturedPath)
+	}
+
+	if len(*blocks) != 2 || (*blocks)[1].Hash != "BLb" {
+		t.Errorf("Unexpected blocks %+v", *blocks)
+	}
+}
+
+func TestTzktClient_GetProtocols(t *testing.T) {
+	var capturedPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		capturedPath = r.URL.String()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`[{"code":18,"hash":"PtParisBxoLz5gzMmn3d9WBQNoPSZakgnkMC2VNuQ3KXfUtUQeZ","firstLevel":5726209,"firstCycle":750,"firstCycleLevel":5726209,"lastLevel":5898240,"constants":{"blocksPerCycle":24576}}]`))
+	}))
+	defer server.Close()
+
+	client := NewTzktClient(server.URL + "/v1/operations/delegations?limit=1000")
+
+	protocols, err := client.GetProtocols(context.Background())
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := "/v1/protocols?limit=1000&sort.asc=firstLevel"
+	if capturedPath != expected {
+		t.Errorf("Expected path '%s', got '%s'", expected, capturedPath)
+	}
+
+	if len(*protocols) != 1 || (*protocols)[0].FirstCycle != 750 || (*protocols)[0].Constants.BlocksPerCycle != 24576 {
+		t.Errorf("Unexpected protocols %+v", *protocols)
+	}
+}
+
+func TestTzktClient_GetDelegationsAt(t *testing.T) {
+	var capturedQuery string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		capturedQuery = r.URL.String()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode([]DelegationResponse{{ID: 1, Level: 100}})
+	}))
+	defer server.Close()
+
+	client := NewTzktClient(server.URL + "/v1/operations/delegations?limit=1000")
+
+	delegations, err := client.GetDelegationsAt(context.Background(), 100)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := "/v1/operations/delegations?level=100&limit=1000&sort.asc=id"
+	if capturedQuery != expected {
+		t.Errorf("expected query '%s', got '%s'", expected, capturedQuery)
+	}
+	if len(*delegations) != 1 {
+		t.Errorf("Expected 1 delegation, got %d", len(*delegations))
+	}
+}
+
+func TestTzktClient_URLConstruction_KeepsBaseQuery(t *testing.T) {
+	var capturedQuery string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		capturedQuery = r.URL.String()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode([]DelegationResponse{})
+	}))
+	defer server.Close()
+
+	client := NewTzktClient(server.URL + "/v1/operations/delegations?limit=1000")
+
+	if _, err := client.GetDelegations(context.Background(), 42, Start{}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := "/v1/operations/delegations?id.gt=42&limit=1000&sort.asc=id"
+	if capturedQuery != expected {
+		t.Errorf("expected query '%s', got '%s'", expected, capturedQuery)
+	}
+}
+
+func TestTzktClient_GetDelegations_Cancelled(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := NewTzktClient(server.URL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	results, err := client.GetDelegations(ctx, 0, Start{})
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+
+	if results != nil {
+		t.Errorf("Expected nil results, got %v", results)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the request to be aborted promptly, took %s", elapsed)
+	}
+}
+
+func TestTzktClient_GetDelegations_CancelledWhileBackingOff(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := NewTzktClient(server.URL, WithRetryPolicy(RetryPolicy{MaxAttempts: 5, BaseDelay: time.Minute, MaxDelay: time.Minute}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := client.GetDelegations(ctx, 0, Start{})
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the backoff to be interrupted, took %s", elapsed)
+	}
+}
+
+func TestTzktClient_RequestTimeoutIsRetried(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			// stall the first attempt past the per-request timeout
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode([]DelegationResponse{{ID: 1}})
+	}))
+	defer server.Close()
+
+	client := NewTzktClient(server.URL,
+		WithRequestTimeout(50*time.Millisecond),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}),
+	)
+
+	results, err := client.GetDelegations(context.Background(), 0, Start{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(*results) != 1 {
+		t.Errorf("Expected 1 result, got %d", len(*results))
+	}
+}
+
+func TestTzktClient_GetDelegations_DecodesOperationDetails(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[
+			{
+				"type": "delegation",
+				"id": 1098907648,
+				"level": 109,
+				"timestamp": "2018-06-30T19:30:27Z",
+				"block": "BLwRUPupdm5XJGeRUYp3njDsaamsM9hv4PegJrNpKpNeUiGKuMr",
+				"hash": "oo2jBSaJ2Lh7iTaH6yRsg3wLkH2LpnCDhx4mVvxNrwFAKWr1oWP",
+				"counter": 23,
+				"sender": {"alias": "Sender", "address": "tz1Wit2PqodvPeuRRhdQXmkrtU8e8bRYZecd"},
+				"gasLimit": 0,
+				"gasUsed": 100,
+				"bakerFee": 50,
+				"amount": 25079312620,
+				"prevDelegate": {"alias": "Old Baker", "address": "tz1KqTpEZ7Yob7QbPE4Hy4Wo8fHG8LhKxZSx"},
+				"newDelegate": {"alias": "New Baker", "address": "tz1Wit2PqodvPeuRRhdQXmkrtU8e8bRYZecd"},
+				"status": "applied"
+			},
+			{
+				"type": "delegation",
+				"id": 1098907649,
+				"level": 110,
+				"timestamp": "2018-06-30T19:31:27Z",
+				"sender": {"address": "tz1KqTpEZ7Yob7QbPE4Hy4Wo8fHG8LhKxZSx"},
+				"amount": 1000,
+				"status": "failed"
+			}
+		]`))
+	}))
+	defer server.Close()
+
+	client := NewTzktClient(server.URL)
+
+	results, err := client.GetDelegations(context.Background(), 0, Start{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	first := (*results)[0]
+	if first.Hash != "oo2jBSaJ2Lh7iTaH6yRsg3wLkH2LpnCDhx4mVvxNrwFAKWr1oWP" {
+		t.Errorf("Unexpected hash %s", first.Hash)
+	}
+	if first.Block != "BLwRUPupdm5XJGeRUYp3njDsaamsM9hv4PegJrNpKpNeUiGKuMr" {
+		t.Errorf("Unexpected block %s", first.Block)
+	}
+	if first.Counter != 23 || first.GasUsed != 100 || first.BakerFee != 50 || first.Status != "applied" {
+		t.Errorf("Unexpected operation details %+v", first)
+	}
+	if first.NewDelegate == nil || first.NewDelegate.Address != "tz1Wit2PqodvPeuRRhdQXmkrtU8e8bRYZecd" || first.NewDelegate.Alias != "New Baker" {
+		t.Errorf("Unexpected new delegate %+v", first.NewDelegate)
+	}
+	if first.PrevDelegate == nil || first.PrevDelegate.Address != "tz1KqTpEZ7Yob7QbPE4Hy4Wo8fHG8LhKxZSx" {
+		t.Errorf("Unexpected previous delegate %+v", first.PrevDelegate)
+	}
+
+	second := (*results)[1]
+	if second.NewDelegate != nil || second.PrevDelegate != nil {
+		t.Errorf("Expected no delegates, got %+v and %+v", second.PrevDelegate, second.NewDelegate)
+	}
+	if second.Status != "failed" {
+		t.Errorf("Expected status failed, got %s", second.Status)
+	}
+}
@@ -0,0 +1,157 @@
+// Package tzkttest provides a fake TzKT events hub for tests. It speaks enough of the SignalR JSON
+// protocol for a client to handshake, subscribe to operations and receive events.
+package tzkttest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+const recordSeparator = 0x1e
+
+// Hub is a fake TzKT /v1/ws endpoint. Events are only sent to clients that subscribed to
+// operations.
+type Hub struct {
+	server   *httptest.Server
+	upgrader websocket.Upgrader
+
+	mu            sync.Mutex
+	state         int
+	subscribers   map[*websocket.Conn]bool
+	subscriptions chan struct{}
+}
+
+type message struct {
+	Type         int    `json:"type"`
+	Target       string `json:"target,omitempty"`
+	Arguments    []any  `json:"arguments,omitempty"`
+	InvocationID string `json:"invocationId,omitempty"`
+}
+
+// NewHub starts a hub that reports state as its current level.
+func NewHub(state int) *Hub {
+	h := &Hub{
+		state:         state,
+		subscribers:   map[*websocket.Conn]bool{},
+		subscriptions: make(chan struct{}, 16),
+	}
+	h.server = httptest.NewServer(http.HandlerFunc(h.serve))
+	return h
+}
+
+// URL returns the websocket URL of the hub.
+func (h *Hub) URL() string {
+	return "ws" + strings.TrimPrefix(h.server.URL, "http") + "/v1/ws"
+}
+
+// Subscribed receives a value every time a client subscribes to operations.
+func (h *Hub) Subscribed() <-chan struct{} {
+	return h.subscriptions
+}
+
+// Publish sends the operations of a new block at level to every subscriber.
+func (h *Hub) Publish(level int, operations any) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.state = level
+	h.broadcast(map[string]any{"type": 1, "state": level, "data": operations})
+}
+
+// Reorg tells every subscriber that the chain was rolled back to level.
+func (h *Hub) Reorg(level int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.state = level
+	h.broadcast(map[string]any{"type": 2, "state": level})
+}
+
+// Disconnect drops every client, as a TzKT restart would.
+func (h *Hub) Disconnect() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for conn := range h.subscribers {
+		conn.Close()
+	}
+	h.subscribers = map[*websocket.Conn]bool{}
+}
+
+func (h *Hub) Close() {
+	h.Disconnect()
+	h.server.Close()
+}
+
+func (h *Hub) broadcast(event any) {
+	for conn := range h.subscribers {
+		if err := write(conn, message{Type: 1, Target: "operations", Arguments: []any{event}}); err != nil {
+			conn.Close()
+			delete(h.subscribers, conn)
+		}
+	}
+}
+
+func (h *Hub) serve(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// handshake
+	if _, _, err := conn.ReadMessage(); err != nil {
+		return
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, []byte{'{', '}', recordSeparator}); err != nil {
+		return
+	}
+
+	for {
+		_, payload, err := conn.ReadMessage()
+		if err != nil {
+			h.mu.Lock()
+			delete(h.subscribers, conn)
+			h.mu.Unlock()
+			return
+		}
+
+		for _, record := range bytes.Split(payload, []byte{recordSeparator}) {
+			var msg message
+			if len(record) == 0 || json.Unmarshal(record, &msg) != nil {
+				continue
+			}
+			if msg.Type == 1 && msg.Target == "SubscribeToOperations" {
+				h.subscribe(conn, msg.InvocationID)
+			}
+		}
+	}
+}
+
+func (h *Hub) subscribe(conn *websocket.Conn, invocationID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	write(conn, message{Type: 3, InvocationID: invocationID})
+	write(conn, message{Type: 1, Target: "operations", Arguments: []any{map[string]any{"type": 0, "state": h.state}}})
+	h.subscribers[conn] = true
+
+	select {
+	case h.subscriptions <- struct{}{}:
+	default:
+	}
+}
+
+func write(conn *websocket.Conn, msg message) error {
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(websocket.TextMessage, append(payload, recordSeparator))
+}
@@ -1,17 +1,51 @@
 package mocks
 
 import (
+	"context"
+
 	"tezos-delegation-service/internal/transport"
 )
 
 type MockTzktClient struct {
 	Delegations *[]transport.DelegationResponse
+	Head        *transport.HeadResponse
+	Blocks      *[]transport.BlockResponse
+	Protocols   *[]transport.ProtocolResponse
 	Err         error
+	URL         string
+	// ProtocolRequests counts the protocol table fetches.
+	ProtocolRequests int
 }
 
-func (m *MockTzktClient) GetDelegations(offset int, fromTimestamp string) (*[]transport.DelegationResponse, error) {
+func (m *MockTzktClient) GetDelegations(ctx context.Context, afterID int, from transport.Start) (*[]transport.DelegationResponse, error) {
 	if m.Err != nil {
 		return nil, m.Err
 	}
 	return m.Delegations, nil
 }
+
+func (m *MockTzktClient) Source() string {
+	return m.URL
+}
+
+func (m *MockTzktClient) GetHead(ctx context.Context) (*transport.HeadResponse, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return m.Head, nil
+}
+
+func (m *MockTzktClient) GetBlocks(ctx context.Context, fromLevel, toLevel int) (*[]transport.BlockResponse, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return m.Blocks, nil
+}
+
+func (m *MockTzktClient) GetProtocols(ctx context.Context) (*[]transport.ProtocolResponse, error) {
+	m.ProtocolRequests++
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return m.Protocols, nil
+}
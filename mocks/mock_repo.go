@@ -1,30 +1,147 @@
 package mocks
 
 import (
+	"context"
+
 	"tezos-delegation-service/internal/model"
 )
 
 type MockDelegationRepository struct {
 	Delegations []model.Delegation
 	Latest      model.Delegation
+	Checkpoint  model.SyncCheckpoint
 	Err         error
 	SaveErr     error
+	Saved       []model.Delegation
+	Blocks      map[int]string
+	RolledBack  []int
+	Current     model.CurrentDelegation
+	Bakers      []model.BakerStats
+	Stats       []model.DelegationStats
+	Protocols   model.Protocols
+	// BakerQueries counts the baker aggregates queried, to tell cached ones apart.
+	BakerQueries int
+}
+
+func (m *MockDelegationRepository) GetDelegations(ctx context.Context, query model.DelegationQuery) ([]model.Delegation, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	return m.Delegations, nil
 }
 
-func (m *MockDelegationRepository) GetDelegations(year int, offset int) ([]model.Delegation, error) {
+func (m *MockDelegationRepository) GetDelegatorDelegations(ctx context.Context, delegator string, query model.DelegationQuery) ([]model.Delegation, error) {
 	if m.Err != nil {
 		return nil, m.Err
 	}
 	return m.Delegations, nil
 }
 
-func (m *MockDelegationRepository) GetLatestDelegation(year int) (model.Delegation, error) {
+func (m *MockDelegationRepository) GetCurrentDelegation(ctx context.Context, delegator string) (model.CurrentDelegation, error) {
+	return m.Current, m.Err
+}
+
+func (m *MockDelegationRepository) GetBakers(ctx context.Context, query model.BakerQuery) ([]model.BakerStats, error) {
+	m.BakerQueries++
+	return m.Bakers, m.Err
+}
+
+func (m *MockDelegationRepository) GetBaker(ctx context.Context, baker string) (model.BakerStats, error) {
+	m.BakerQueries++
+	if len(m.Bakers) > 0 {
+		return m.Bakers[0], m.Err
+	}
+	return model.BakerStats{Baker: baker}, m.Err
+}
+
+func (m *MockDelegationRepository) GetBakerDelegators(ctx context.Context, baker string, limit int, offset int) ([]model.CurrentDelegation, error) {
+	return []model.CurrentDelegation{m.Current}, m.Err
+}
+
+func (m *MockDelegationRepository) GetDelegationStats(ctx context.Context, query model.StatsQuery) ([]model.DelegationStats, error) {
+	return m.Stats, m.Err
+}
+
+func (m *MockDelegationRepository) GetProtocols(ctx context.Context) (model.Protocols, error) {
+	return m.Protocols, m.Err
+}
+
+func (m *MockDelegationRepository) SaveProtocols(ctx context.Context, protocols model.Protocols) error {
+	if m.SaveErr != nil {
+		return m.SaveErr
+	}
+	m.Protocols = protocols
+	return nil
+}
+
+func (m *MockDelegationRepository) CountDelegations(ctx context.Context, query model.DelegationQuery) (int, error) {
+	if m.Err != nil {
+		return 0, m.Err
+	}
+	return len(m.Delegations), nil
+}
+
+func (m *MockDelegationRepository) GetLatestDelegation(ctx context.Context, year int) (model.Delegation, error) {
 	if m.Err != nil {
 		return model.Delegation{}, m.Err
 	}
 	return m.Latest, nil
 }
 
-func (m *MockDelegationRepository) SaveBatch(delegations []model.Delegation) error {
+func (m *MockDelegationRepository) GetLastDelegation(ctx context.Context) (model.Delegation, error) {
+	if m.Err != nil {
+		return model.Delegation{}, m.Err
+	}
+	return m.Latest, nil
+}
+
+func (m *MockDelegationRepository) SaveBatch(ctx context.Context, delegations []model.Delegation) error {
 	return m.SaveErr
 }
+
+func (m *MockDelegationRepository) SaveBatchWithCheckpoint(ctx context.Context, delegations []model.Delegation, checkpoint model.SyncCheckpoint) error {
+	if m.SaveErr != nil {
+		return m.SaveErr
+	}
+	m.Saved = append(m.Saved, delegations...)
+	if len(delegations) > 0 || checkpoint.LastID > 0 {
+		m.Checkpoint = checkpoint
+	}
+	return nil
+}
+
+func (m *MockDelegationRepository) GetCheckpoint(ctx context.Context, name string) (model.SyncCheckpoint, error) {
+	if m.Err != nil {
+		return model.SyncCheckpoint{}, m.Err
+	}
+	return m.Checkpoint, nil
+}
+
+func (m *MockDelegationRepository) DeleteCheckpoint(ctx context.Context, name string) error {
+	if m.Err != nil {
+		return m.Err
+	}
+	m.Checkpoint = model.SyncCheckpoint{}
+	return nil
+}
+
+func (m *MockDelegationRepository) GetBlockHashes(ctx context.Context, fromLevel, toLevel int) (map[int]string, error) {
+	if m.Err != nil {
+		return nil, m.Err
+	}
+	hashes := map[int]string{}
+	for level, hash := range m.Blocks {
+		if level >= fromLevel && level <= toLevel {
+			hashes[level] = hash
+		}
+	}
+	return hashes, nil
+}
+
+func (m *MockDelegationRepository) RollbackFrom(ctx context.Context, level int, checkpoint string) error {
+	if m.SaveErr != nil {
+		return m.SaveErr
+	}
+	m.RolledBack = append(m.RolledBack, level)
+	return nil
+}
@@ -1,23 +1,108 @@
 package mocks
 
-import "tezos-delegation-service/internal/model"
+import (
+	"context"
+
+	"tezos-delegation-service/internal/model"
+	"tezos-delegation-service/internal/transport"
+)
 
 type MockXtzService struct {
 	Delegations []model.Delegation
+	HeadLevel   int
 	Err         error
+	Ctx         context.Context
+	Query       model.DelegationQuery
+	Fork        int
+	Total       int
+	Delegator   string
+	Current     model.CurrentDelegation
+	Bakers      []model.BakerStats
+	BakerQuery  model.BakerQuery
+	Baker       string
+	Delegators  []model.CurrentDelegation
+	Stats       []model.DelegationStats
+	StatsQuery  model.StatsQuery
 }
 
-func (m *MockXtzService) GetDelegations(year int, offset int) ([]model.Delegation, error) {
+func (m *MockXtzService) GetDelegations(ctx context.Context, query model.DelegationQuery) ([]model.Delegation, error) {
+	m.Ctx = ctx
+	m.Query = query
 	return m.Delegations, m.Err
 }
 
-func (m *MockXtzService) StoreDelegations(offset int, startFrom string) ([]model.Delegation, error) {
+func (m *MockXtzService) GetDelegatorDelegations(ctx context.Context, delegator string, query model.DelegationQuery) ([]model.Delegation, error) {
+	m.Ctx = ctx
+	m.Delegator = delegator
+	m.Query = query
 	return m.Delegations, m.Err
 }
 
-func (m *MockXtzService) GetLatestDelegation() (model.Delegation, error) {
+func (m *MockXtzService) CountDelegations(ctx context.Context, query model.DelegationQuery) (int, error) {
+	return m.Total, m.Err
+}
+
+func (m *MockXtzService) GetCurrentDelegation(ctx context.Context, delegator string) (model.CurrentDelegation, error) {
+	m.Ctx = ctx
+	m.Delegator = delegator
+	return m.Current, m.Err
+}
+
+func (m *MockXtzService) GetBakers(ctx context.Context, query model.BakerQuery) ([]model.BakerStats, error) {
+	m.Ctx = ctx
+	m.BakerQuery = query
+	return m.Bakers, m.Err
+}
+
+func (m *MockXtzService) GetBaker(ctx context.Context, baker string) (model.BakerStats, error) {
+	m.Ctx = ctx
+	if len(m.Bakers) > 0 {
+		return m.Bakers[0], m.Err
+	}
+	return model.BakerStats{Baker: baker}, m.Err
+}
+
+func (m *MockXtzService) GetBakerDelegators(ctx context.Context, baker string, limit int, offset int) ([]model.CurrentDelegation, error) {
+	m.Ctx = ctx
+	m.Baker = baker
+	m.Query = model.DelegationQuery{Limit: limit, Offset: offset}
+	return m.Delegators, m.Err
+}
+
+func (m *MockXtzService) GetDelegationStats(ctx context.Context, query model.StatsQuery) ([]model.DelegationStats, error) {
+	m.Ctx = ctx
+	m.StatsQuery = query
+	return m.Stats, m.Err
+}
+
+func (m *MockXtzService) StoreDelegations(ctx context.Context, checkpoint string, afterID int, from transport.Start) ([]model.Delegation, model.SyncCheckpoint, error) {
+	reached := model.SyncCheckpoint{Name: checkpoint, FromLevel: from.Level}
+	if len(m.Delegations) > 0 {
+		last := m.Delegations[len(m.Delegations)-1]
+		reached.LastID, reached.Level, reached.Timestamp = last.ID, last.Level, last.Timestamp
+	}
+	return m.Delegations, reached, m.Err
+}
+
+func (m *MockXtzService) StoreStreamed(ctx context.Context, checkpoint string, results []transport.DelegationResponse) ([]model.Delegation, error) {
+	return m.Delegations, m.Err
+}
+
+func (m *MockXtzService) GetLatestDelegation(ctx context.Context) (model.Delegation, error) {
 	if len(m.Delegations) > 0 {
 		return m.Delegations[0], m.Err
 	}
 	return model.Delegation{}, m.Err
 }
+
+func (m *MockXtzService) GetHeadLevel(ctx context.Context) (int, error) {
+	return m.HeadLevel, m.Err
+}
+
+func (m *MockXtzService) Reconcile(ctx context.Context, checkpoint string, depth int) (int, error) {
+	return m.Fork, m.Err
+}
+
+func (m *MockXtzService) FindFork(ctx context.Context, checkpoint string, depth int) (int, error) {
+	return m.Fork, m.Err
+}